@@ -1,26 +1,14 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"io"
 	"log"
-	"net/http"
-	"strconv"
-	"strings"
-	"time"
+	"os"
+	"rpksi/pkg/rpksi"
 )
 
-type void struct{}
-
-var member void
-var adminClient = &http.Client{Timeout: 10 * time.Second}
 var deleteCmd = &cobra.Command{
 	Use:     "delete",
 	Aliases: []string{"del"},
@@ -44,225 +32,146 @@ List segments for all topics sorted by size:
 Narrow down results to a specific topic:
 	> rpksi list -at aTopic
 
+Narrow down results to a single partition of the topic:
+	> rpksi list -at aTopic -p 0
+
 View the event at offset 4500 for a topic:
 	> rpk topic consume -n 1 aTopic -o 4500
 
-Do a dry-run that deletes segments containing only those offsets that are older than 4500:
-	> rpksi del -o 4500 --dry-run
+Write a plan for deleting the segments containing only those offsets that are older than 4500:
+	> rpksi del -t aTopic -o 4500 --plan-out plan.json
 
-Delete the segments found above:
-	> rpksi del -o 4500
-`,
-	Run: func(cmd *cobra.Command, args []string) {
-		topicFlag, _ := cmd.Flags().GetString("topic")
-		if len(topicFlag) == 0 {
-			log.Fatalln("Topic required (--topic or -t)")
-		}
-		dryrunFlag, _ := cmd.Flags().GetBool("dry-run")
-		olderThanFlag, _ := cmd.Flags().GetString("older-than")
-		offsetFlag, _ := cmd.Flags().GetInt64("offset")
-		var olderThanTimestamp int64
-		if len(olderThanFlag) > 0 {
-			var err error
-			olderThanTimestamp, err = strconv.ParseInt(olderThanFlag, 10, 64)
-			if err != nil {
-				log.Fatalln(err)
-			}
-		}
-		adminApi := viper.GetString("admin")
-		s3Api := viper.GetString("s3")
-		bucket := viper.GetString("bucket")
-		accessKeyID := viper.GetString("accessKey")
-		secretAccessKey := viper.GetString("secretKey")
-		useSSL := viper.GetBool("useSSL")
+The plan lists each segment and object key, and the old manifest versions (etags) along with
+the new manifests. After it has been reviewed, apply exactly that plan. Apply refuses to run
+if any of the manifests or segments have changed since the plan was made, and stops with the
+manifests written so far if one changes while it runs, rather than writing a plan nobody reviewed:
+	> rpksi del --apply plan.json
 
-		isDeleting := false
-		namespace := "kafka"
-		manifestFileName := "manifest.json"
-		protocol := "http"
-		if useSSL {
-			protocol = "https"
-		}
+Or delete the segments without a separate plan:
+	> rpksi del -t aTopic -o 4500
+
+Only delete segments of partition 0:
+	> rpksi del -t aTopic -o 4500 -p 0
+
+Delete segments whose records are all older than 90 days (see 'rpksi help list' for time formats):
+	> rpksi del -t aTopic --older-than 90d
+
+Unlike list, delete --between only matches segments whose records are all within the range, so
+segments straddling either end are kept. Dates are midnight UTC, this deletes the segments holding
+records of the 27th and the 28th only:
+	> rpksi del -t aTopic --between 2022-05-27,2022-05-29
 
-		manifests := make(map[string]Manifest)
-		segments := make(map[string]RowSegment)
-		manifestEntries := make(map[string]RowSegment)
-		urls := make(map[string]void)
+A deletion runs in phases: the current manifests are backed up, the new manifests are
+uploaded and verified, local state is synchronized, and only then are the segments deleted.
+Progress is recorded in a journal file (--journal). If a deletion is interrupted, either
+finish it or restore the old manifests (only possible before segments are deleted):
+	> rpksi del --resume
+	> rpksi del --rollback
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		journalFlag, _ := cmd.Flags().GetString("journal")
+		resumeFlag, _ := cmd.Flags().GetBool("resume")
+		rollbackFlag, _ := cmd.Flags().GetBool("rollback")
 
-		s3Client, err := minio.New(s3Api, &minio.Options{
-			Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
-			Secure: useSSL,
-		})
+		client, err := newClient()
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 
-		// loop through top-level objects (hash prefix names)
-		for object := range s3Client.ListObjects(context.Background(), bucket, minio.ListObjectsOptions{Recursive: true}) {
-			if object.Err != nil {
-				fmt.Println(object.Err)
-				return
+		if resumeFlag || rollbackFlag {
+			journal, err := rpksi.OpenJournal(journalFlag)
+			if err != nil {
+				log.Fatalln(err)
 			}
-			if strings.Split(object.Key, "/")[2] != namespace {
-				topic := strings.Split(object.Key, "/")[2]
-				// collect remote segment paths
-				objectKey := strings.Split(object.Key, "/")[2] + ":" + strings.Split(object.Key, "/")[4]
-				objectKey = strings.TrimRight(objectKey, ".1")
-				segments[objectKey] = RowSegment{
-					ObjectPath: object.Key,
-					TopicName:  topic,
-				}
+			if rollbackFlag {
+				fmt.Println("Rolling back deletion started at", journal.Started)
+				err = client.RollbackDeletion(context.Background(), journal)
 			} else {
-				if strings.Contains(object.Key, "/"+manifestFileName) {
-					// read remote manifest and populate manifestEntries map
-					reader, err := s3Client.GetObject(context.Background(), bucket, object.Key, minio.GetObjectOptions{})
-					if err != nil {
-						log.Fatalln(err)
-					}
-					defer func(reader *minio.Object) {
-						err := reader.Close()
-						if err != nil {
-							log.Fatalln(err)
-						}
-					}(reader)
-
-					data, _ := io.ReadAll(reader)
-
-					var manifest Manifest
-
-					err = json.Unmarshal(data, &manifest)
-					if err != nil {
-						log.Fatalln(err)
-					}
-
-					// continue only if this manifest relates to the given topic
-					if len(topicFlag) > 0 && topicFlag != manifest.Topic {
-						continue
-					}
-
-					// add manifest to manifests
-					manifests[object.Key] = manifest
-
-					// filter manifest entries based on given filters, then add to manifestEntries map
-					for key, val := range manifest.Segments {
-						manifestEntries[manifest.Topic+":"+key] = RowSegment{
-							Partition:            manifest.Partition,
-							SegmentName:          key,
-							SegmentSize:          byteCountBinary(val.SizeBytes),
-							SegmentOldOffsetDate: val.BaseTimestamp,
-							SegmentNewOffsetDate: val.MaxTimestamp,
-							SegmentOldOffsetId:   val.BaseOffset,
-							SegmentNewOffsetId:   val.CommittedOffset,
-							TopicName:            manifest.Topic,
-						}
-					}
-				}
+				fmt.Println("Resuming deletion started at", journal.Started, "after phase", journal.Phase)
+				err = client.RunDeletion(context.Background(), journal)
+			}
+			if err != nil {
+				log.Fatalln(err)
 			}
+			fmt.Println("Complete.")
+			return
 		}
 
-		// expand segments data based on manifestEntries
-		for sk, sv := range segments {
-			for mek, mev := range manifestEntries {
-				if sk == mek {
-					mev.ObjectPath = sv.ObjectPath
-					mev.Delete = false
-
-					// filter segments
-					if len(olderThanFlag) > 0 && olderThanTimestamp > int64(mev.SegmentNewOffsetDate) {
-						mev.Delete = true
-						isDeleting = true
-					}
-					if offsetFlag != -1 && offsetFlag > int64(mev.SegmentNewOffsetId) {
-						mev.Delete = true
-						isDeleting = true
-					}
-
-					segments[sk] = mev
-				}
-			}
+		applyFlag, _ := cmd.Flags().GetString("apply")
+		planOutFlag, _ := cmd.Flags().GetString("plan-out")
+		if dryrunFlag, _ := cmd.Flags().GetBool("dry-run"); dryrunFlag {
+			planOutFlag = "-"
 		}
 
-		if isDeleting {
-			if dryrunFlag {
-				fmt.Println("Dry run (no changes being made)...")
+		var plan *rpksi.DeletionPlan
+		if len(applyFlag) > 0 {
+			plan, err = rpksi.ReadPlanFile(applyFlag)
+			if err != nil {
+				log.Fatalln(err)
 			}
-
-			fmt.Println("Deleting segments...")
-			for _, v := range segments {
-				if v.Delete {
-					fmt.Println("  delete " + v.ObjectPath)
-					if !dryrunFlag {
-						err = s3Client.RemoveObject(context.Background(), bucket, v.ObjectPath, minio.RemoveObjectOptions{GovernanceBypass: true})
-						if err != nil {
-							log.Fatalln(err)
-						}
-					}
-				}
+			if err := client.CheckPlan(context.Background(), plan); err != nil {
+				log.Fatalf("%s\nrefusing to apply %s, make a new plan with --plan-out\n", err, applyFlag)
 			}
-
-			fmt.Println("Synchronizing local state...")
-			for _, v := range segments {
-				url := fmt.Sprintf("%s://%s/v1/shadow_indexing/sync_local_state/%s/%d", protocol, adminApi, v.TopicName, v.Partition)
-				urls[url] = member
+		} else {
+			topicFlag, _ := cmd.Flags().GetString("topic")
+			if len(topicFlag) == 0 {
+				log.Fatalln("Topic required (--topic or -t)")
 			}
-			for url := range urls {
-				if dryrunFlag {
-					fmt.Println("  POST request to " + url)
-				} else {
-					request, err := http.NewRequest("POST", url, new(bytes.Buffer))
-					response, err := adminClient.Do(request)
-					if err != nil {
-						panic(err)
-					}
-					defer response.Body.Close()
-				}
+			plan, err = client.PlanDeletion(context.Background(), segmentFilter(cmd))
+			if err != nil {
+				log.Fatalln(err)
 			}
-
-			fmt.Println("Determining if manifest segments should be removed...")
-			for mk, mv := range manifests {
-				for msk, msv := range mv.Segments {
-					for _, sv := range segments {
-						if sv.Delete && sv.SegmentOldOffsetId == msv.BaseOffset && sv.SegmentNewOffsetId == msv.CommittedOffset {
-							// found a manifest segment that needs to be deleted
-							mv.NeedsRewrite = true
-							fmt.Println("  removing segment", msk)
-							delete(mv.Segments, msk)
-						}
-					}
-				}
-				manifests[mk] = mv
+			if len(plan.Segments) == 0 {
+				fmt.Println("no segments found (try another topic, increasing the offset, or a more recent timestamp")
+				return
 			}
+		}
 
-			fmt.Println("Writing new manifest...")
-			for k, manifest := range manifests {
-				if manifest.NeedsRewrite {
-					json, err := json.Marshal(manifest)
-					if err != nil {
-						log.Fatalln(err)
-					}
-					if dryrunFlag {
-						fmt.Println(string(json))
-					} else {
-						reader := strings.NewReader(string(json))
-						uploadInfo, err := s3Client.PutObject(context.Background(), bucket, k, reader, -1, minio.PutObjectOptions{})
-						if err != nil {
-							log.Fatalln(err)
-							return
-						}
-						fmt.Println("  uploaded manifest " + uploadInfo.Key)
-					}
+		if len(planOutFlag) > 0 {
+			if planOutFlag == "-" {
+				if err := rpksi.WritePlan(os.Stdout, plan); err != nil {
+					log.Fatalln(err)
 				}
+				return
+			}
+			f, err := os.Create(planOutFlag)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if err := rpksi.WritePlan(f, plan); err != nil {
+				log.Fatalln(err)
 			}
+			if err := f.Close(); err != nil {
+				log.Fatalln(err)
+			}
+			var size uint64
+			for _, segment := range plan.Segments {
+				size += segment.SegmentSize
+			}
+			fmt.Printf("Planned deletion of %d segments (%s) from %d manifests, written to %s\n", len(plan.Segments), byteCountBinary(size), len(plan.Manifests), planOutFlag)
+			fmt.Printf("Review the plan, then run it with: rpksi del --apply %s\n", planOutFlag)
+			return
+		}
 
-			if dryrunFlag {
-				fmt.Println("Dry run complete")
-			} else {
-				fmt.Println("Complete.")
+		// refuse to start over an interrupted deletion
+		if journal, err := rpksi.OpenJournal(journalFlag); err == nil && !journal.Done() {
+			log.Fatalf("an interrupted deletion was found in %s (phase %s), use --resume or --rollback\n", journalFlag, journal.Phase)
+		}
+		journal, err := rpksi.NewJournal(journalFlag, plan)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		// a reviewed plan is applied as it is, or not at all
+		if len(applyFlag) > 0 {
+			if err := journal.SetReviewed(); err != nil {
+				log.Fatalln(err)
 			}
-		} else {
-			fmt.Println("no segments found (try another topic, increasing the offset, or a more recent timestamp")
 		}
+		if err := client.RunDeletion(context.Background(), journal); err != nil {
+			log.Fatalf("%s\nthe deletion was interrupted, resume it with --resume or undo it with --rollback (journal: %s)\n", err, journalFlag)
+		}
+		fmt.Println("Complete.")
 	},
 }
 
@@ -271,7 +180,16 @@ func init() {
 
 	deleteCmd.Flags().BoolP("all", "a", false, "ignored on delete (included for switching between list and delete easily)")
 	deleteCmd.Flags().StringP("topic", "t", "", "filter by topic")
-	deleteCmd.Flags().StringP("older-than", "", "", "show segments w/ offsets older than timestamp (exclusive)")
-	deleteCmd.Flags().Int64P("offset", "o", -1, "show segments containing an offset range that is lower than the given offset")
-	deleteCmd.Flags().Bool("dry-run", false, "dry run, prints each task output to console")
+	deleteCmd.Flags().IntSliceP("partition", "p", nil, "filter by partition")
+	deleteCmd.Flags().StringP("older-than", "", "", "delete segments w/ records older than time (exclusive): "+timeSyntax)
+	deleteCmd.Flags().String("newer-than", "", "delete segments w/ records newer than time (exclusive): "+timeSyntax)
+	deleteCmd.Flags().String("between", "", "delete segments w/ all records within <from>,<to> (inclusive)")
+	deleteCmd.Flags().Int64P("offset", "o", 0, "show segments containing an offset range that is lower than the given offset")
+	deleteCmd.Flags().Bool("dry-run", false, "dry run, prints the deletion plan to the console")
+	deleteCmd.Flags().MarkDeprecated("dry-run", "use --plan-out - instead")
+	deleteCmd.Flags().String("plan-out", "", "write the deletion plan to this file (- for stdout) instead of deleting")
+	deleteCmd.Flags().String("apply", "", "apply a plan written with --plan-out, refusing if the bucket has changed since")
+	deleteCmd.Flags().String("journal", "rpksi-delete.journal", "file recording the progress of the deletion")
+	deleteCmd.Flags().Bool("resume", false, "resume the interrupted deletion recorded in the journal")
+	deleteCmd.Flags().Bool("rollback", false, "restore the manifests backed up by the interrupted deletion recorded in the journal")
 }
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"os"
+	"rpksi/pkg/rpksi"
+	"rpksi/pkg/segment"
+)
+
+// dumpRecord is a record as written by dump, with keys, values and header
+// values encoded as strings (nil for null).
+type dumpRecord struct {
+	Topic     string       `json:"topic"`
+	Partition int          `json:"partition"`
+	Offset    int64        `json:"offset"`
+	Timestamp int64        `json:"timestamp"`
+	Key       *string      `json:"key"`
+	Value     *string      `json:"value"`
+	Headers   []dumpHeader `json:"headers"`
+}
+
+type dumpHeader struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+}
+
+// recordEncoder encodes record bytes as utf8 or base64 strings.
+type recordEncoder func([]byte) *string
+
+func newRecordEncoder(encoding string) (recordEncoder, error) {
+	switch encoding {
+	case "utf8":
+		return func(b []byte) *string {
+			if b == nil {
+				return nil
+			}
+			s := string(b)
+			return &s
+		}, nil
+	case "base64":
+		return func(b []byte) *string {
+			if b == nil {
+				return nil
+			}
+			s := base64.StdEncoding.EncodeToString(b)
+			return &s
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q (utf8|base64)", encoding)
+}
+
+func (e recordEncoder) record(row rpksi.RowSegment, record segment.Record) dumpRecord {
+	out := dumpRecord{
+		Topic:     row.TopicName,
+		Partition: row.Partition,
+		Offset:    record.Offset,
+		Timestamp: record.Timestamp,
+		Key:       e(record.Key),
+		Value:     e(record.Value),
+		Headers:   []dumpHeader{},
+	}
+	for _, header := range record.Headers {
+		out.Headers = append(out.Headers, dumpHeader{Key: header.Key, Value: e(header.Value)})
+	}
+	return out
+}
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Writes archived records as JSON lines",
+	Long: `Writes archived records as JSON lines.
+
+The segments covering the requested offsets or times are found through the partition
+manifests and read from the bucket, so records can be read after they were removed from
+the cluster. Each record is written as a line with its topic, partition, offset, timestamp,
+key, value and headers.
+
+Dump offsets 4000 to 4500 of partition 0:
+	> rpksi dump --topic aTopic --partition 0 --from-offset 4000 --to-offset 4500
+
+Dump the records of all partitions produced within a time range (see 'rpksi help list' for
+time formats) to a file. Dates are midnight UTC, so this is the 27th:
+	> rpksi dump --topic aTopic --between 2022-05-27,2022-05-28 --out records.jsonl
+
+Keys and values are written as utf8 strings by default, use --encoding base64 for binary data.
+
+With --decode, keys and values in the Confluent wire format (Avro, Protobuf or JSON with a
+schema id) are written as JSON, using the schemaRegistry of the config file or the exported
+schemas of schemaDir. Keys and values that fail to decode are written as they are, with a
+warning on stderr:
+	> rpksi dump --topic aTopic --partition 0 --from-offset 4000 --to-offset 4500 --decode
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		topicFlag, _ := cmd.Flags().GetString("topic")
+		if len(topicFlag) == 0 {
+			log.Fatalln("Topic required (--topic or -t)")
+		}
+		encodingFlag, _ := cmd.Flags().GetString("encoding")
+		encode, err := newRecordEncoder(encodingFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		outFlag, _ := cmd.Flags().GetString("out")
+		decodeFlag, _ := cmd.Flags().GetBool("decode")
+		filter := segmentFilter(cmd)
+		var decoder rpksi.RecordDecoder
+		if decodeFlag {
+			if decoder, err = newSchemaDecoder(); err != nil {
+				log.Fatalln(err)
+			}
+		}
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		var out io.Writer = os.Stdout
+		if len(outFlag) > 0 {
+			f, err := os.Create(outFlag)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+			out = f
+		}
+		w := bufio.NewWriter(out)
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		var count int
+		ctx := context.Background()
+		err = client.ReadRecords(ctx, filter, func(row rpksi.RowSegment, record segment.Record) error {
+			if decoder != nil {
+				var err error
+				if record, err = decoder.DecodeRecord(ctx, record); err != nil {
+					log.Printf("warning: %s: %v, keeping the raw bytes\n", row.ObjectPath, err)
+				}
+			}
+			count++
+			return enc.Encode(encode.record(row, record))
+		})
+		if flushErr := w.Flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(outFlag) > 0 {
+			fmt.Printf("%d records written to %s\n", count, outFlag)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dumpCmd)
+
+	dumpCmd.Flags().StringP("topic", "t", "", "topic to dump")
+	dumpCmd.Flags().IntSliceP("partition", "p", nil, "filter by partition")
+	dumpCmd.Flags().Uint64("from-offset", 0, "dump records from the given offset (inclusive)")
+	dumpCmd.Flags().Uint64("to-offset", 0, "dump records up to the given offset (inclusive)")
+	dumpCmd.Flags().String("between", "", "dump records with timestamps within <from>,<to> (inclusive)")
+	dumpCmd.Flags().String("encoding", "utf8", "encoding of keys, values and header values (utf8|base64)")
+	dumpCmd.Flags().String("out", "", "write the records to this file instead of stdout")
+	dumpCmd.Flags().Bool("decode", false, "decode wire format keys and values with the schema registry")
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log"
+	"rpksi/pkg/export"
+	"rpksi/pkg/storage"
+)
+
+// newExportStore opens the bucket records are exported to, with the
+// endpoint and credentials of the archive bucket. Tests can replace it.
+var newExportStore = func(bucket string) (storage.ObjectStore, error) {
+	return storage.NewMinioStore(
+		viper.GetString("s3"),
+		viper.GetString("accessKey"),
+		viper.GetString("secretKey"),
+		viper.GetBool("useSSL"),
+		bucket,
+	)
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Writes archived records as Parquet, Avro or JSON lines files",
+	Long: `Writes archived records as Parquet, Avro or JSON lines files.
+
+The archived batches of the topic are decoded and their records are written to a local
+directory (--out-dir) or to another bucket of the same endpoint (--dest-bucket), one file per
+partition and day (UTC) of the record timestamps. Files are laid out as
+	topic=<topic>/partition=<partition>/date=<yyyy-mm-dd>/<topic>-<partition>-<first offset>.<format>
+which query engines such as Spark, Trino or DuckDB read as a partitioned table. Every format
+has the columns offset, timestamp (milliseconds since the epoch), key, value (bytes, or null)
+and headers (a list of key and value). JSON lines files encode bytes as base64.
+
+Export a day of records of every partition as Parquet files (dates are midnight UTC, so this is
+the 27th):
+	> rpksi export --format parquet --topic aTopic --between 2022-05-27,2022-05-28 --out-dir ./lake
+
+Export offsets 4000 to 4500 of partition 0 as Avro files to the lake bucket:
+	> rpksi export --format avro --topic aTopic --partition 0 --from-offset 4000 --to-offset 4500 \
+		--dest-bucket lake --dest-prefix redpanda
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		topicFlag, _ := cmd.Flags().GetString("topic")
+		if len(topicFlag) == 0 {
+			log.Fatalln("Topic required (--topic or -t)")
+		}
+		formatFlag, _ := cmd.Flags().GetString("format")
+		format, err := export.ParseFormat(formatFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		outDirFlag, _ := cmd.Flags().GetString("out-dir")
+		destBucketFlag, _ := cmd.Flags().GetString("dest-bucket")
+		destPrefixFlag, _ := cmd.Flags().GetString("dest-prefix")
+		if (len(outDirFlag) == 0) == (len(destBucketFlag) == 0) {
+			log.Fatalln("One destination required (--out-dir or --dest-bucket)")
+		}
+		filter := segmentFilter(cmd)
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		var sink export.Sink = export.DirSink{Dir: outDirFlag}
+		destination := outDirFlag
+		if len(destBucketFlag) > 0 {
+			store, err := newExportStore(destBucketFlag)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			sink = export.BucketSink{Store: store, Prefix: destPrefixFlag}
+			destination = destBucketFlag + "/" + destPrefixFlag
+		}
+
+		fmt.Printf("Exporting %s to %s...\n", topicFlag, destination)
+		files, err := client.Export(context.Background(), filter, sink, format)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		var records int
+		for _, file := range files {
+			records += file.Records
+		}
+		fmt.Printf("%d records written to %d files\n", records, len(files))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().String("format", "parquet", "file format (parquet|avro|jsonl)")
+	exportCmd.Flags().StringP("topic", "t", "", "topic to export")
+	exportCmd.Flags().IntSliceP("partition", "p", nil, "filter by partition")
+	exportCmd.Flags().Uint64("from-offset", 0, "export records from the given offset (inclusive)")
+	exportCmd.Flags().Uint64("to-offset", 0, "export records up to the given offset (inclusive)")
+	exportCmd.Flags().String("between", "", "export records with timestamps within <from>,<to> (inclusive)")
+	exportCmd.Flags().String("out-dir", "", "write the files under this local directory")
+	exportCmd.Flags().String("dest-bucket", "", "write the files to this bucket")
+	exportCmd.Flags().String("dest-prefix", "", "write the files under this prefix of the destination bucket")
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"rpksi/pkg/rpksi"
+)
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Checks the consistency of partition manifests",
+	Long: `Checks the consistency of partition manifests.
+
+Every partition manifest is read and its segments, sorted by base offset, are checked for:
+	gap        offsets missing between consecutive segments
+	overlap    offsets held by more than one segment
+	offsets    a committed_offset beyond the last_offset of the manifest, or before the
+	           base_offset of the segment
+	timestamp  a max_timestamp before the base_timestamp of the segment (error), or a
+	           segment starting before the max_timestamp of the previous one (warning)
+	name       a segment name disagreeing with its base_offset, committed_offset, size or
+	           segment_term
+	missing    a segment without an object in the bucket
+
+Only the manifests are read, segment objects are not downloaded (see 'rpksi verify' for
+that). The command exits with status 1 when errors are found, or when warnings are found
+with --fail-on warning, so it can run as a scheduled job.
+
+Check every manifest in the bucket:
+	> rpksi fsck
+
+Check the manifests of partitions 0 and 1 of a topic and print the findings as json:
+	> rpksi fsck --topic aTopic --partition 0,1 --output json
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		topicFlag, _ := cmd.Flags().GetString("topic")
+		partitionFlag, _ := cmd.Flags().GetIntSlice("partition")
+		failOnFlag, _ := cmd.Flags().GetString("fail-on")
+		if failOnFlag != string(rpksi.SeverityError) && failOnFlag != string(rpksi.SeverityWarning) {
+			log.Fatalf("unknown severity %q for --fail-on (expected error or warning)\n", failOnFlag)
+		}
+		format, err := outputFormat(cmd)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		result, err := client.CheckManifests(context.Background(), rpksi.Filter{Topic: topicFlag, Partitions: partitionFlag})
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		errorCount, warningCount := result.Count(rpksi.SeverityError), result.Count(rpksi.SeverityWarning)
+		switch format {
+		case outputJSON, outputYAML:
+			err = printStructured(format, result)
+		case outputCSV, outputMarkdown:
+			err = printRecords(format, result.Findings)
+		default:
+			if len(result.Findings) > 0 {
+				t := table.NewWriter()
+				t.SetStyle(table.StyleLight)
+				t.SetOutputMirror(os.Stdout)
+				t.AppendHeader(table.Row{"Severity", "Check", "Topic", "Partition", "Segment", "Message"})
+				for _, f := range result.Findings {
+					t.AppendRow(table.Row{f.Severity, f.Check, f.TopicName, f.Partition, f.SegmentName, f.Message})
+				}
+				t.Render()
+			}
+			fmt.Printf("%d manifests, %d segments checked: %d errors, %d warnings\n", result.Manifests, result.Segments, errorCount, warningCount)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if errorCount > 0 || (failOnFlag == string(rpksi.SeverityWarning) && warningCount > 0) {
+			osExit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fsckCmd)
+
+	fsckCmd.Flags().StringP("topic", "t", "", "check a single topic")
+	fsckCmd.Flags().IntSliceP("partition", "p", nil, "filter by partition")
+	fsckCmd.Flags().String("fail-on", string(rpksi.SeverityError), "lowest severity that makes the command exit with status 1 (error|warning)")
+	addOutputFlag(fsckCmd)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"rpksi/pkg/rpksi"
+	"rpksi/pkg/storage"
+	"testing"
+)
+
+// runCommand runs rpksi with args against store and returns the status it
+// exited with.
+func runCommand(t *testing.T, store storage.ObjectStore, args ...string) int {
+	t.Helper()
+	status := 0
+	oldStore, oldExit := newObjectStore, osExit
+	newObjectStore = func() (storage.ObjectStore, error) { return store, nil }
+	osExit = func(code int) { status = code }
+	defer func() { newObjectStore, osExit = oldStore, oldExit }()
+
+	rootCmd.SetArgs(args)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func putTestManifest(t *testing.T, store storage.ObjectStore, m rpksi.Manifest) {
+	t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "a0000000/meta/kafka/events/0_7/" + rpksi.ManifestFileName
+	if err := store.PutObject(context.Background(), key, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFsckStatus(t *testing.T) {
+	store := storage.NewMemoryStore()
+	m := rpksi.Manifest{
+		Version:    1,
+		Namespace:  rpksi.Namespace,
+		Topic:      "events",
+		Partition:  0,
+		Revision:   7,
+		LastOffset: 9,
+		Segments: map[string]rpksi.Segment{
+			"0-1-v1.log": {SizeBytes: 7, BaseOffset: 0, CommittedOffset: 9, MaxTimestamp: 9, ArchiverTerm: 1},
+		},
+	}
+	putTestManifest(t, store, m)
+	data := []byte("segment")
+	if err := store.PutObject(context.Background(), "a0000000/kafka/events/0_7/0-1-v1.log.1", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if status := runCommand(t, store, "fsck", "--output", "json"); status != 0 {
+		t.Errorf("consistent manifest: exit status %d, want 0", status)
+	}
+
+	// a segment without an object
+	m.Segments["10-1-v1.log"] = rpksi.Segment{SizeBytes: 7, BaseOffset: 10, CommittedOffset: 19, BaseTimestamp: 10, MaxTimestamp: 19, ArchiverTerm: 1}
+	m.LastOffset = 19
+	putTestManifest(t, store, m)
+	if status := runCommand(t, store, "fsck", "--output", "json"); status != 1 {
+		t.Errorf("missing segment object: exit status %d, want 1", status)
+	}
+}
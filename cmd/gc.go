@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"rpksi/pkg/rpksi"
+	"strings"
+	"time"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Finds and deletes objects no partition manifest references",
+	Long: `Finds and deletes objects no partition manifest references.
+
+Objects under the partition prefixes of topics are reported as orphans when no partition
+manifest references them:
+	segment   a segment missing from the manifest of its partition (left behind by
+	          retention, compaction or an interrupted deletion)
+	term      a segment listed in the manifest but uploaded under another term than the
+	          manifest records (a failed or retried upload)
+	index     the .index file of an orphaned segment
+	tx        the .tx file of an orphaned segment
+	revision  any object of a partition revision without a manifest (a deleted or
+	          recreated topic)
+
+Segments in the replaced list of a manifest are kept, Redpanda deletes them itself. Partitions
+whose older segments were moved to spillover manifests are skipped entirely and listed as
+such, since rpksi does not read spillover manifests.
+
+The archiver uploads a segment before adding it to the manifest, so objects modified within
+--min-age (24h by default, a duration like 30m, 12h, 7d or 1w) are never reported. Manifests
+and topic manifests are never reported, nor are objects whose names are not those of segments.
+
+List the orphans of every topic and their total size:
+	> rpksi gc
+
+List the orphans of a topic as json:
+	> rpksi gc --topic aTopic --output json
+
+Delete the orphans of a topic after confirming, or without asking. The manifests are read
+again before deleting, and objects they reference by then are kept:
+	> rpksi gc --topic aTopic --delete
+	> rpksi gc --topic aTopic --delete --yes
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		topicFlag, _ := cmd.Flags().GetString("topic")
+		minAgeFlag, _ := cmd.Flags().GetString("min-age")
+		deleteFlag, _ := cmd.Flags().GetBool("delete")
+		yesFlag, _ := cmd.Flags().GetBool("yes")
+		format, err := outputFormat(cmd)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		minAge, err := rpksi.ParseDuration(minAgeFlag)
+		if err != nil {
+			log.Fatalln("invalid --min-age:", err)
+		}
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		report, err := client.FindOrphans(context.Background(), topicFlag, minAge)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		orphans := report.Orphans
+
+		switch format {
+		case outputJSON, outputYAML:
+			err = printStructured(format, report)
+		case outputCSV, outputMarkdown:
+			err = printRecords(format, orphans)
+		default:
+			printOrphans(orphans)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, key := range report.Skipped {
+			fmt.Fprintf(os.Stderr, "skipped %s: the partition has spillover manifests\n", key)
+		}
+		if !deleteFlag || len(orphans) == 0 {
+			return
+		}
+
+		if !yesFlag {
+			// stdout may hold the orphans as json or yaml, keep it clean
+			fmt.Fprintf(os.Stderr, "Delete %d objects (%s)? [y/N] ", len(orphans), byteCountBinary(orphansSize(orphans)))
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+				fmt.Fprintln(os.Stderr, "Aborted.")
+				return
+			}
+		}
+		if format != outputTable {
+			client.Log = os.Stderr
+		}
+		fmt.Fprintln(client.Log, "Deleting orphans...")
+		deleted, err := client.DeleteOrphans(context.Background(), orphans)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Fprintf(client.Log, "Deleted %d objects (%s).\n", len(deleted), byteCountBinary(orphansSize(deleted)))
+	},
+}
+
+// printOrphans prints each orphan followed by their count and size per
+// reason.
+func printOrphans(orphans []rpksi.Orphan) {
+	if len(orphans) == 0 {
+		fmt.Println("no orphaned objects found")
+		return
+	}
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Object", "Reason", "Size", "Last Modified"})
+	counts := make(map[rpksi.OrphanReason]int)
+	sizes := make(map[rpksi.OrphanReason]uint64)
+	for _, orphan := range orphans {
+		counts[orphan.Reason]++
+		sizes[orphan.Reason] += uint64(orphan.Size)
+		t.AppendRow(table.Row{orphan.Key, orphan.Reason, byteCountBinary(uint64(orphan.Size)), orphan.LastModified.UTC().Format(time.RFC3339)})
+	}
+	t.Render()
+	for _, reason := range []rpksi.OrphanReason{rpksi.OrphanSegment, rpksi.OrphanTerm, rpksi.OrphanIndex, rpksi.OrphanTx, rpksi.OrphanRevision} {
+		if counts[reason] > 0 {
+			fmt.Printf("%s: %d objects, %s\n", reason, counts[reason], byteCountBinary(sizes[reason]))
+		}
+	}
+	fmt.Printf("total: %d objects, %s\n", len(orphans), byteCountBinary(orphansSize(orphans)))
+}
+
+func orphansSize(orphans []rpksi.Orphan) uint64 {
+	var size uint64
+	for _, orphan := range orphans {
+		size += uint64(orphan.Size)
+	}
+	return size
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+
+	gcCmd.Flags().StringP("topic", "t", "", "only look for orphans of a topic")
+	gcCmd.Flags().String("min-age", "24h", "ignore objects modified more recently than this duration (30m, 12h, 7d, 1w)")
+	gcCmd.Flags().Bool("delete", false, "delete the orphans after confirmation")
+	gcCmd.Flags().BoolP("yes", "y", false, "delete without asking for confirmation")
+	addOutputFlag(gcCmd)
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"log"
 	"os"
 )
 
@@ -19,8 +20,22 @@ Get config based on what is found in the config file and possibly default values
 Get config, but override the kafka value with a flag:
 	> rpksi get-config --kafka redpanda-0:9092
 
+Get config as yaml (other formats are json, csv and markdown):
+	> rpksi get-config --output yaml
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
+		format, err := outputFormat(cmd)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if format == outputJSON || format == outputYAML {
+			if err := printStructured(format, viper.AllSettings()); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+
 		t := table.NewWriter()
 		t.SetStyle(table.StyleLight)
 		t.Style().Options.SeparateRows = true
@@ -32,10 +47,12 @@ Get config, but override the kafka value with a flag:
 		for k, v := range viper.AllSettings() {
 			t.AppendRow(table.Row{k, v})
 		}
-		t.Render()
+		renderFlat(t, format)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(getConfigCmd)
+
+	addOutputFlag(getConfigCmd)
 }
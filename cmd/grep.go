@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"regexp"
+	"rpksi/pkg/rpksi"
+	"strings"
+)
+
+// grepHit is a record found by grep.
+type grepHit struct {
+	Partition   int     `json:"partition" yaml:"partition"`
+	Offset      int64   `json:"offset" yaml:"offset"`
+	Timestamp   int64   `json:"timestamp" yaml:"timestamp"`
+	Key         *string `json:"key" yaml:"key"`
+	SegmentName string  `json:"segment_name" yaml:"segment_name"`
+	ObjectPath  string  `json:"object_path" yaml:"object_path"`
+}
+
+var grepCmd = &cobra.Command{
+	Use:   "grep",
+	Short: "Searches archived records by key, header or value",
+	Long: `Searches archived records by key, header or value.
+
+The segments of the topic are read from the bucket, several at a time (--parallel), and the
+records matching all of the given criteria are printed with their partition, offset and
+timestamp. Narrow down the segments to read with --partition, --between or the offset flags.
+
+Did a record with key order-1234 land in the topic in May?
+	> rpksi grep --topic aTopic --key order-1234 --between 2022-05-01,2022-06-01
+
+Keys can also be matched with a regular expression, and records by header (repeat the flag to
+require several headers) and by a substring of the value:
+	> rpksi grep --topic aTopic --key-regex '^order-12' --header source=web
+	> rpksi grep --topic aTopic --value-contains '"status":"failed"' --between 7d,now
+
+With --decode, keys and values in the Confluent wire format are decoded to JSON with the
+schema registry before they are matched. Keys and values that fail to decode are matched as
+raw bytes, with a warning on stderr:
+	> rpksi grep --topic aTopic --decode --value-contains '"status":"FAILED"'
+
+Print the hits as json (other formats are yaml, csv and markdown):
+	> rpksi grep --topic aTopic --key order-1234 --output json
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		topicFlag, _ := cmd.Flags().GetString("topic")
+		if len(topicFlag) == 0 {
+			log.Fatalln("Topic required (--topic or -t)")
+		}
+		matcher, err := recordMatcher(cmd)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if decodeFlag, _ := cmd.Flags().GetBool("decode"); decodeFlag {
+			if matcher.Decoder, err = newSchemaDecoder(); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		parallelFlag, _ := cmd.Flags().GetInt("parallel")
+		encodingFlag, _ := cmd.Flags().GetString("encoding")
+		encode, err := newRecordEncoder(encodingFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		format, err := outputFormat(cmd)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		filter := segmentFilter(cmd)
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if format == outputTable {
+			fmt.Printf("%-10s %-20s %-14s %s\n", "PARTITION", "OFFSET", "TIMESTAMP", "KEY")
+		} else {
+			// keep decoding warnings out of the structured output
+			client.Log = os.Stderr
+		}
+		hits := []grepHit{}
+		err = client.SearchRecords(context.Background(), filter, matcher, parallelFlag, func(hit rpksi.RecordHit) error {
+			h := grepHit{
+				Partition:   hit.Segment.Partition,
+				Offset:      hit.Record.Offset,
+				Timestamp:   hit.Record.Timestamp,
+				Key:         encode(hit.Record.Key),
+				SegmentName: hit.Segment.SegmentName,
+				ObjectPath:  hit.Segment.ObjectPath,
+			}
+			if format == outputTable {
+				key := "<null>"
+				if h.Key != nil {
+					key = *h.Key
+				}
+				fmt.Printf("%-10d %-20d %-14d %s\n", h.Partition, h.Offset, h.Timestamp, key)
+			}
+			hits = append(hits, h)
+			return nil
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if format != outputTable {
+			if err := printRecords(format, hits); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+		fmt.Printf("%d matching records\n", len(hits))
+	},
+}
+
+// recordMatcher builds the record matcher of grep from the command's flags.
+func recordMatcher(cmd *cobra.Command) (rpksi.RecordMatcher, error) {
+	var matcher rpksi.RecordMatcher
+	if cmd.Flags().Changed("key") {
+		keyFlag, _ := cmd.Flags().GetString("key")
+		matcher.Key = []byte(keyFlag)
+	}
+	if keyRegexFlag, _ := cmd.Flags().GetString("key-regex"); len(keyRegexFlag) > 0 {
+		re, err := regexp.Compile(keyRegexFlag)
+		if err != nil {
+			return matcher, fmt.Errorf("invalid --key-regex: %w", err)
+		}
+		matcher.KeyRegexp = re
+	}
+	headerFlag, _ := cmd.Flags().GetStringArray("header")
+	for _, header := range headerFlag {
+		key, value, ok := strings.Cut(header, "=")
+		if !ok {
+			return matcher, fmt.Errorf("invalid --header %q, expected key=value", header)
+		}
+		if matcher.Headers == nil {
+			matcher.Headers = make(map[string]string)
+		}
+		matcher.Headers[key] = value
+	}
+	if cmd.Flags().Changed("value-contains") {
+		valueContainsFlag, _ := cmd.Flags().GetString("value-contains")
+		matcher.ValueContains = []byte(valueContainsFlag)
+	}
+	return matcher, nil
+}
+
+func init() {
+	rootCmd.AddCommand(grepCmd)
+
+	grepCmd.Flags().StringP("topic", "t", "", "topic to search")
+	grepCmd.Flags().IntSliceP("partition", "p", nil, "filter by partition")
+	grepCmd.Flags().String("key", "", "match records with exactly this key")
+	grepCmd.Flags().String("key-regex", "", "match records whose key matches this regular expression")
+	grepCmd.Flags().StringArray("header", nil, "match records with this header, as key=value (repeatable)")
+	grepCmd.Flags().String("value-contains", "", "match records whose value contains this string")
+	grepCmd.Flags().String("between", "", "search records with timestamps within <from>,<to> (inclusive)")
+	grepCmd.Flags().Uint64("from-offset", 0, "search records from the given offset (inclusive)")
+	grepCmd.Flags().Uint64("to-offset", 0, "search records up to the given offset (inclusive)")
+	grepCmd.Flags().Int("parallel", 4, "number of segments to scan at a time")
+	grepCmd.Flags().String("encoding", "utf8", "encoding of printed keys (utf8|base64)")
+	grepCmd.Flags().Bool("decode", false, "decode wire format keys and values with the schema registry before matching")
+	addOutputFlag(grepCmd)
+}
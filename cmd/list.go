@@ -2,21 +2,20 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jedib0t/go-pretty/v6/text"
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"io"
 	"log"
+	"math"
 	"os"
-	"strconv"
-	"strings"
+	"rpksi/pkg/rpksi"
+	"time"
 )
 
+// timeSyntax sums up the times accepted by rpksi.ParseTime for flag help.
+const timeSyntax = "30d, 12h or 1h30m ago, 2022-05-28, 2022-05-28T03:15:00Z, or a unix epoch in ms (1653707348951) or s (1653707348s)"
+
 func byteCountBinary(b uint64) string {
 	const unit = 1024
 	if b < unit {
@@ -30,6 +29,60 @@ func byteCountBinary(b uint64) string {
 	return fmt.Sprintf("%.2f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
 }
 
+// segmentFilter builds the segment filter shared by list and delete from the
+// command's flags.
+func segmentFilter(cmd *cobra.Command) rpksi.Filter {
+	topicFlag, _ := cmd.Flags().GetString("topic")
+	partitionFlag, _ := cmd.Flags().GetIntSlice("partition")
+	olderThanFlag, _ := cmd.Flags().GetString("older-than")
+	newerThanFlag, _ := cmd.Flags().GetString("newer-than")
+	betweenFlag, _ := cmd.Flags().GetString("between")
+	filter := rpksi.Filter{Topic: topicFlag, Partitions: partitionFlag}
+	now := time.Now()
+	var err error
+	if len(olderThanFlag) > 0 {
+		filter.OlderThan, err = rpksi.ParseTime(olderThanFlag, now)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if len(newerThanFlag) > 0 {
+		filter.NewerThan, err = rpksi.ParseTime(newerThanFlag, now)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if len(betweenFlag) > 0 {
+		between, err := rpksi.ParseTimeRange(betweenFlag, now)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		filter.Between = &between
+	}
+	if cmd.Flags().Changed("offset") {
+		offsetFlag, _ := cmd.Flags().GetInt64("offset")
+		if offsetFlag < 0 {
+			log.Fatalf("--offset %d is negative\n", offsetFlag)
+		}
+		filter.Offset = &offsetFlag
+	}
+	if cmd.Flags().Changed("contains-offset") {
+		containsOffsetFlag, _ := cmd.Flags().GetUint64("contains-offset")
+		filter.Offsets = &rpksi.OffsetRange{From: containsOffsetFlag, To: containsOffsetFlag}
+	} else if cmd.Flags().Changed("from-offset") || cmd.Flags().Changed("to-offset") {
+		fromOffsetFlag, _ := cmd.Flags().GetUint64("from-offset")
+		toOffsetFlag := uint64(math.MaxUint64)
+		if cmd.Flags().Changed("to-offset") {
+			toOffsetFlag, _ = cmd.Flags().GetUint64("to-offset")
+		}
+		if fromOffsetFlag > toOffsetFlag {
+			log.Fatalf("--from-offset %d is after --to-offset %d\n", fromOffsetFlag, toOffsetFlag)
+		}
+		filter.Offsets = &rpksi.OffsetRange{From: fromOffsetFlag, To: toOffsetFlag}
+	}
+	return filter
+}
+
 var listCmd = &cobra.Command{
 	Use:     "list",
 	Aliases: []string{"ls"},
@@ -45,38 +98,47 @@ For more details add --all:
 Filter by topic with --topic:
 	> rpksi list --topic aTopic
 
-Find the storage size and segment count for aTopic containing offsets that are older than the given unix timestamp:
-	> rpksi list --older-than 3546080250619836472
+Filter by topic and partitions with --partition (repeat the flag or separate partitions with commas):
+	> rpksi list -a --topic aTopic --partition 0,2
+
+Find the storage size and segment count for each topic of segments only holding records older than 30 days:
+	> rpksi list --older-than 30d
+
+List segment details for segments with records older than the given time, filtering by topic:
+	> rpksi list -a --topic aTopic --older-than 2022-05-28T03:15:00Z
 
-List segment details for segments with offsets older than the given unix timestamp, filtering by topic:
-	> rpksi list -a --topic aTopic --older-than 3546080250619836472
+Times can be a duration before now (30d, 12h, 1w, 1h30m), an RFC3339 timestamp or date, or a unix
+epoch in milliseconds (1653707348951 or 1653707348951ms) or in seconds (1653707348s). Durations
+are in weeks, days, hours and minutes; seconds are an epoch, so 30s is 30 seconds after 1970.
+--older-than and --newer-than
+match segments whose records are all older or newer than the time (exclusive), and --between
+matches segments holding any record within an inclusive range:
+	> rpksi list -a --newer-than 12h
+	> rpksi list -a --between 2022-05-27,2022-05-29
+
+A date is midnight UTC at the start of that day, so the range above covers the 27th and the 28th,
+and 2022-05-27,2022-05-28 would only reach the first millisecond of the 28th.
+
+Find the segments holding offsets 4000 to 4500, or the one segment holding offset 4500, along with
+the keys of their objects (either bound of the range can be left out):
+	> rpksi list -a --topic aTopic --partition 0 --from-offset 4000 --to-offset 4500
+	> rpksi list -a --topic aTopic --partition 0 --contains-offset 4500
+
+Print segment details as json (sizes in bytes), other formats are yaml, csv and markdown:
+	> rpksi list -a --output json
+
+Topics whose number of archived partitions differs from the partition count of their
+topic manifest are flagged with a warning (see 'rpksi topic describe').
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		allFlag, _ := cmd.Flags().GetBool("all")
-		topicFlag, _ := cmd.Flags().GetString("topic")
-		olderThanFlag, _ := cmd.Flags().GetString("older-than")
-		offsetFlag, _ := cmd.Flags().GetInt64("offset")
-		var olderThanTimestamp int64
-		if len(olderThanFlag) > 0 {
-			var err error
-			olderThanTimestamp, err = strconv.ParseInt(olderThanFlag, 10, 64)
-			if err != nil {
-				log.Fatalln(err)
-			}
+		filter := segmentFilter(cmd)
+		// investigating offsets usually leads to the objects, so show their keys
+		showObjects := filter.Offsets != nil
+		format, err := outputFormat(cmd)
+		if err != nil {
+			log.Fatalln(err)
 		}
-		s3Api := viper.GetString("s3")
-		bucket := viper.GetString("bucket")
-		accessKeyID := viper.GetString("accessKey")
-		secretAccessKey := viper.GetString("secretKey")
-		useSSL := viper.GetBool("useSSL")
-
-		//manifestHashPrefixRegexp := regexp.MustCompile("([a-z]|\\d){1,3}0{6,8}")
-		manifestFileName := "manifest.json"
-		namespace := "kafka"
-
-		//objectPaths := make(map[string]string)
-		segments := make(map[string]RowSegment)
-		topics := make(map[string]RowTopic)
 
 		t := table.NewWriter()
 		t.SetStyle(table.StyleLight)
@@ -87,132 +149,101 @@ List segment details for segments with offsets older than the given unix timesta
 			t.SetColumnConfigs([]table.ColumnConfig{
 				{Number: 1, AutoMerge: true},
 				{Number: 2, AutoMerge: true},
-				{Number: 3, Align: text.AlignCenter, AlignFooter: text.AlignCenter, AlignHeader: text.AlignCenter},
+				{Number: 3, AutoMerge: true, Align: text.AlignCenter, AlignFooter: text.AlignCenter, AlignHeader: text.AlignCenter},
 				{Number: 4, Align: text.AlignCenter, AlignFooter: text.AlignCenter, AlignHeader: text.AlignCenter},
 				{Number: 5, Align: text.AlignCenter, AlignFooter: text.AlignCenter, AlignHeader: text.AlignCenter},
 				{Number: 6, Align: text.AlignCenter, AlignFooter: text.AlignCenter, AlignHeader: text.AlignCenter},
 				{Number: 7, Align: text.AlignCenter, AlignFooter: text.AlignCenter, AlignHeader: text.AlignCenter},
 				{Number: 8, Align: text.AlignCenter, AlignFooter: text.AlignCenter, AlignHeader: text.AlignCenter},
+				{Number: 9, Align: text.AlignCenter, AlignFooter: text.AlignCenter, AlignHeader: text.AlignCenter},
 			})
-			t.AppendHeader(table.Row{"Topic", "Topic", "Remote Segment", "Remote Segment", "Remote Segment", "Remote Segment", "Remote Segment", "Remote Segment"}, rowConfigAutoMerge)
-			t.AppendHeader(table.Row{"Name", "Size", "Name", "Size", "Oldest Offset", "Oldest Offset", "Newest Offset", "Newest Offset"}, rowConfigAutoMerge)
-			t.AppendHeader(table.Row{"Name", "Size", "", "", "#", "Date", "#", "Date"})
+			headers := []table.Row{
+				{"Topic", "Topic", "Partition", "Remote Segment", "Remote Segment", "Remote Segment", "Remote Segment", "Remote Segment", "Remote Segment"},
+				{"Name", "Size", "Partition", "Name", "Size", "Oldest Offset", "Oldest Offset", "Newest Offset", "Newest Offset"},
+				{"Name", "Size", "Partition", "", "", "#", "Date", "#", "Date"},
+			}
+			if showObjects {
+				headers[0] = append(headers[0], "Object")
+				headers[1] = append(headers[1], "Object")
+				headers[2] = append(headers[2], "")
+			}
+			t.AppendHeader(headers[0], rowConfigAutoMerge)
+			t.AppendHeader(headers[1], rowConfigAutoMerge)
+			t.AppendHeader(headers[2])
 			t.SortBy([]table.SortBy{
 				{Number: 1, Mode: table.Asc},
-				{Number: 5, Mode: table.AscNumeric},
+				{Number: 3, Mode: table.AscNumeric},
+				{Number: 6, Mode: table.AscNumeric},
 			})
 		} else {
-			t.AppendHeader(table.Row{"Topic", "Size", "Remote Segment Count", "Base Remote Offset", "Newest Remote Offset"})
+			t.AppendHeader(table.Row{"Topic", "Size", "Partitions", "Remote Segment Count", "Base Remote Offset", "Newest Remote Offset"})
 			t.SortBy([]table.SortBy{
 				{Name: "Topic", Mode: table.Asc},
 			})
 		}
 
-		s3Client, err := minio.New(s3Api, &minio.Options{
-			Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
-			Secure: useSSL,
-		})
+		client, err := newClient()
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 
-		// loop through top-level objects (hash prefix names)
-		for object := range s3Client.ListObjects(context.Background(), bucket, minio.ListObjectsOptions{Recursive: true}) {
-			if object.Err != nil {
-				panic(object.Err)
+		var topics []rpksi.RowTopic
+		var segments []rpksi.RowSegment
+		if allFlag {
+			topics, segments, err = client.ListTopicsAndSegments(context.Background(), filter)
+		} else {
+			topics, err = client.ListTopics(context.Background(), filter)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if format != outputTable {
+			if allFlag {
+				err = printRecords(format, segments)
+			} else {
+				err = printRecords(format, topics)
 			}
-			if strings.Split(object.Key, "/")[2] == namespace {
-				// read manifest to list remote segments
-				if strings.Contains(object.Key, "/"+manifestFileName) {
-					reader, err := s3Client.GetObject(context.Background(), bucket, object.Key, minio.GetObjectOptions{})
-					if err != nil {
-						log.Fatalln(err)
-					}
-					defer func(reader *minio.Object) {
-						err := reader.Close()
-						if err != nil {
-							log.Fatalln(err)
-						}
-					}(reader)
-
-					data, _ := io.ReadAll(reader)
-
-					var manifest Manifest
-					var totalSizeBytes uint64
-					var baseOffset uint64
-
-					err = json.Unmarshal(data, &manifest)
-					if err != nil {
-						log.Fatalln(err)
-					}
-
-					// topic filter
-					if len(topicFlag) > 0 && topicFlag != manifest.Topic {
-						continue
-					}
-
-					var passingSegmentCount int
-					for key, val := range manifest.Segments {
-						//fmt.Println(key, val.BaseOffset, val.CommittedOffset, val.DeltaOffset)
-						if len(olderThanFlag) > 0 && olderThanTimestamp <= int64(val.MaxTimestamp) {
-							continue
-						}
-						if offsetFlag != -1 && offsetFlag <= int64(val.CommittedOffset) {
-							continue
-						}
-						passingSegmentCount++
-
-						// RowTopic values
-						if val.BaseOffset < baseOffset {
-							baseOffset = val.BaseOffset
-						}
-						totalSizeBytes += val.SizeBytes
-
-						// RowSegment values
-						// TODO find segmentObject.key
-						segments[manifest.Topic+":"+key] = RowSegment{
-							//ObjectName:           segmentObject.Key,
-							TopicName:            manifest.Topic,
-							SegmentName:          key,
-							SegmentSize:          byteCountBinary(val.SizeBytes),
-							SegmentOldOffsetDate: val.BaseTimestamp,
-							SegmentNewOffsetDate: val.MaxTimestamp,
-							SegmentOldOffsetId:   val.BaseOffset,
-							SegmentNewOffsetId:   val.CommittedOffset,
-						}
-					}
-
-					topics[manifest.Topic] = RowTopic{
-						TopicName:          manifest.Topic,
-						TopicSize:          byteCountBinary(totalSizeBytes),
-						SegmentCount:       passingSegmentCount,
-						SegmentOldOffsetId: baseOffset,
-						SegmentNewOffsetId: manifest.LastOffset,
-					}
-				}
+			if err != nil {
+				log.Fatalln(err)
 			}
+			return
 		}
 
 		if allFlag {
+			topicsByName := make(map[string]rpksi.RowTopic)
+			for _, topic := range topics {
+				topicsByName[topic.TopicName] = topic
+			}
 			for _, segment := range segments {
-				topic := topics[segment.TopicName]
-				t.AppendRow(table.Row{
+				topic := topicsByName[segment.TopicName]
+				row := table.Row{
 					topic.TopicName,
-					topic.TopicSize,
+					byteCountBinary(topic.TopicSize),
+					segment.Partition,
 					segment.SegmentName,
-					segment.SegmentSize,
+					byteCountBinary(segment.SegmentSize),
 					segment.SegmentOldOffsetId,
 					segment.SegmentOldOffsetDate,
 					segment.SegmentNewOffsetId,
 					segment.SegmentNewOffsetDate,
-				})
+				}
+				if showObjects {
+					row = append(row, segment.ObjectPath)
+				}
+				t.AppendRow(row)
 			}
 		} else {
 			for _, topic := range topics {
+				partitions := fmt.Sprint(topic.PartitionCount)
+				if topic.PartitionMismatch {
+					partitions = fmt.Sprintf("%d of %d (!)", topic.PartitionCount, topic.ManifestPartitionCount)
+				}
 				t.AppendRow(table.Row{
 					topic.TopicName,
-					topic.TopicSize,
+					byteCountBinary(topic.TopicSize),
+					partitions,
 					topic.SegmentCount,
 					topic.SegmentOldOffsetId,
 					topic.SegmentNewOffsetId,
@@ -220,6 +251,11 @@ List segment details for segments with offsets older than the given unix timesta
 			}
 		}
 		t.Render()
+		for _, topic := range topics {
+			if topic.PartitionMismatch {
+				fmt.Printf("warning: %s has %d archived partitions but its topic manifest has %d\n", topic.TopicName, topic.PartitionCount, topic.ManifestPartitionCount)
+			}
+		}
 	},
 }
 
@@ -228,6 +264,13 @@ func init() {
 
 	listCmd.Flags().BoolP("all", "a", false, "show all details")
 	listCmd.Flags().StringP("topic", "t", "", "filter by topic")
-	listCmd.Flags().StringP("older-than", "", "", "show segments w/ offsets older than timestamp (exclusive)")
-	listCmd.Flags().Int64P("offset", "o", -1, "show segments containing an offset range that is lower than the given offset")
+	listCmd.Flags().IntSliceP("partition", "p", nil, "filter by partition")
+	listCmd.Flags().StringP("older-than", "", "", "show segments w/ records older than time (exclusive): "+timeSyntax)
+	listCmd.Flags().String("newer-than", "", "show segments w/ records newer than time (exclusive): "+timeSyntax)
+	listCmd.Flags().String("between", "", "show segments w/ records within <from>,<to> (inclusive)")
+	listCmd.Flags().Int64P("offset", "o", 0, "show segments containing an offset range that is lower than the given offset")
+	listCmd.Flags().Uint64("from-offset", 0, "show segments holding offsets from the given offset (inclusive)")
+	listCmd.Flags().Uint64("to-offset", 0, "show segments holding offsets up to the given offset (inclusive)")
+	listCmd.Flags().Uint64("contains-offset", 0, "show the segments holding the given offset")
+	addOutputFlag(listCmd)
 }
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"rpksi/pkg/rpksi"
+	"strconv"
+	"time"
+)
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Manages partition manifests",
+	Long: `Manages partition manifests.
+
+Every manifest rewritten by rpksi is first copied to a timestamped backup key under the
+backup prefix (see --backupPrefix).
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := cmd.Help()
+		if err != nil {
+			return
+		}
+	},
+}
+
+var manifestRestoreCmd = &cobra.Command{
+	Use:   "restore <topic> <partition>",
+	Short: "Lists manifest backups for a partition and restores one of them",
+	Long: `Lists manifest backups for a partition and restores one of them.
+
+Without --at, the backups of the partition are listed:
+	> rpksi manifest restore aTopic 0
+
+Restore the most recent backup taken at or before the given time (either the timestamp shown
+by the backup list or any time accepted by list, see 'rpksi help list'). The manifest being
+replaced is backed up first:
+	> rpksi manifest restore aTopic 0 --at 20220528T031500Z
+	> rpksi manifest restore aTopic 0 --at 2h
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		topic := args[0]
+		partition, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalln("invalid partition:", args[1])
+		}
+		atFlag, _ := cmd.Flags().GetString("at")
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		backups, err := client.ManifestBackups(context.Background(), topic, partition)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(backups) == 0 {
+			fmt.Printf("no manifest backups found for %s/%d\n", topic, partition)
+			return
+		}
+
+		if len(atFlag) == 0 {
+			t := table.NewWriter()
+			t.SetStyle(table.StyleLight)
+			t.SetOutputMirror(os.Stdout)
+			t.AppendHeader(table.Row{"Taken", "Backup", "Size"})
+			for _, backup := range backups {
+				t.AppendRow(table.Row{backup.Taken.Format(rpksi.BackupTimeFormat), backup.Key, byteCountBinary(uint64(backup.Size))})
+			}
+			t.Render()
+			return
+		}
+
+		at, err := parseBackupTime(atFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		var restore *rpksi.ManifestBackup
+		for i := range backups {
+			if !backups[i].Taken.After(at) {
+				restore = &backups[i]
+			}
+		}
+		if restore == nil {
+			log.Fatalf("no manifest backup of %s/%d taken at or before %s\n", topic, partition, atFlag)
+		}
+
+		fmt.Println("Restoring manifest...")
+		if err := client.RestoreManifest(context.Background(), *restore); err != nil {
+			if errors.Is(err, rpksi.ErrManifestConflict) {
+				log.Fatalf("%s\nthe manifest was not restored, run the command again to restore it over the new version\n", err)
+			}
+			log.Fatalln(err)
+		}
+		fmt.Println("Complete.")
+	},
+}
+
+// parseBackupTime parses a backup timestamp (with or without milliseconds)
+// or any time accepted by rpksi.ParseTime.
+func parseBackupTime(value string) (time.Time, error) {
+	for _, layout := range []string{rpksi.BackupTimeFormat, "20060102T150405Z"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	ms, err := rpksi.ParseTime(value, time.Now())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func init() {
+	rootCmd.AddCommand(manifestCmd)
+	manifestCmd.AddCommand(manifestRestoreCmd)
+
+	manifestRestoreCmd.Flags().String("at", "", "restore the latest backup taken at or before this time")
+}
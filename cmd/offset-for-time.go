@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+	"rpksi/pkg/rpksi"
+	"time"
+)
+
+var offsetForTimeCmd = &cobra.Command{
+	Use:   "offset-for-time <time>",
+	Short: "Finds the first archived offset at or after a time",
+	Long: `Finds the first archived offset at or after a time.
+
+The segment holding the time is found through the base_timestamp and max_timestamp of the
+segments in the manifest, then the batch headers of the segment are read with ranged requests
+until the batch holding the first record at or after the time is found. This works for data
+that only exists in the bucket, so it can be used to pick an offset for 'rpksi del -o'.
+
+Times take the same formats as list (see 'rpksi help list'):
+	> rpksi offset-for-time --topic aTopic --partition 0 2022-05-28T03:10:00Z
+	> rpksi offset-for-time --topic aTopic --partition 0 90d
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		topicFlag, _ := cmd.Flags().GetString("topic")
+		if len(topicFlag) == 0 {
+			log.Fatalln("Topic required (--topic or -t)")
+		}
+		partitionFlag, _ := cmd.Flags().GetInt("partition")
+		ts, err := rpksi.ParseTime(args[0], time.Now())
+		if err != nil {
+			log.Fatalln(err)
+		}
+		format, err := outputFormat(cmd)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		lookup, err := client.OffsetForTime(context.Background(), topicFlag, partitionFlag, ts)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if format != outputTable {
+			if err := printRecords(format, []rpksi.OffsetLookup{lookup}); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+		fmt.Println("Offset:   ", lookup.Offset)
+		fmt.Printf("Timestamp: %d (%s)\n", lookup.Timestamp, time.UnixMilli(lookup.Timestamp).UTC().Format(time.RFC3339Nano))
+		fmt.Println("Segment:  ", lookup.Segment.SegmentName)
+		fmt.Println("Object:   ", lookup.Segment.ObjectPath)
+		fmt.Printf("%s transferred in %d ranged requests\n", byteCountBinary(uint64(lookup.BytesRead)), lookup.Requests)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(offsetForTimeCmd)
+
+	offsetForTimeCmd.Flags().StringP("topic", "t", "", "topic to search")
+	offsetForTimeCmd.Flags().IntP("partition", "p", 0, "partition to search")
+	addOutputFlag(offsetForTimeCmd)
+}
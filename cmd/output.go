@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	outputTable    = "table"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputCSV      = "csv"
+	outputMarkdown = "markdown"
+)
+
+var outputFormats = []string{outputTable, outputJSON, outputYAML, outputCSV, outputMarkdown}
+
+// addOutputFlag registers the --output flag on cmd.
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().String("output", outputTable, "output format ("+strings.Join(outputFormats, "|")+")")
+}
+
+// outputFormat returns the validated value of the --output flag.
+func outputFormat(cmd *cobra.Command) (string, error) {
+	format, _ := cmd.Flags().GetString("output")
+	for _, f := range outputFormats {
+		if format == f {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q (expected one of %s)", format, strings.Join(outputFormats, ", "))
+}
+
+// printStructured writes v as json or yaml to stdout.
+func printStructured(format string, v interface{}) error {
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case outputYAML:
+		encoder := yaml.NewEncoder(os.Stdout)
+		defer encoder.Close()
+		return encoder.Encode(v)
+	}
+	return fmt.Errorf("%s is not a structured output format", format)
+}
+
+// printRecords writes a slice of structs to stdout in the given format. Column
+// and field names are taken from the json tags of the struct, and values are
+// written unformatted so sizes stay in bytes.
+func printRecords(format string, records interface{}) error {
+	value := reflect.ValueOf(records)
+	if value.Kind() != reflect.Slice {
+		return fmt.Errorf("expected a slice, got %T", records)
+	}
+	if value.IsNil() {
+		// encode an empty list rather than null
+		value = reflect.MakeSlice(value.Type(), 0, 0)
+	}
+	if format == outputJSON || format == outputYAML {
+		return printStructured(format, value.Interface())
+	}
+
+	var header table.Row
+	var fields []int
+	elemType := value.Type().Elem()
+	for i := 0; i < elemType.NumField(); i++ {
+		name := strings.Split(elemType.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		header = append(header, name)
+		fields = append(fields, i)
+	}
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(header)
+	for i := 0; i < value.Len(); i++ {
+		row := make(table.Row, len(fields))
+		for j, field := range fields {
+			row[j] = value.Index(i).Field(field).Interface()
+		}
+		t.AppendRow(row)
+	}
+	renderFlat(t, format)
+	return nil
+}
+
+// renderFlat renders t in one of the non-box table formats.
+func renderFlat(t table.Writer, format string) {
+	switch format {
+	case outputCSV:
+		t.RenderCSV()
+	case outputMarkdown:
+		t.RenderMarkdown()
+	default:
+		t.Render()
+	}
+}
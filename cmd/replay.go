@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"log"
+	"rpksi/pkg/kafka"
+	"rpksi/pkg/rpksi"
+	"time"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Produces archived records to a Kafka topic",
+	Long: `Produces archived records to a Kafka topic.
+
+The archived batches of the source topic are decoded and their records are produced to the
+target topic through the Kafka API (the kafka endpoint in the config file or --kafka), keeping
+their keys, headers and timestamps. The records get new offsets in the target topic. This
+rehydrates a slice of history into a scratch topic without a remote recovery of the source
+topic. The target topic must exist, and should use CreateTime timestamps so the original
+timestamps are kept.
+
+Like a read_committed consumer, replay skips the records of aborted transactions. They are
+found from the transaction markers in the segments read, so a transaction whose marker is not
+in them (it is after --to, or not archived yet) is replayed, with a warning.
+
+Replay offsets 4000 to 4500 of partition 0 into partition 0 of a scratch topic (--from and
+--to are short for --from-offset and --to-offset):
+	> rpksi replay --topic aTopic --partition 0 --from 4000 --to 4500 --target-topic scratch
+
+Replay a day of records (dates are midnight UTC, so this is the 27th) from every partition into
+a single partition, at most 1000 records and 1 MiB per second:
+	> rpksi replay --topic aTopic --between 2022-05-27,2022-05-28 --target-topic scratch \
+		--target-partition 0 --rate 1000 --byte-rate 1048576
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		topicFlag, _ := cmd.Flags().GetString("topic")
+		if len(topicFlag) == 0 {
+			log.Fatalln("Topic required (--topic or -t)")
+		}
+		targetTopicFlag, _ := cmd.Flags().GetString("target-topic")
+		if len(targetTopicFlag) == 0 {
+			log.Fatalln("Target topic required (--target-topic)")
+		}
+		targetPartitionFlag, _ := cmd.Flags().GetInt("target-partition")
+		batchRecordsFlag, _ := cmd.Flags().GetInt("batch-records")
+		rateFlag, _ := cmd.Flags().GetFloat64("rate")
+		byteRateFlag, _ := cmd.Flags().GetFloat64("byte-rate")
+		filter := segmentFilter(cmd)
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		producer := kafka.NewClient(viper.GetString("kafka"))
+		defer producer.Close()
+
+		fmt.Printf("Replaying %s to %s...\n", topicFlag, targetTopicFlag)
+		stats, err := client.Replay(context.Background(), filter, producer, rpksi.ReplayOptions{
+			TargetTopic:      targetTopicFlag,
+			TargetPartition:  targetPartitionFlag,
+			BatchRecords:     batchRecordsFlag,
+			RecordsPerSecond: rateFlag,
+			BytesPerSecond:   byteRateFlag,
+		})
+		if err != nil {
+			log.Fatalf("%s\n%d records were produced before the error\n", err, stats.Records)
+		}
+		fmt.Printf("Produced %d records (%s) in %d batches in %s\n", stats.Records, byteCountBinary(uint64(stats.Bytes)), stats.Batches, stats.Elapsed.Round(time.Millisecond))
+		if stats.Aborted > 0 {
+			fmt.Printf("Skipped the records of %d aborted transactions\n", stats.Aborted)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().StringP("topic", "t", "", "topic to replay")
+	replayCmd.Flags().IntSliceP("partition", "p", nil, "filter by partition")
+	replayCmd.Flags().Uint64("from-offset", 0, "replay records from the given offset (inclusive), or --from")
+	replayCmd.Flags().Uint64("to-offset", 0, "replay records up to the given offset (inclusive), or --to")
+	// the offset flags are named as in dump, export and grep, and shared
+	// with them through segmentFilter
+	replayCmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		switch name {
+		case "from":
+			name = "from-offset"
+		case "to":
+			name = "to-offset"
+		}
+		return pflag.NormalizedName(name)
+	})
+	replayCmd.Flags().String("between", "", "replay records with timestamps within <from>,<to> (inclusive)")
+	replayCmd.Flags().String("target-topic", "", "topic to produce the records to")
+	replayCmd.Flags().Int("target-partition", -1, "partition to produce the records to (default: the partition of each record)")
+	replayCmd.Flags().Int("batch-records", rpksi.DefaultReplayBatchRecords, "maximum number of records produced at a time")
+	replayCmd.Flags().Float64("rate", 0, "maximum records produced per second (0 for no limit)")
+	replayCmd.Flags().Float64("byte-rate", 0, "maximum bytes of keys, values and headers produced per second (0 for no limit)")
+}
@@ -0,0 +1,15 @@
+package cmd
+
+import "testing"
+
+func TestReplayOffsetFlags(t *testing.T) {
+	flags := replayCmd.Flags()
+	if err := flags.Parse([]string{"--from", "4000", "--to", "4500"}); err != nil {
+		t.Fatal(err)
+	}
+	from, _ := flags.GetUint64("from-offset")
+	to, _ := flags.GetUint64("to-offset")
+	if from != 4000 || to != 4500 {
+		t.Errorf("--from and --to set the offsets %d to %d, want 4000 to 4500", from, to)
+	}
+}
@@ -1,70 +1,70 @@
 package cmd
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"os"
+	"path/filepath"
+	"rpksi/pkg/rpksi"
+	"rpksi/pkg/schemaregistry"
+	"rpksi/pkg/storage"
+	"strings"
 )
 
 var cfgFile string
 
-type Segment struct {
-	IsCompacted     bool   `json:"is_compacted"`
-	SizeBytes       uint64 `json:"size_bytes"`
-	CommittedOffset uint64 `json:"committed_offset"`
-	BaseOffset      uint64 `json:"base_offset"`
-	BaseTimestamp   uint64 `json:"base_timestamp"`
-	MaxTimestamp    uint64 `json:"max_timestamp"`
-	DeltaOffset     uint64 `json:"delta_offset"`
-	ArchiverTerm    int    `json:"archiver_term"`
+// newObjectStore opens the configured bucket. Tests can replace it to run
+// commands against a storage.MemoryStore.
+var newObjectStore = func() (storage.ObjectStore, error) {
+	return storage.NewMinioStore(
+		viper.GetString("s3"),
+		viper.GetString("accessKey"),
+		viper.GetString("secretKey"),
+		viper.GetBool("useSSL"),
+		viper.GetString("bucket"),
+	)
 }
 
-type Manifest struct {
-	Version      int                `json:"version"`
-	Namespace    string             `json:"namespace"`
-	Topic        string             `json:"topic"`
-	Partition    int                `json:"partition"`
-	Revision     int                `json:"revision"`
-	LastOffset   uint64             `json:"last_offset"`
-	Segments     map[string]Segment `json:"segments"`
-	NeedsRewrite bool
-}
-
-type RowTopic struct {
-	TopicName          string
-	TopicSize          string
-	SegmentCount       int
-	SegmentOldOffsetId uint64
-	SegmentNewOffsetId uint64
-}
+// osExit ends the process with the status of a command that ran to the end,
+// such as fsck finding errors. Tests can replace it to check the status.
+var osExit = os.Exit
 
-type RowSegment struct {
-	Delete               bool
-	ObjectPath           string
-	Partition            int
-	TopicName            string
-	SegmentName          string
-	SegmentSize          string
-	SegmentOldOffsetDate uint64
-	SegmentNewOffsetDate uint64
-	SegmentOldOffsetId   uint64
-	SegmentNewOffsetId   uint64
+// newClient creates an rpksi client for the configured bucket and admin API.
+func newClient() (*rpksi.Client, error) {
+	store, err := newObjectStore()
+	if err != nil {
+		return nil, err
+	}
+	client := rpksi.NewClient(store, viper.GetString("admin"), viper.GetBool("useSSL"))
+	client.BackupPrefix = viper.GetString("backupPrefix")
+	client.Log = os.Stdout
+	return client, nil
 }
 
-// MarshalJSON excludes NeedsRewrite from json manifest
-func (m Manifest) MarshalJSON() ([]byte, error) {
-	mMap := map[string]interface{}{
-		"version":     m.Version,
-		"namespace":   m.Namespace,
-		"topic":       m.Topic,
-		"partition":   m.Partition,
-		"revision":    m.Revision,
-		"last_offset": m.LastOffset,
-		"segments":    m.Segments,
+// newSchemaDecoder creates a decoder of Confluent wire format keys and
+// values, using the configured schema registry and schema directories.
+func newSchemaDecoder() (*schemaregistry.Decoder, error) {
+	registryURL := viper.GetString("schemaRegistry")
+	schemaDir := viper.GetString("schemaDir")
+	if len(registryURL) == 0 && len(schemaDir) == 0 {
+		return nil, errors.New("schema registry required (schemaRegistry or schemaDir in the config file)")
+	}
+	if len(registryURL) > 0 && !strings.Contains(registryURL, "://") {
+		scheme := "http://"
+		if viper.GetBool("useSSL") {
+			scheme = "https://"
+		}
+		registryURL = scheme + registryURL
+	}
+	cacheDir := viper.GetString("schemaCache")
+	if len(cacheDir) == 0 {
+		if dir, err := os.UserCacheDir(); err == nil {
+			cacheDir = filepath.Join(dir, "rpksi", "schemas")
+		}
 	}
-	return json.Marshal(mMap)
+	return schemaregistry.NewDecoder(schemaregistry.NewRegistry(registryURL, schemaDir, cacheDir)), nil
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -85,6 +85,8 @@ Values in a config file will be used if found (default location is $HOME/.redpan
 	accessKey: "minio"
 	secretKey: "minio123"
 	useSSL: false
+	backupPrefix: "rpksi-backups"
+	schemaRegistry: "localhost:8081"
 
 USAGE:
 
@@ -117,6 +119,10 @@ func init() {
 	rootCmd.PersistentFlags().StringP("bucket", "b", "redpanda", "bucket name")
 	rootCmd.PersistentFlags().String("accessKey", "", "access key")
 	rootCmd.PersistentFlags().String("secretKey", "", "secret key")
+	rootCmd.PersistentFlags().String("backupPrefix", rpksi.DefaultBackupPrefix, "bucket prefix for manifest backups")
+	rootCmd.PersistentFlags().String("schemaRegistry", "", "Schema Registry endpoint, used to decode keys and values")
+	rootCmd.PersistentFlags().String("schemaDir", "", "directory of exported schemas, used before the Schema Registry")
+	rootCmd.PersistentFlags().String("schemaCache", "", "directory caching the schemas fetched from the Schema Registry (default is the user cache directory)")
 
 	cobra.OnInitialize(initConfig)
 
@@ -166,6 +172,10 @@ func initConfig() {
 	viper.BindPFlag("bucket", rootCmd.Flags().Lookup("bucket"))
 	viper.BindPFlag("accessKey", rootCmd.Flags().Lookup("accessKey"))
 	viper.BindPFlag("secretKey", rootCmd.Flags().Lookup("secretKey"))
+	viper.BindPFlag("backupPrefix", rootCmd.Flags().Lookup("backupPrefix"))
+	viper.BindPFlag("schemaRegistry", rootCmd.Flags().Lookup("schemaRegistry"))
+	viper.BindPFlag("schemaDir", rootCmd.Flags().Lookup("schemaDir"))
+	viper.BindPFlag("schemaCache", rootCmd.Flags().Lookup("schemaCache"))
 
 	viper.AutomaticEnv()
 }
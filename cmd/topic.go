@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"time"
+)
+
+var topicCmd = &cobra.Command{
+	Use:   "topic",
+	Short: "Shows archived topics",
+	Long: `Shows archived topics.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := cmd.Help()
+		if err != nil {
+			return
+		}
+	},
+}
+
+var topicDescribeCmd = &cobra.Command{
+	Use:   "describe <topic>",
+	Short: "Shows the topic manifest and partition manifests of a topic",
+	Long: `Shows the topic manifest and partition manifests of a topic.
+
+The topic manifest holds the configuration of the topic when it was archived: partition count,
+replication factor, revision, cleanup policy and retention settings. Settings left to the
+cluster defaults are shown as "default". Each partition manifest is listed below it, and a
+warning is printed when the number of archived partitions differs from the partition count:
+	> rpksi topic describe aTopic
+
+Print the description as json or yaml (csv and markdown list the partition manifests):
+	> rpksi topic describe aTopic --output json
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := outputFormat(cmd)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		description, err := client.DescribeTopic(context.Background(), args[0])
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		switch format {
+		case outputJSON, outputYAML:
+			err = printStructured(format, description)
+		case outputCSV, outputMarkdown:
+			err = printRecords(format, description.Partitions)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if format != outputTable {
+			return
+		}
+
+		if description.Manifest == nil {
+			fmt.Printf("no topic manifest found for %s\n", description.Topic)
+		} else {
+			m := description.Manifest
+			t := table.NewWriter()
+			t.SetStyle(table.StyleLight)
+			t.SetOutputMirror(os.Stdout)
+			t.AppendHeader(table.Row{"Setting", "Value"})
+			t.AppendRows([]table.Row{
+				{"Topic", m.Topic},
+				{"Namespace", m.Namespace},
+				{"Manifest", description.ManifestKey},
+				{"Partition count", m.PartitionCount},
+				{"Replication factor", m.ReplicationFactor},
+				{"Revision", m.RevisionID},
+				{"Cleanup policy", stringSetting(m.CleanupPolicy)},
+				{"Compaction strategy", stringSetting(m.CompactionStrategy)},
+				{"Compression", stringSetting(m.Compression)},
+				{"Timestamp type", stringSetting(m.TimestampType)},
+				{"Segment size", sizeSetting(m.SegmentSize)},
+				{"Retention bytes", sizeSetting(m.RetentionBytes)},
+				{"Retention duration", durationSetting(m.RetentionDuration)},
+			})
+			t.Render()
+		}
+
+		t := table.NewWriter()
+		t.SetStyle(table.StyleLight)
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Partition", "Revision", "Format", "Segments", "Size", "Start Offset", "Last Offset", "Manifest"})
+		for _, p := range description.Partitions {
+			t.AppendRow(table.Row{p.Partition, p.Revision, p.Format, p.SegmentCount, byteCountBinary(p.Size), p.StartOffset, p.LastOffset, p.ManifestKey})
+		}
+		t.Render()
+		if description.PartitionMismatch() {
+			fmt.Printf("warning: %d archived partitions but the topic manifest has %d\n", description.ArchivedPartitions(), description.Manifest.PartitionCount)
+		}
+	},
+}
+
+func stringSetting(value *string) string {
+	if value == nil {
+		return "default"
+	}
+	return *value
+}
+
+func sizeSetting(value *int64) string {
+	if value == nil {
+		return "default"
+	}
+	if *value < 0 {
+		return "unlimited"
+	}
+	return byteCountBinary(uint64(*value))
+}
+
+func durationSetting(value *int64) string {
+	if value == nil {
+		return "default"
+	}
+	if *value < 0 {
+		return "unlimited"
+	}
+	return (time.Duration(*value) * time.Millisecond).String()
+}
+
+func init() {
+	rootCmd.AddCommand(topicCmd)
+	topicCmd.AddCommand(topicDescribeCmd)
+
+	addOutputFlag(topicDescribeCmd)
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"rpksi/pkg/rpksi"
+	"sort"
+	"strings"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verifies the integrity of remote segments",
+	Long: `Verifies the integrity of remote segments.
+
+Each segment listed in the manifests of the topic is downloaded and every record batch is
+checked against its header CRC and its record CRC (CRC32C). The offsets of the batches are
+checked against the base_offset and committed_offset of the segment in the manifest, and the
+length of the object against size_bytes.
+
+Segments are reported as ok, missing (no object), truncated (the object ends early),
+corrupted (a CRC check failed or a batch can not be decoded) or mismatch (the object is
+readable but does not match the manifest). The command exits with status 1 when any
+segment is not ok.
+
+Verify every segment of a topic:
+	> rpksi verify --topic aTopic
+
+Verify the segments of partitions 0 and 1 and print the results as json:
+	> rpksi verify --topic aTopic --partition 0,1 --output json
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		topicFlag, _ := cmd.Flags().GetString("topic")
+		if len(topicFlag) == 0 {
+			log.Fatalln("Topic required (--topic or -t)")
+		}
+		partitionFlag, _ := cmd.Flags().GetIntSlice("partition")
+		format, err := outputFormat(cmd)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		client, err := newClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		checks, err := client.VerifySegments(context.Background(), rpksi.Filter{Topic: topicFlag, Partitions: partitionFlag})
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if format != outputTable {
+			if err := printRecords(format, checks); err != nil {
+				log.Fatalln(err)
+			}
+		} else {
+			printVerification(checks)
+		}
+		for _, check := range checks {
+			if check.Status != rpksi.SegmentOK {
+				osExit(1)
+				return
+			}
+		}
+	},
+}
+
+// printVerification prints the problems found in each segment followed by a
+// summary for each partition.
+func printVerification(checks []rpksi.SegmentCheck) {
+	type summary struct {
+		segments int
+		statuses map[rpksi.SegmentStatus]int
+	}
+	summaries := make(map[int]*summary)
+	var partitions []int
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.Style().Options.SeparateRows = true
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Partition", "Segment", "Status", "Batches", "Size", "Problems"})
+	for _, check := range checks {
+		s, ok := summaries[check.Partition]
+		if !ok {
+			s = &summary{statuses: make(map[rpksi.SegmentStatus]int)}
+			summaries[check.Partition] = s
+			partitions = append(partitions, check.Partition)
+		}
+		s.segments++
+		s.statuses[check.Status]++
+		t.AppendRow(table.Row{
+			check.Partition,
+			check.SegmentName,
+			check.Status,
+			check.Batches,
+			byteCountBinary(uint64(check.BytesRead)),
+			strings.Join(check.Problems, "\n"),
+		})
+	}
+	t.Render()
+
+	sort.Ints(partitions)
+	for _, partition := range partitions {
+		s := summaries[partition]
+		var counts []string
+		for _, status := range []rpksi.SegmentStatus{rpksi.SegmentOK, rpksi.SegmentMissing, rpksi.SegmentTruncated, rpksi.SegmentCorrupted, rpksi.SegmentMismatch} {
+			if s.statuses[status] > 0 {
+				counts = append(counts, fmt.Sprintf("%d %s", s.statuses[status], status))
+			}
+		}
+		fmt.Printf("partition %d: %d segments, %s\n", partition, s.segments, strings.Join(counts, ", "))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringP("topic", "t", "", "topic to verify")
+	verifyCmd.Flags().IntSliceP("partition", "p", nil, "filter by partition")
+	addOutputFlag(verifyCmd)
+}
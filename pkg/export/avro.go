@@ -0,0 +1,93 @@
+package export
+
+import (
+	"github.com/linkedin/goavro/v2"
+	"io"
+	"rpksi/pkg/segment"
+	"time"
+)
+
+// avroSchema is the schema of exported Avro files.
+const avroSchema = `{
+	"type": "record",
+	"name": "Record",
+	"namespace": "rpksi",
+	"fields": [
+		{"name": "offset", "type": "long"},
+		{"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "key", "type": ["null", "bytes"]},
+		{"name": "value", "type": ["null", "bytes"]},
+		{"name": "headers", "type": {"type": "array", "items": {
+			"type": "record",
+			"name": "Header",
+			"fields": [
+				{"name": "key", "type": "string"},
+				{"name": "value", "type": ["null", "bytes"]}
+			]
+		}}}
+	]
+}`
+
+// avroBlockRecords is the number of records in each block of the file.
+const avroBlockRecords = 1000
+
+// avroWriter writes records as an Avro object container file with snappy
+// compressed blocks.
+type avroWriter struct {
+	ocf   *goavro.OCFWriter
+	block []interface{}
+}
+
+func newAvroWriter(w io.Writer) (*avroWriter, error) {
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               w,
+		Schema:          avroSchema,
+		CompressionName: goavro.CompressionSnappyLabel,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &avroWriter{ocf: ocf}, nil
+}
+
+func (a *avroWriter) Write(r segment.Record) error {
+	headers := make([]interface{}, 0, len(r.Headers))
+	for _, header := range r.Headers {
+		headers = append(headers, map[string]interface{}{
+			"key":   header.Key,
+			"value": avroBytes(header.Value),
+		})
+	}
+	a.block = append(a.block, map[string]interface{}{
+		"offset":    r.Offset,
+		"timestamp": time.UnixMilli(r.Timestamp).UTC(),
+		"key":       avroBytes(r.Key),
+		"value":     avroBytes(r.Value),
+		"headers":   headers,
+	})
+	if len(a.block) < avroBlockRecords {
+		return nil
+	}
+	return a.flush()
+}
+
+func (a *avroWriter) flush() error {
+	if len(a.block) == 0 {
+		return nil
+	}
+	err := a.ocf.Append(a.block)
+	a.block = a.block[:0]
+	return err
+}
+
+func (a *avroWriter) Close() error {
+	return a.flush()
+}
+
+// avroBytes returns the union value of a nullable byte array.
+func avroBytes(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return goavro.Union("bytes", b)
+}
@@ -0,0 +1,59 @@
+// Package export writes archived records as files for data lake tools, in
+// Parquet, Avro or JSON lines. Every format has the same columns: offset,
+// timestamp, key, value and headers.
+package export
+
+import (
+	"fmt"
+	"io"
+	"rpksi/pkg/segment"
+	"time"
+)
+
+// Format is a file format records can be exported to.
+type Format string
+
+const (
+	FormatParquet Format = "parquet"
+	FormatAvro    Format = "avro"
+	FormatJSONL   Format = "jsonl"
+)
+
+// ParseFormat returns the format named s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatParquet, FormatAvro, FormatJSONL:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q (parquet|avro|jsonl)", s)
+}
+
+// RecordWriter writes records to a file of a given format.
+type RecordWriter interface {
+	Write(segment.Record) error
+	// Close writes anything buffered and the end of the file. It does not
+	// close the underlying writer.
+	Close() error
+}
+
+// NewRecordWriter returns a writer of records in format to w.
+func NewRecordWriter(format Format, w io.Writer) (RecordWriter, error) {
+	switch format {
+	case FormatParquet:
+		return newParquetWriter(w), nil
+	case FormatAvro:
+		return newAvroWriter(w)
+	case FormatJSONL:
+		return newJSONLWriter(w), nil
+	}
+	return nil, fmt.Errorf("unknown format %q (parquet|avro|jsonl)", format)
+}
+
+// FilePath returns the path of the file holding the records of a topic
+// partition produced on date (UTC), starting at firstOffset. Paths follow
+// the Hive layout understood by most query engines, for instance
+// topic=aTopic/partition=0/date=2022-05-27/aTopic-0-4000.parquet.
+func FilePath(topic string, partition int, date time.Time, firstOffset int64, format Format) string {
+	return fmt.Sprintf("topic=%s/partition=%d/date=%s/%s-%d-%d.%s",
+		topic, partition, date.UTC().Format("2006-01-02"), topic, partition, firstOffset, format)
+}
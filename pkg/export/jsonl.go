@@ -0,0 +1,51 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"rpksi/pkg/segment"
+)
+
+// jsonlRecord is an exported JSON line. Keys, values and header values are
+// base64 strings, or null.
+type jsonlRecord struct {
+	Offset    int64         `json:"offset"`
+	Timestamp int64         `json:"timestamp"`
+	Key       []byte        `json:"key"`
+	Value     []byte        `json:"value"`
+	Headers   []jsonlHeader `json:"headers"`
+}
+
+type jsonlHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// jsonlWriter writes a record per line.
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonlWriter{enc: enc}
+}
+
+func (j *jsonlWriter) Write(r segment.Record) error {
+	out := jsonlRecord{
+		Offset:    r.Offset,
+		Timestamp: r.Timestamp,
+		Key:       r.Key,
+		Value:     r.Value,
+		Headers:   make([]jsonlHeader, 0, len(r.Headers)),
+	}
+	for _, header := range r.Headers {
+		out.Headers = append(out.Headers, jsonlHeader{Key: header.Key, Value: header.Value})
+	}
+	return j.enc.Encode(out)
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,348 @@
+package export
+
+import (
+	"encoding/binary"
+	"github.com/klauspost/compress/snappy"
+	"io"
+	"rpksi/pkg/segment"
+)
+
+// Parquet physical types, repetitions, converted types and encodings used by
+// the export schema.
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+	parquetRepeated = 2
+
+	parquetUTF8            = 0
+	parquetList            = 3
+	parquetTimestampMillis = 9
+
+	parquetPlain  = 0
+	parquetRLE    = 3
+	parquetSnappy = 1
+)
+
+// parquetMagic starts and ends a Parquet file.
+const parquetMagic = "PAR1"
+
+// Row groups are written once either limit is reached, which bounds the
+// records buffered in memory.
+const (
+	parquetRowGroupRows  = 100000
+	parquetRowGroupBytes = 64 << 20
+)
+
+// parquetColumn buffers the levels and PLAIN encoded values of a leaf column
+// for the current row group.
+type parquetColumn struct {
+	path   []string
+	typ    int32
+	maxRep int
+	maxDef int
+	rep    []int
+	def    []int
+	values []byte
+	// levels counts the entries of the column, values and nulls alike
+	levels int
+}
+
+func (c *parquetColumn) add(rep, def int, value []byte, present bool) {
+	if c.maxRep > 0 {
+		c.rep = append(c.rep, rep)
+	}
+	if c.maxDef > 0 {
+		c.def = append(c.def, def)
+	}
+	c.levels++
+	if !present {
+		return
+	}
+	if c.typ == parquetInt64 {
+		c.values = append(c.values, value...)
+		return
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(value)))
+	c.values = append(append(c.values, size[:]...), value...)
+}
+
+func (c *parquetColumn) int64(v int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(v))
+	c.add(0, 0, b[:], true)
+}
+
+// optional adds a nullable top-level byte array.
+func (c *parquetColumn) optional(b []byte) {
+	if b == nil {
+		c.add(0, 0, nil, false)
+		return
+	}
+	c.add(0, 1, b, true)
+}
+
+// columnChunk is the metadata of a column chunk already written.
+type columnChunk struct {
+	offset       int64
+	numValues    int64
+	uncompressed int64
+	compressed   int64
+}
+
+// parquetWriter writes records as a Parquet file with offset, timestamp,
+// key, value and a list of headers. Columns are PLAIN encoded and snappy
+// compressed, with one page per column in each row group.
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	columns   []*parquetColumn
+	rows      int64
+	buffered  int
+	rowGroups []parquetRowGroup
+	err       error
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	size   int64
+	chunks []columnChunk
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{
+		w: w,
+		columns: []*parquetColumn{
+			{path: []string{"offset"}, typ: parquetInt64},
+			{path: []string{"timestamp"}, typ: parquetInt64},
+			{path: []string{"key"}, typ: parquetByteArray, maxDef: 1},
+			{path: []string{"value"}, typ: parquetByteArray, maxDef: 1},
+			{path: []string{"headers", "list", "element", "key"}, typ: parquetByteArray, maxRep: 1, maxDef: 1},
+			{path: []string{"headers", "list", "element", "value"}, typ: parquetByteArray, maxRep: 1, maxDef: 2},
+		},
+	}
+}
+
+func (p *parquetWriter) Write(r segment.Record) error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.offset == 0 {
+		p.write([]byte(parquetMagic))
+	}
+	p.columns[0].int64(r.Offset)
+	p.columns[1].int64(r.Timestamp)
+	p.columns[2].optional(r.Key)
+	p.columns[3].optional(r.Value)
+	if len(r.Headers) == 0 {
+		p.columns[4].add(0, 0, nil, false)
+		p.columns[5].add(0, 0, nil, false)
+	}
+	for i, header := range r.Headers {
+		rep := 0
+		if i > 0 {
+			rep = 1
+		}
+		p.columns[4].add(rep, 1, []byte(header.Key), true)
+		if header.Value == nil {
+			p.columns[5].add(rep, 1, nil, false)
+		} else {
+			p.columns[5].add(rep, 2, header.Value, true)
+		}
+	}
+	p.rows++
+	p.buffered += len(r.Key) + len(r.Value) + 16
+	if p.rows%parquetRowGroupRows == 0 || p.buffered >= parquetRowGroupBytes {
+		p.flushRowGroup()
+	}
+	return p.err
+}
+
+func (p *parquetWriter) write(b []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	p.err = err
+}
+
+// flushRowGroup writes the buffered rows as a row group.
+func (p *parquetWriter) flushRowGroup() {
+	var groupRows int64
+	for _, g := range p.rowGroups {
+		groupRows += g.rows
+	}
+	group := parquetRowGroup{rows: p.rows - groupRows}
+	if group.rows == 0 {
+		return
+	}
+	for _, c := range p.columns {
+		var page []byte
+		if c.maxRep > 0 {
+			page = appendLevels(page, c.rep, c.maxRep)
+		}
+		if c.maxDef > 0 {
+			page = appendLevels(page, c.def, c.maxDef)
+		}
+		page = append(page, c.values...)
+		compressed := snappy.Encode(nil, page)
+
+		header := thriftWriter{}
+		header.beginStruct()
+		header.i32(1, 0) // data page
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(compressed)))
+		header.structField(5, func() {
+			header.i32(1, int32(c.levels))
+			header.i32(2, parquetPlain)
+			header.i32(3, parquetRLE)
+			header.i32(4, parquetRLE)
+		})
+		header.endStruct()
+
+		chunk := columnChunk{
+			offset:       p.offset,
+			numValues:    int64(c.levels),
+			uncompressed: int64(len(header.buf) + len(page)),
+			compressed:   int64(len(header.buf) + len(compressed)),
+		}
+		p.write(header.buf)
+		p.write(compressed)
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.uncompressed
+		c.rep, c.def, c.values, c.levels = c.rep[:0], c.def[:0], c.values[:0], 0
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.buffered = 0
+}
+
+// appendLevels appends repetition or definition levels, RLE encoded and
+// prefixed with their length.
+func appendLevels(b []byte, levels []int, max int) []byte {
+	width := 0
+	for max > 0 {
+		width++
+		max >>= 1
+	}
+	start := len(b)
+	b = append(b, 0, 0, 0, 0)
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		var header [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(header[:], uint64(j-i)<<1)
+		b = append(b, header[:n]...)
+		for k := 0; k < (width+7)/8; k++ {
+			b = append(b, byte(levels[i]>>(8*k)))
+		}
+		i = j
+	}
+	binary.LittleEndian.PutUint32(b[start:], uint32(len(b)-start-4))
+	return b
+}
+
+// Close writes the remaining rows and the file metadata. It does not close
+// the underlying writer.
+func (p *parquetWriter) Close() error {
+	if p.offset == 0 {
+		p.write([]byte(parquetMagic))
+	}
+	p.flushRowGroup()
+
+	meta := thriftWriter{}
+	meta.beginStruct()
+	meta.i32(1, 1)
+	meta.structList(2, len(parquetSchema), func(i int) {
+		parquetSchema[i].write(&meta)
+	})
+	meta.i64(3, p.rows)
+	meta.structList(4, len(p.rowGroups), func(i int) {
+		group := p.rowGroups[i]
+		meta.structList(1, len(group.chunks), func(j int) {
+			chunk, column := group.chunks[j], p.columns[j]
+			meta.i64(2, chunk.offset)
+			meta.structField(3, func() {
+				meta.i32(1, column.typ)
+				meta.i32List(2, []int32{parquetPlain, parquetRLE})
+				meta.stringList(3, column.path)
+				meta.i32(4, parquetSnappy)
+				meta.i64(5, chunk.numValues)
+				meta.i64(6, chunk.uncompressed)
+				meta.i64(7, chunk.compressed)
+				meta.i64(9, chunk.offset)
+			})
+		})
+		meta.i64(2, group.size)
+		meta.i64(3, group.rows)
+	})
+	meta.string(6, "rpksi")
+	meta.endStruct()
+
+	p.write(meta.buf)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(meta.buf)))
+	p.write(size[:])
+	p.write([]byte(parquetMagic))
+	return p.err
+}
+
+// parquetSchemaElement is an element of the depth-first Parquet schema,
+// a negative type or repetition is left out.
+type parquetSchemaElement struct {
+	name       string
+	typ        int32
+	repetition int32
+	children   int32
+	converted  int32
+}
+
+// parquetSchema is the schema of exported files:
+//
+//	message schema {
+//	  required int64 offset;
+//	  required int64 timestamp (TIMESTAMP_MILLIS);
+//	  optional binary key;
+//	  optional binary value;
+//	  required group headers (LIST) {
+//	    repeated group list {
+//	      required group element {
+//	        required binary key (UTF8);
+//	        optional binary value;
+//	      }
+//	    }
+//	  }
+//	}
+var parquetSchema = []parquetSchemaElement{
+	{name: "schema", typ: -1, repetition: -1, children: 5, converted: -1},
+	{name: "offset", typ: parquetInt64, repetition: parquetRequired, converted: -1},
+	{name: "timestamp", typ: parquetInt64, repetition: parquetRequired, converted: parquetTimestampMillis},
+	{name: "key", typ: parquetByteArray, repetition: parquetOptional, converted: -1},
+	{name: "value", typ: parquetByteArray, repetition: parquetOptional, converted: -1},
+	{name: "headers", typ: -1, repetition: parquetRequired, children: 1, converted: parquetList},
+	{name: "list", typ: -1, repetition: parquetRepeated, children: 1, converted: -1},
+	{name: "element", typ: -1, repetition: parquetRequired, children: 2, converted: -1},
+	{name: "key", typ: parquetByteArray, repetition: parquetRequired, converted: parquetUTF8},
+	{name: "value", typ: parquetByteArray, repetition: parquetOptional, converted: -1},
+}
+
+func (e parquetSchemaElement) write(w *thriftWriter) {
+	if e.typ >= 0 {
+		w.i32(1, e.typ)
+	}
+	if e.repetition >= 0 {
+		w.i32(3, e.repetition)
+	}
+	w.string(4, e.name)
+	if e.children > 0 {
+		w.i32(5, e.children)
+	}
+	if e.converted >= 0 {
+		w.i32(6, e.converted)
+	}
+}
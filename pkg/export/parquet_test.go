@@ -0,0 +1,400 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/snappy"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"rpksi/pkg/segment"
+	"testing"
+)
+
+// No Parquet library is a dependency of rpksi, so the files written are read
+// back with the minimal reader below. It follows the Parquet format
+// specification, decodes pages with another snappy implementation than the
+// writer and handles both kinds of runs of the RLE/bit-packed hybrid
+// encoding, but only the subset of Parquet the export schema needs.
+// TestParquetPyarrow also reads them with pyarrow, when it is installed.
+
+// parquetLeaf is a column read back from a file.
+type parquetLeaf struct {
+	rep, def []int
+	values   [][]byte
+}
+
+func field(t *testing.T, s map[int16]interface{}, id int16) interface{} {
+	t.Helper()
+	v, ok := s[id]
+	if !ok {
+		t.Fatalf("field %d missing from %v", id, s)
+	}
+	return v
+}
+
+// readParquet reads every leaf column of a file written by parquetWriter,
+// keyed by dotted path, and checks the schema and row counts.
+func readParquet(t *testing.T, data []byte) (map[string]*parquetLeaf, int64, int) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(parquetMagic)) || !bytes.HasSuffix(data, []byte(parquetMagic)) {
+		t.Fatal("missing PAR1 magic")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := thriftReader{buf: data[len(data)-8-size : len(data)-8]}
+	meta := r.structValue()
+	if r.err != nil || len(r.buf) > 0 {
+		t.Fatalf("file metadata: %v, %d bytes left", r.err, len(r.buf))
+	}
+
+	var schema []string
+	maxLevels := make(map[string][2]int)
+	var walk func(elements []interface{}, prefix string, rep, def int) []interface{}
+	walk = func(elements []interface{}, prefix string, rep, def int) []interface{} {
+		e := elements[0].(map[int16]interface{})
+		elements = elements[1:]
+		name := field(t, e, 4).(string)
+		if repetition, ok := e[3]; ok {
+			switch repetition.(int32) {
+			case parquetOptional:
+				def++
+			case parquetRepeated:
+				rep, def = rep+1, def+1
+			}
+		}
+		path := name
+		if len(prefix) > 0 {
+			path = prefix + "." + name
+		}
+		children, ok := e[5]
+		if !ok {
+			schema = append(schema, fmt.Sprintf("%s:%d", path, field(t, e, 1)))
+			maxLevels[path] = [2]int{rep, def}
+			return elements
+		}
+		if prefix == "" && name == "schema" {
+			path = ""
+		}
+		for i := 0; i < int(children.(int32)); i++ {
+			elements = walk(elements, path, rep, def)
+		}
+		return elements
+	}
+	walk(field(t, meta, 2).([]interface{}), "", 0, 0)
+	wantSchema := []string{"offset:2", "timestamp:2", "key:6", "value:6", "headers.list.element.key:6", "headers.list.element.value:6"}
+	if !reflect.DeepEqual(schema, wantSchema) {
+		t.Fatalf("schema leaves %v, want %v", schema, wantSchema)
+	}
+
+	leaves := make(map[string]*parquetLeaf)
+	var rows int64
+	groups := field(t, meta, 4).([]interface{})
+	for _, g := range groups {
+		group := g.(map[int16]interface{})
+		rows += field(t, group, 3).(int64)
+		for _, c := range field(t, group, 1).([]interface{}) {
+			column := field(t, c.(map[int16]interface{}), 3).(map[int16]interface{})
+			var names []string
+			for _, name := range field(t, column, 3).([]interface{}) {
+				names = append(names, name.(string))
+			}
+			path := joinPath(names)
+			if codec := field(t, column, 4).(int32); codec != parquetSnappy {
+				t.Fatalf("%s: codec %d", path, codec)
+			}
+			leaf, ok := leaves[path]
+			if !ok {
+				leaf = &parquetLeaf{}
+				leaves[path] = leaf
+			}
+			levels := maxLevels[path]
+			readPage(t, data, field(t, column, 9).(int64), field(t, column, 5).(int64), field(t, column, 1).(int32), levels[0], levels[1], leaf)
+		}
+	}
+	if numRows := field(t, meta, 3).(int64); numRows != rows {
+		t.Errorf("file has %d rows, its row groups %d", numRows, rows)
+	}
+	return leaves, rows, len(groups)
+}
+
+func joinPath(names []string) string {
+	path := ""
+	for i, name := range names {
+		if i > 0 {
+			path += "."
+		}
+		path += name
+	}
+	return path
+}
+
+// readPage reads the data page at offset into leaf.
+func readPage(t *testing.T, data []byte, offset, numValues int64, typ int32, maxRep, maxDef int, leaf *parquetLeaf) {
+	t.Helper()
+	r := thriftReader{buf: data[offset:]}
+	header := r.structValue()
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if pageType := field(t, header, 1).(int32); pageType != 0 {
+		t.Fatalf("page type %d, want a data page", pageType)
+	}
+	compressed := r.buf[:field(t, header, 3).(int32)]
+	page, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != int(field(t, header, 2).(int32)) {
+		t.Fatalf("page of %d bytes, header says %d", len(page), field(t, header, 2))
+	}
+	dataPage := field(t, header, 5).(map[int16]interface{})
+	n := int(field(t, dataPage, 1).(int32))
+	if int64(n) != numValues {
+		t.Fatalf("page of %d values, column chunk says %d", n, numValues)
+	}
+
+	rep, def := make([]int, n), make([]int, n)
+	if maxRep > 0 {
+		rep, page = readLevels(t, page, maxRep, n)
+	}
+	if maxDef > 0 {
+		def, page = readLevels(t, page, maxDef, n)
+	}
+	for i := 0; i < n; i++ {
+		var value []byte
+		if def[i] == maxDef {
+			if typ == parquetInt64 {
+				value, page = page[:8], page[8:]
+			} else {
+				size := binary.LittleEndian.Uint32(page)
+				value, page = page[4:4+size], page[4+size:]
+			}
+		}
+		leaf.rep = append(leaf.rep, rep[i])
+		leaf.def = append(leaf.def, def[i])
+		leaf.values = append(leaf.values, value)
+	}
+	if len(page) > 0 {
+		t.Fatalf("%d bytes left in page", len(page))
+	}
+}
+
+// readLevels decodes n levels of the RLE/bit-packed hybrid encoding, prefixed
+// with their length.
+func readLevels(t *testing.T, page []byte, max, n int) ([]int, []byte) {
+	t.Helper()
+	width := 0
+	for ; max > 0; max >>= 1 {
+		width++
+	}
+	size := binary.LittleEndian.Uint32(page)
+	encoded, rest := page[4:4+size], page[4+size:]
+	var levels []int
+	for len(encoded) > 0 {
+		header, k := binary.Uvarint(encoded)
+		encoded = encoded[k:]
+		if header&1 == 0 {
+			value := 0
+			for i := 0; i < (width+7)/8; i++ {
+				value |= int(encoded[i]) << (8 * i)
+			}
+			encoded = encoded[(width+7)/8:]
+			for i := 0; i < int(header>>1); i++ {
+				levels = append(levels, value)
+			}
+			continue
+		}
+		count := int(header>>1) * 8
+		bits := encoded[:count*width/8]
+		encoded = encoded[count*width/8:]
+		for i := 0; i < count; i++ {
+			value := 0
+			for b := 0; b < width; b++ {
+				bit := i*width + b
+				value |= int(bits[bit/8]>>(bit%8)&1) << b
+			}
+			levels = append(levels, value)
+		}
+	}
+	if len(levels) < n {
+		t.Fatalf("%d levels, want %d", len(levels), n)
+	}
+	return levels[:n], rest
+}
+
+// parquetRecords assembles the records of the leaves read back.
+func parquetRecords(t *testing.T, leaves map[string]*parquetLeaf) []segment.Record {
+	t.Helper()
+	offsets, timestamps := leaves["offset"], leaves["timestamp"]
+	keys, values := leaves["key"], leaves["value"]
+	headerKeys, headerValues := leaves["headers.list.element.key"], leaves["headers.list.element.value"]
+	records := make([]segment.Record, len(offsets.values))
+	for i := range records {
+		records[i] = segment.Record{
+			Offset:    int64(binary.LittleEndian.Uint64(offsets.values[i])),
+			Timestamp: int64(binary.LittleEndian.Uint64(timestamps.values[i])),
+			Key:       keys.values[i],
+			Value:     values.values[i],
+		}
+	}
+	row := -1
+	for i := range headerKeys.rep {
+		if headerKeys.rep[i] != headerValues.rep[i] {
+			t.Fatalf("header key and value levels differ at %d", i)
+		}
+		if headerKeys.rep[i] == 0 {
+			row++
+		}
+		if headerKeys.def[i] == 0 {
+			continue
+		}
+		records[row].Headers = append(records[row].Headers, segment.RecordHeader{
+			Key:   string(headerKeys.values[i]),
+			Value: headerValues.values[i],
+		})
+	}
+	if row != len(records)-1 {
+		t.Fatalf("headers of %d rows, want %d", row+1, len(records))
+	}
+	return records
+}
+
+func TestParquetWriter(t *testing.T) {
+	records := []segment.Record{
+		{Offset: 4000, Timestamp: 1653609600000, Key: []byte("k"), Value: []byte("v")},
+		{Offset: 4001, Timestamp: 1653609600001, Value: []byte{}},
+		{Offset: 4002, Timestamp: 1653609600002, Key: []byte{}, Headers: []segment.RecordHeader{
+			{Key: "trace", Value: []byte("abc")},
+			{Key: "empty", Value: []byte{}},
+			{Key: "null"},
+		}},
+		{Offset: 4003, Timestamp: 1653609600003, Headers: []segment.RecordHeader{{Key: "trace", Value: []byte("def")}}},
+	}
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf)
+	for _, record := range records {
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	leaves, rows, _ := readParquet(t, buf.Bytes())
+	if rows != int64(len(records)) {
+		t.Errorf("%d rows, want %d", rows, len(records))
+	}
+	if got := parquetRecords(t, leaves); !reflect.DeepEqual(got, records) {
+		t.Errorf("read back\n%+v\nwant\n%+v", got, records)
+	}
+}
+
+func TestParquetWriterRowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf)
+	n := parquetRowGroupRows + 10
+	for i := 0; i < n; i++ {
+		record := segment.Record{Offset: int64(i), Timestamp: int64(i), Value: []byte{byte(i)}}
+		if i%3 == 0 {
+			record.Headers = []segment.RecordHeader{{Key: "i", Value: []byte{byte(i)}}}
+		}
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	leaves, rows, groups := readParquet(t, buf.Bytes())
+	if rows != int64(n) || groups != 2 {
+		t.Fatalf("%d rows in %d row groups, want %d in 2", rows, groups, n)
+	}
+	for i, record := range parquetRecords(t, leaves) {
+		if record.Offset != int64(i) || record.Value[0] != byte(i) || (len(record.Headers) == 1) != (i%3 == 0) {
+			t.Fatalf("record %d read back as %+v", i, record)
+		}
+	}
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newParquetWriter(&buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, rows, groups := readParquet(t, buf.Bytes()); rows != 0 || groups != 0 {
+		t.Errorf("empty file has %d rows in %d row groups", rows, groups)
+	}
+}
+
+// pyarrowScript prints the rows of a Parquet file as JSON, with byte strings
+// decoded as UTF-8 and timestamps in milliseconds.
+const pyarrowScript = `
+import json, sys
+import pyarrow.parquet as pq
+
+def plain(v):
+    if isinstance(v, bytes):
+        return v.decode()
+    if isinstance(v, list):
+        return [plain(x) for x in v]
+    if isinstance(v, dict):
+        return {k: plain(x) for k, x in v.items()}
+    if hasattr(v, "timestamp"):
+        return round(v.timestamp() * 1000)
+    return v
+
+table = pq.read_table(sys.argv[1])
+print(json.dumps([plain(row) for row in table.to_pylist()]))
+`
+
+func TestParquetPyarrow(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not found")
+	}
+	if err := exec.Command(python, "-c", "import pyarrow.parquet").Run(); err != nil {
+		t.Skip("pyarrow not installed")
+	}
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf)
+	for _, record := range []segment.Record{
+		{Offset: 4000, Timestamp: 1653609600000, Key: []byte("k"), Value: []byte("v")},
+		{Offset: 4001, Timestamp: 1653609600001, Headers: []segment.RecordHeader{
+			{Key: "trace", Value: []byte("abc")},
+			{Key: "null"},
+		}},
+	} {
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "records.parquet")
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(python, "-c", pyarrowScript, file).Output()
+	if err != nil {
+		t.Fatalf("pyarrow cannot read the file: %v", err)
+	}
+	var got, want interface{}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(`[
+		{"offset": 4000, "timestamp": 1653609600000, "key": "k", "value": "v", "headers": []},
+		{"offset": 4001, "timestamp": 1653609600001, "key": null, "value": null, "headers": [
+			{"key": "trace", "value": "abc"}, {"key": "null", "value": null}
+		]}
+	]`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pyarrow read\n%s\nwant\n%v", out, want)
+	}
+}
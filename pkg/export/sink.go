@@ -0,0 +1,118 @@
+package export
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"rpksi/pkg/storage"
+)
+
+// Sink creates the exported files.
+type Sink interface {
+	// Create creates or overwrites the file at name, a slash separated
+	// path. Nothing is visible at name until the returned file is closed.
+	Create(ctx context.Context, name string) (File, error)
+}
+
+// File is a file being written to a Sink.
+type File interface {
+	io.Writer
+	// Close completes the file.
+	Close() error
+	// Abort discards what was written, leaving the destination as it was
+	// before Create.
+	Abort() error
+}
+
+// DirSink creates files under a local directory. Each file is written next
+// to its destination and renamed over it once closed.
+type DirSink struct {
+	Dir string
+}
+
+func (s DirSink) Create(_ context.Context, name string) (File, error) {
+	dest := filepath.Join(s.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &dirFile{File: file, dest: dest}, nil
+}
+
+// dirFile is a file being written by a DirSink.
+type dirFile struct {
+	*os.File
+	dest string
+}
+
+// Close renames the file to its destination.
+func (f *dirFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), f.dest)
+}
+
+// Abort removes the file.
+func (f *dirFile) Abort() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
+// BucketSink creates objects under a prefix of a bucket. Each file is staged
+// in a temporary file and uploaded with its size once closed, as uploading a
+// stream of unknown size makes the S3 client buffer a part of the maximum
+// size in memory for every open file.
+type BucketSink struct {
+	Store  storage.ObjectStore
+	Prefix string
+	// TempDir is where files are staged, the default directory for
+	// temporary files when empty.
+	TempDir string
+}
+
+func (s BucketSink) Create(ctx context.Context, name string) (File, error) {
+	file, err := os.CreateTemp(s.TempDir, "rpksi-export-*")
+	if err != nil {
+		return nil, err
+	}
+	return &bucketObject{ctx: ctx, store: s.Store, key: path.Join(s.Prefix, name), file: file}, nil
+}
+
+// bucketObject is an object being written by a BucketSink.
+type bucketObject struct {
+	ctx   context.Context
+	store storage.ObjectStore
+	key   string
+	file  *os.File
+}
+
+func (o *bucketObject) Write(p []byte) (int, error) {
+	return o.file.Write(p)
+}
+
+// Close uploads the staged file and removes it.
+func (o *bucketObject) Close() error {
+	defer os.Remove(o.file.Name())
+	defer o.file.Close()
+	size, err := o.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := o.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return o.store.PutObject(o.ctx, o.key, o.file, size)
+}
+
+// Abort removes the staged file without uploading it.
+func (o *bucketObject) Abort() error {
+	o.file.Close()
+	return os.Remove(o.file.Name())
+}
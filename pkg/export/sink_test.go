@@ -0,0 +1,120 @@
+package export
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"rpksi/pkg/storage"
+	"testing"
+)
+
+func TestBucketSink(t *testing.T) {
+	store := storage.NewMemoryStore()
+	tmp := t.TempDir()
+	sink := BucketSink{Store: store, Prefix: "exports", TempDir: tmp}
+	w, err := sink.Create(context.Background(), "topic=events/part.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"{\"offset\":1}\n", "{\"offset\":2}\n"} {
+		if _, err := io.WriteString(w, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.StatObject(context.Background(), "exports/topic=events/part.jsonl"); err == nil {
+		t.Error("object uploaded before the file was closed")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := store.StatObject(context.Background(), "exports/topic=events/part.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := store.GetObject(context.Background(), info.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "{\"offset\":1}\n{\"offset\":2}\n"; got != want {
+		t.Errorf("object holds %q, want %q", got, want)
+	}
+	if entries, err := os.ReadDir(tmp); err != nil || len(entries) > 0 {
+		t.Errorf("staged files left behind: %v %v", entries, err)
+	}
+}
+
+func TestBucketSinkAbort(t *testing.T) {
+	store := storage.NewMemoryStore()
+	tmp := t.TempDir()
+	sink := BucketSink{Store: store, Prefix: "exports", TempDir: tmp}
+	w, err := sink.Create(context.Background(), "topic=events/part.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "{\"offset\":1}\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if objects, err := store.ListObjects(context.Background(), ""); err != nil || len(objects) > 0 {
+		t.Errorf("objects uploaded: %v %v", objects, err)
+	}
+	if entries, err := os.ReadDir(tmp); err != nil || len(entries) > 0 {
+		t.Errorf("staged files left behind: %v %v", entries, err)
+	}
+}
+
+func TestDirSink(t *testing.T) {
+	dir := t.TempDir()
+	sink := DirSink{Dir: dir}
+	name := filepath.Join(dir, "topic=events", "part.jsonl")
+	create := func(data string) File {
+		w, err := sink.Create(context.Background(), "topic=events/part.jsonl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	contents := func() string {
+		data, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			return "<none>"
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	w := create("v1")
+	if got := contents(); got != "<none>" {
+		t.Errorf("file holds %q before it was closed", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(); got != "v1" {
+		t.Errorf("file holds %q, want v1", got)
+	}
+	// an aborted file leaves the previous one untouched
+	if err := create("v2").Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(); got != "v1" {
+		t.Errorf("file holds %q after an abort, want v1", got)
+	}
+	if entries, err := os.ReadDir(filepath.Dir(name)); err != nil || len(entries) != 1 {
+		t.Errorf("files %v %v, want only part.jsonl", entries, err)
+	}
+}
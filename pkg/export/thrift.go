@@ -0,0 +1,112 @@
+package export
+
+import "encoding/binary"
+
+// Thrift compact protocol types, as used by the Parquet metadata.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, which the
+// Parquet file and page metadata use.
+type thriftWriter struct {
+	buf []byte
+	// last field id of each open struct, field ids are delta encoded
+	last []int16
+}
+
+func (w *thriftWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.buf = append(w.buf, b[:n]...)
+}
+
+func (w *thriftWriter) varint(v int64) {
+	w.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := w.last[len(w.last)-1]
+	if delta := id - last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.varint(int64(id))
+	}
+	w.last[len(w.last)-1] = id
+}
+
+func (w *thriftWriter) beginStruct() {
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) endStruct() {
+	w.buf = append(w.buf, 0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) string(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *thriftWriter) listHeader(size int, elemType byte) {
+	if size < 15 {
+		w.buf = append(w.buf, byte(size)<<4|elemType)
+		return
+	}
+	w.buf = append(w.buf, 0xF0|elemType)
+	w.uvarint(uint64(size))
+}
+
+func (w *thriftWriter) i32List(id int16, values []int32) {
+	w.field(id, thriftList)
+	w.listHeader(len(values), thriftI32)
+	for _, v := range values {
+		w.varint(int64(v))
+	}
+}
+
+func (w *thriftWriter) stringList(id int16, values []string) {
+	w.field(id, thriftList)
+	w.listHeader(len(values), thriftBinary)
+	for _, v := range values {
+		w.uvarint(uint64(len(v)))
+		w.buf = append(w.buf, v...)
+	}
+}
+
+// structList writes a list of n structs, each written by fn between the
+// struct delimiters.
+func (w *thriftWriter) structList(id int16, n int, fn func(i int)) {
+	w.field(id, thriftList)
+	w.listHeader(n, thriftStruct)
+	for i := 0; i < n; i++ {
+		w.beginStruct()
+		fn(i)
+		w.endStruct()
+	}
+}
+
+// structField writes a struct field written by fn.
+func (w *thriftWriter) structField(id int16, fn func()) {
+	w.field(id, thriftStruct)
+	w.beginStruct()
+	fn()
+	w.endStruct()
+}
@@ -0,0 +1,162 @@
+package export
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// thriftReader decodes the Thrift compact protocol into generic values,
+// following the protocol specification rather than thriftWriter: structs
+// become maps of field id to value, lists become slices.
+type thriftReader struct {
+	buf []byte
+	err error
+}
+
+var errThriftShort = errors.New("thrift: unexpected end of data")
+
+func (r *thriftReader) byte() byte {
+	if r.err != nil || len(r.buf) == 0 {
+		r.err = errThriftShort
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errThriftShort
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 3:
+		return int8(r.byte())
+	case 4:
+		return int16(r.zigzag())
+	case 5:
+		return int32(r.zigzag())
+	case 6:
+		return r.zigzag()
+	case 8:
+		n := int(r.uvarint())
+		if r.err != nil || n > len(r.buf) {
+			r.err = errThriftShort
+			return nil
+		}
+		b := string(r.buf[:n])
+		r.buf = r.buf[n:]
+		return b
+	case 9:
+		header := r.byte()
+		size, elemType := int(header>>4), header&0x0f
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := []interface{}{}
+		for i := 0; i < size && r.err == nil; i++ {
+			if elemType == 1 || elemType == 2 {
+				list = append(list, r.byte() == 1)
+				continue
+			}
+			list = append(list, r.value(elemType))
+		}
+		return list
+	case 12:
+		return r.structValue()
+	}
+	r.err = fmt.Errorf("thrift: unsupported type %d", typ)
+	return nil
+}
+
+func (r *thriftReader) structValue() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for r.err == nil {
+		header := r.byte()
+		if header == 0 {
+			break
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(header & 0x0f)
+		last = id
+	}
+	return fields
+}
+
+func TestThriftWriter(t *testing.T) {
+	long := make([]string, 20)
+	for i := range long {
+		long[i] = fmt.Sprint(i)
+	}
+	w := thriftWriter{}
+	w.beginStruct()
+	w.i32(1, -5)
+	w.i64(2, 1<<40)
+	w.string(3, "rpksi")
+	w.i32List(4, []int32{0, 3})
+	w.stringList(5, long)
+	w.structField(40, func() {
+		w.i64(1, -1)
+		w.string(17, "")
+	})
+	w.structList(41, 2, func(i int) {
+		w.i32(1, int32(i))
+	})
+	w.i32(3000, 7)
+	w.endStruct()
+
+	r := thriftReader{buf: w.buf}
+	got := r.structValue()
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if len(r.buf) > 0 {
+		t.Errorf("%d bytes after the struct", len(r.buf))
+	}
+	longValues := make([]interface{}, len(long))
+	for i, s := range long {
+		longValues[i] = s
+	}
+	want := map[int16]interface{}{
+		1:  int32(-5),
+		2:  int64(1 << 40),
+		3:  "rpksi",
+		4:  []interface{}{int32(0), int32(3)},
+		5:  longValues,
+		40: map[int16]interface{}{1: int64(-1), 17: ""},
+		41: []interface{}{
+			map[int16]interface{}{1: int32(0)},
+			map[int16]interface{}{1: int32(1)},
+		},
+		3000: int32(7),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %v, want %v", got, want)
+	}
+}
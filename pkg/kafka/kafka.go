@@ -0,0 +1,227 @@
+// Package kafka is a minimal client for the Kafka API of Redpanda, covering
+// the metadata and produce requests needed to replay archived records. It
+// speaks plaintext only.
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	apiKeyProduce  = 0
+	apiKeyMetadata = 3
+)
+
+// DefaultTimeout bounds each request made by a Client.
+const DefaultTimeout = 30 * time.Second
+
+// maxResponseSize bounds the responses read, none of the requests made by a
+// Client gets a large response.
+const maxResponseSize = 64 << 20
+
+// Client sends requests to the brokers of a cluster, found through a
+// bootstrap broker.
+type Client struct {
+	Bootstrap string
+	ClientID  string
+	Timeout   time.Duration
+
+	mu      sync.Mutex
+	leaders map[topicPartition]string
+	conns   map[string]*conn
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// NewClient returns a client for the cluster reachable at bootstrap
+// (host:port).
+func NewClient(bootstrap string) *Client {
+	return &Client{
+		Bootstrap: bootstrap,
+		ClientID:  "rpksi",
+		Timeout:   DefaultTimeout,
+		leaders:   make(map[topicPartition]string),
+		conns:     make(map[string]*conn),
+	}
+}
+
+// Close closes every connection of the client.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var first error
+	for addr, conn := range c.conns {
+		if err := conn.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(c.conns, addr)
+	}
+	return first
+}
+
+// PartitionLeader returns the address of the broker leading a partition.
+func (c *Client) PartitionLeader(ctx context.Context, topic string, partition int32) (string, error) {
+	body := encoder{}
+	body.int32(1)
+	body.string(topic)
+	resp, err := c.request(ctx, c.Bootstrap, apiKeyMetadata, 1, body.buf)
+	if err != nil {
+		return "", err
+	}
+
+	d := decoder{buf: resp}
+	brokers := make(map[int32]string)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		if rack := d.int16(); rack > 0 {
+			d.take(int(rack))
+		}
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+	leader := int32(-1)
+	var topicErr, partitionErr Error
+	found := false
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		errorCode := Error(d.int16())
+		name := d.string()
+		d.int8() // is internal
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			partitionError := Error(d.int16())
+			index := d.int32()
+			leaderID := d.int32()
+			for k, r := 0, d.arrayLen(); k < r; k++ {
+				d.int32()
+			}
+			for k, r := 0, d.arrayLen(); k < r; k++ {
+				d.int32()
+			}
+			if name == topic && index == partition {
+				leader, partitionErr, found = leaderID, partitionError, true
+			}
+		}
+		if name == topic {
+			topicErr = errorCode
+		}
+	}
+	if d.err != nil {
+		return "", fmt.Errorf("metadata: %w", d.err)
+	}
+
+	if topicErr != 0 {
+		return "", fmt.Errorf("topic %s: %w", topic, topicErr)
+	}
+	if !found {
+		return "", fmt.Errorf("topic %s partition %d: %w", topic, partition, Error(3))
+	}
+	if partitionErr != 0 {
+		return "", fmt.Errorf("topic %s partition %d: %w", topic, partition, partitionErr)
+	}
+	addr, ok := brokers[leader]
+	if !ok {
+		return "", fmt.Errorf("topic %s partition %d: %w", topic, partition, Error(5))
+	}
+	return addr, nil
+}
+
+// request sends a request to the broker at addr and returns the response
+// body following the correlation id.
+func (c *Client) request(ctx context.Context, addr string, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	conn, err := c.conn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := conn.roundTrip(ctx, c.ClientID, c.Timeout, apiKey, apiVersion, body)
+	if err != nil {
+		// the connection state is unknown, open a new one next time
+		c.mu.Lock()
+		if c.conns[addr] == conn {
+			delete(c.conns, addr)
+		}
+		c.mu.Unlock()
+		conn.Close()
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	return resp, nil
+}
+
+func (c *Client) conn(ctx context.Context, addr string) (*conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.conns[addr]; ok {
+		return existing, nil
+	}
+	dialer := net.Dialer{Timeout: c.Timeout}
+	nc, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	created := &conn{Conn: nc}
+	c.conns[addr] = created
+	return created, nil
+}
+
+// conn is a broker connection carrying one request at a time.
+type conn struct {
+	net.Conn
+	mu            sync.Mutex
+	correlationID int32
+}
+
+func (c *conn) roundTrip(ctx context.Context, clientID string, timeout time.Duration, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.correlationID++
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	req := encoder{}
+	req.int32(0) // size, set below
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(c.correlationID)
+	req.string(clientID)
+	req.buf = append(req.buf, body...)
+	binary.BigEndian.PutUint32(req.buf, uint32(len(req.buf)-4))
+	if _, err := c.Write(req.buf); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c, size[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(size[:])
+	if length > maxResponseSize {
+		return nil, fmt.Errorf("kafka: response of %d bytes", length)
+	}
+	resp := make([]byte, length)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 4 {
+		return nil, errShortResponse
+	}
+	if id := int32(binary.BigEndian.Uint32(resp)); id != c.correlationID {
+		return nil, fmt.Errorf("kafka: correlation id %d, expected %d", id, c.correlationID)
+	}
+	return resp[4:], nil
+}
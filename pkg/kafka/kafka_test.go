@@ -0,0 +1,485 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"reflect"
+	"rpksi/pkg/segment"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRequest is a request read by a fakeBroker.
+type fakeRequest struct {
+	conn          int
+	apiKey        int16
+	apiVersion    int16
+	correlationID int32
+	clientID      string
+	body          []byte
+}
+
+// fakeBroker serves requests on a local listener. Each request is framed and
+// its header parsed following the protocol specification, then handle
+// returns the response following the size, correlation id included.
+type fakeBroker struct {
+	t      *testing.T
+	ln     net.Listener
+	handle func(req fakeRequest) []byte
+
+	mu       sync.Mutex
+	requests []fakeRequest
+	conns    int
+}
+
+func newFakeBroker(t *testing.T, handle func(req fakeRequest) []byte) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{t: t, ln: ln, handle: handle}
+	t.Cleanup(func() { ln.Close() })
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) addr() string {
+	return b.ln.Addr().String()
+}
+
+func (b *fakeBroker) port() int32 {
+	_, port, _ := net.SplitHostPort(b.addr())
+	n, _ := strconv.Atoi(port)
+	return int32(n)
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		nc, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns++
+		id := b.conns
+		b.mu.Unlock()
+		go b.serveConn(id, nc)
+	}
+}
+
+func (b *fakeBroker) serveConn(id int, nc net.Conn) {
+	defer nc.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(nc, size[:]); err != nil {
+			return
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(nc, frame); err != nil {
+			b.t.Errorf("request shorter than its size: %v", err)
+			return
+		}
+		if len(frame) < 10 {
+			b.t.Errorf("request header of %d bytes", len(frame))
+			return
+		}
+		clientIDSize := int(int16(binary.BigEndian.Uint16(frame[8:])))
+		if clientIDSize < 0 || 10+clientIDSize > len(frame) {
+			b.t.Errorf("client id of %d bytes in a request of %d", clientIDSize, len(frame))
+			return
+		}
+		req := fakeRequest{
+			conn:          id,
+			apiKey:        int16(binary.BigEndian.Uint16(frame)),
+			apiVersion:    int16(binary.BigEndian.Uint16(frame[2:])),
+			correlationID: int32(binary.BigEndian.Uint32(frame[4:])),
+			clientID:      string(frame[10 : 10+clientIDSize]),
+			body:          frame[10+clientIDSize:],
+		}
+		b.mu.Lock()
+		b.requests = append(b.requests, req)
+		b.mu.Unlock()
+
+		resp := b.handle(req)
+		out := make([]byte, 4+len(resp))
+		binary.BigEndian.PutUint32(out, uint32(len(resp)))
+		copy(out[4:], resp)
+		if _, err := nc.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+// apiKeys returns the api keys of the requests served so far.
+func (b *fakeBroker) apiKeys() []int16 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := []int16{}
+	for _, req := range b.requests {
+		keys = append(keys, req.apiKey)
+	}
+	return keys
+}
+
+// response returns a response to req with body.
+func response(req fakeRequest, body []byte) []byte {
+	e := encoder{}
+	e.int32(req.correlationID)
+	return append(e.buf, body...)
+}
+
+// metadataBody is a metadata v1 response for partition 0 of topic events,
+// led by broker 2 listening on port. Broker 1 has a rack.
+func metadataBody(port int32, topicErr, partitionErr int16, leader int32) []byte {
+	e := encoder{}
+	e.int32(2)
+	e.int32(1)
+	e.string("127.0.0.2")
+	e.int32(9092)
+	e.string("rack-a")
+	e.int32(2)
+	e.string("127.0.0.1")
+	e.int32(port)
+	e.int16(-1) // null rack
+	e.int32(1)  // controller id
+	e.int32(2)
+	e.int16(0)
+	e.string("other")
+	e.int8(0)
+	e.int32(1)
+	e.int16(0)
+	e.int32(0)
+	e.int32(1)
+	e.int32(0) // replicas
+	e.int32(0) // isr
+	e.int16(topicErr)
+	e.string("events")
+	e.int8(0)
+	if topicErr != 0 {
+		e.int32(0)
+		return e.buf
+	}
+	e.int32(1)
+	e.int16(partitionErr)
+	e.int32(0)
+	e.int32(leader)
+	e.int32(1)
+	e.int32(leader)
+	e.int32(1)
+	e.int32(leader)
+	return e.buf
+}
+
+// produceBody is a produce v3 response for partition 0 of topic events.
+func produceBody(errorCode int16, baseOffset int64) []byte {
+	e := encoder{}
+	e.int32(1)
+	e.string("events")
+	e.int32(1)
+	e.int32(0)
+	e.int16(errorCode)
+	e.int64(baseOffset)
+	e.int64(-1) // log append time
+	e.int32(0)  // throttle time
+	return e.buf
+}
+
+// checkProduceRequest checks a produce v3 request for partition 0 of topic
+// events, and returns the records of its batch.
+func checkProduceRequest(t *testing.T, body []byte) []segment.Record {
+	t.Helper()
+	d := decoder{buf: body}
+	if transactionalID := d.int16(); transactionalID != -1 {
+		t.Errorf("transactional id of %d bytes, want null", transactionalID)
+	}
+	if acks := d.int16(); acks != -1 {
+		t.Errorf("acks %d, want -1", acks)
+	}
+	if timeout := d.int32(); timeout != int32(DefaultTimeout/time.Millisecond) {
+		t.Errorf("timeout %d", timeout)
+	}
+	if topics := d.int32(); topics != 1 {
+		t.Fatalf("%d topics", topics)
+	}
+	if topic := d.string(); topic != "events" {
+		t.Errorf("topic %q", topic)
+	}
+	if partitions := d.int32(); partitions != 1 {
+		t.Fatalf("%d partitions", partitions)
+	}
+	if partition := d.int32(); partition != 0 {
+		t.Errorf("partition %d", partition)
+	}
+	batch := d.take(int(d.int32()))
+	if d.err != nil || len(d.buf) > 0 {
+		t.Fatalf("produce request: %v, %d bytes left", d.err, len(d.buf))
+	}
+
+	// the batch header, as laid out by the Kafka protocol
+	if len(batch) < 61 {
+		t.Fatalf("batch of %d bytes", len(batch))
+	}
+	if length := binary.BigEndian.Uint32(batch[8:]); int(length) != len(batch)-12 {
+		t.Errorf("batch length %d, want %d", length, len(batch)-12)
+	}
+	if magic := batch[16]; magic != 2 {
+		t.Errorf("magic %d", magic)
+	}
+	crc := binary.BigEndian.Uint32(batch[17:])
+	if want := crc32.Checksum(batch[21:], crc32.MakeTable(crc32.Castagnoli)); crc != want {
+		t.Errorf("batch crc %08x, want %08x", crc, want)
+	}
+	b := &segment.Batch{
+		Header: segment.Header{
+			BaseOffset:      int64(binary.BigEndian.Uint64(batch)),
+			CRC:             crc,
+			Attributes:      segment.Attributes(binary.BigEndian.Uint16(batch[21:])),
+			LastOffsetDelta: int32(binary.BigEndian.Uint32(batch[23:])),
+			FirstTimestamp:  int64(binary.BigEndian.Uint64(batch[27:])),
+			MaxTimestamp:    int64(binary.BigEndian.Uint64(batch[35:])),
+			ProducerID:      int64(binary.BigEndian.Uint64(batch[43:])),
+			ProducerEpoch:   int16(binary.BigEndian.Uint16(batch[51:])),
+			BaseSequence:    int32(binary.BigEndian.Uint32(batch[53:])),
+			RecordCount:     int32(binary.BigEndian.Uint32(batch[57:])),
+		},
+		Payload: batch[61:],
+	}
+	if !b.CRCValid() {
+		t.Error("batch crc does not match its records")
+	}
+	if b.ProducerID != -1 || b.ProducerEpoch != -1 || b.BaseSequence != -1 || b.Attributes != 0 {
+		t.Errorf("batch header %+v", b.Header)
+	}
+	if b.LastOffsetDelta != b.RecordCount-1 {
+		t.Errorf("last offset delta %d for %d records", b.LastOffsetDelta, b.RecordCount)
+	}
+	records, err := b.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxTimestamp := b.FirstTimestamp
+	for _, record := range records {
+		if record.Timestamp < b.FirstTimestamp {
+			t.Errorf("record at %d before the first timestamp %d", record.Timestamp, b.FirstTimestamp)
+		}
+		if record.Timestamp > maxTimestamp {
+			maxTimestamp = record.Timestamp
+		}
+	}
+	if b.MaxTimestamp != maxTimestamp {
+		t.Errorf("max timestamp %d, want %d", b.MaxTimestamp, maxTimestamp)
+	}
+	return records
+}
+
+func TestProduce(t *testing.T) {
+	var broker *fakeBroker
+	broker = newFakeBroker(t, func(req fakeRequest) []byte {
+		switch req.apiKey {
+		case apiKeyMetadata:
+			return response(req, metadataBody(broker.port(), 0, 0, 2))
+		case apiKeyProduce:
+			return response(req, produceBody(0, 4000))
+		}
+		t.Errorf("unexpected api key %d", req.apiKey)
+		return response(req, nil)
+	})
+	c := NewClient(broker.addr())
+	defer c.Close()
+
+	records := []segment.Record{
+		{Timestamp: 1653609600005, Key: []byte("k0"), Value: []byte("v0")},
+		{Timestamp: 1653609600000, Value: []byte{}, Headers: []segment.RecordHeader{
+			{Key: "trace", Value: []byte("abc")},
+			{Key: "null"},
+		}},
+		{Timestamp: 1653609600009, Key: []byte{}},
+	}
+	for i := 0; i < 2; i++ {
+		offset, err := c.Produce(context.Background(), "events", 0, records)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != 4000 {
+			t.Errorf("produced at offset %d, want 4000", offset)
+		}
+	}
+
+	// the leader is looked up once, it is the bootstrap broker and shares its
+	// connection
+	if keys := broker.apiKeys(); !reflect.DeepEqual(keys, []int16{apiKeyMetadata, apiKeyProduce, apiKeyProduce}) {
+		t.Fatalf("requests %v", keys)
+	}
+	for i, req := range broker.requests {
+		if req.conn != 1 || req.correlationID != int32(i+1) || req.clientID != "rpksi" {
+			t.Errorf("request %d on connection %d with correlation id %d and client id %q", i, req.conn, req.correlationID, req.clientID)
+		}
+		wantVersion := int16(3)
+		if req.apiKey == apiKeyMetadata {
+			wantVersion = 1
+		}
+		if req.apiVersion != wantVersion {
+			t.Errorf("request %d version %d, want %d", i, req.apiVersion, wantVersion)
+		}
+	}
+	metadata := decoder{buf: broker.requests[0].body}
+	if n, topic := metadata.int32(), metadata.string(); n != 1 || topic != "events" || len(metadata.buf) > 0 {
+		t.Errorf("metadata request for %d topics, %q", n, topic)
+	}
+
+	got := checkProduceRequest(t, broker.requests[1].body)
+	if len(got) != len(records) {
+		t.Fatalf("%d records produced, want %d", len(got), len(records))
+	}
+	for i, record := range got {
+		want := records[i]
+		if record.Offset != int64(i) || record.Timestamp != want.Timestamp ||
+			!reflect.DeepEqual(record.Key, want.Key) || !reflect.DeepEqual(record.Value, want.Value) ||
+			len(record.Headers) != len(want.Headers) || (len(want.Headers) > 0 && !reflect.DeepEqual(record.Headers, want.Headers)) {
+			t.Errorf("record %d produced as %+v, want %+v", i, record, want)
+		}
+	}
+}
+
+func TestProduceRetries(t *testing.T) {
+	var broker *fakeBroker
+	produced := 0
+	broker = newFakeBroker(t, func(req fakeRequest) []byte {
+		if req.apiKey == apiKeyMetadata {
+			return response(req, metadataBody(broker.port(), 0, 0, 2))
+		}
+		produced++
+		if produced == 1 {
+			return response(req, produceBody(6, -1)) // NOT_LEADER_OR_FOLLOWER
+		}
+		return response(req, produceBody(0, 12))
+	})
+	c := NewClient(broker.addr())
+	defer c.Close()
+
+	offset, err := c.Produce(context.Background(), "events", 0, []segment.Record{{Value: []byte("v")}})
+	if err != nil || offset != 12 {
+		t.Fatalf("produced at %d: %v", offset, err)
+	}
+	// the leader is looked up again after the retriable error
+	want := []int16{apiKeyMetadata, apiKeyProduce, apiKeyMetadata, apiKeyProduce}
+	if keys := broker.apiKeys(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("requests %v, want %v", keys, want)
+	}
+}
+
+func TestProduceError(t *testing.T) {
+	var broker *fakeBroker
+	broker = newFakeBroker(t, func(req fakeRequest) []byte {
+		if req.apiKey == apiKeyMetadata {
+			return response(req, metadataBody(broker.port(), 0, 0, 2))
+		}
+		return response(req, produceBody(87, -1)) // INVALID_RECORD
+	})
+	c := NewClient(broker.addr())
+	defer c.Close()
+
+	_, err := c.Produce(context.Background(), "events", 0, []segment.Record{{Value: []byte("v")}})
+	if !errors.Is(err, Error(87)) || !strings.Contains(err.Error(), "INVALID_RECORD") {
+		t.Errorf("produce: %v, want %v", err, Error(87))
+	}
+	if keys := broker.apiKeys(); !reflect.DeepEqual(keys, []int16{apiKeyMetadata, apiKeyProduce}) {
+		t.Errorf("requests %v, a non retriable error was retried", keys)
+	}
+}
+
+func TestPartitionLeader(t *testing.T) {
+	for _, tt := range []struct {
+		name                   string
+		topicErr, partitionErr int16
+		leader, partition      int32
+		want                   error
+	}{
+		{"leader", 0, 0, 2, 0, nil},
+		{"topic error", 29, 0, 2, 0, Error(29)},
+		{"partition error", 0, 5, -1, 0, Error(5)},
+		{"unknown partition", 0, 0, 2, 1, Error(3)},
+		{"unknown leader", 0, 0, 3, 0, Error(5)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var broker *fakeBroker
+			broker = newFakeBroker(t, func(req fakeRequest) []byte {
+				return response(req, metadataBody(broker.port(), tt.topicErr, tt.partitionErr, tt.leader))
+			})
+			c := NewClient(broker.addr())
+			defer c.Close()
+
+			leader, err := c.PartitionLeader(context.Background(), "events", tt.partition)
+			if tt.want == nil {
+				if err != nil || leader != broker.addr() {
+					t.Errorf("leader %s: %v, want %s", leader, err, broker.addr())
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("leader %s: %v, want %v", leader, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBadResponses(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		resp func(req fakeRequest) []byte
+		err  string
+	}{
+		{"correlation id", func(req fakeRequest) []byte {
+			req.correlationID++
+			return response(req, nil)
+		}, "correlation id"},
+		{"no correlation id", func(req fakeRequest) []byte {
+			return []byte{0, 0}
+		}, errShortResponse.Error()},
+		{"truncated body", func(req fakeRequest) []byte {
+			return response(req, metadataBody(9092, 0, 0, 2)[:20])
+		}, errShortResponse.Error()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := newFakeBroker(t, tt.resp)
+			c := NewClient(broker.addr())
+			defer c.Close()
+			for i := 0; i < 2; i++ {
+				if _, err := c.PartitionLeader(context.Background(), "events", 0); err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("leader: %v, want %q", err, tt.err)
+				}
+			}
+		})
+	}
+
+	// connections are dropped after a failed round trip
+	broker := newFakeBroker(t, func(req fakeRequest) []byte {
+		req.correlationID++
+		return response(req, nil)
+	})
+	c := NewClient(broker.addr())
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		c.PartitionLeader(context.Background(), "events", 0)
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if broker.conns != 2 {
+		t.Errorf("%d connections for two failed requests, want 2", broker.conns)
+	}
+}
+
+func TestProduceNothing(t *testing.T) {
+	offset, err := NewClient("127.0.0.1:1").Produce(context.Background(), "events", 0, nil)
+	if err != nil || offset != -1 {
+		t.Errorf("producing no records: %d, %v", offset, err)
+	}
+}
@@ -0,0 +1,154 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"rpksi/pkg/segment"
+	"time"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// maxProduceAttempts bounds the attempts to produce a batch when the broker
+// returns a retriable error, such as a leadership change.
+const maxProduceAttempts = 5
+
+// EncodeRecordBatch encodes records as an uncompressed Kafka record batch
+// (magic 2) with create time timestamps. Offsets are assigned by the broker,
+// the offsets of the records are not kept.
+func EncodeRecordBatch(records []segment.Record) []byte {
+	var base, max int64
+	for i, record := range records {
+		if i == 0 || record.Timestamp < base {
+			base = record.Timestamp
+		}
+		if i == 0 || record.Timestamp > max {
+			max = record.Timestamp
+		}
+	}
+
+	body := encoder{}
+	body.int16(0) // attributes
+	body.int32(int32(len(records) - 1))
+	body.int64(base)
+	body.int64(max)
+	body.int64(-1) // producer id
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(records)))
+	for i, record := range records {
+		r := encoder{}
+		r.int8(0)
+		r.varint(record.Timestamp - base)
+		r.varint(int64(i))
+		r.varbytes(record.Key)
+		r.varbytes(record.Value)
+		r.varint(int64(len(record.Headers)))
+		for _, header := range record.Headers {
+			r.varbytes([]byte(header.Key))
+			r.varbytes(header.Value)
+		}
+		body.varint(int64(len(r.buf)))
+		body.buf = append(body.buf, r.buf...)
+	}
+
+	batch := encoder{}
+	batch.int64(0) // base offset
+	batch.int32(int32(4 + 1 + 4 + len(body.buf)))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(body.buf, crc32c)))
+	batch.buf = append(batch.buf, body.buf...)
+	return batch.buf
+}
+
+// Produce writes records to a partition as a single batch, waiting for all
+// in-sync replicas, and returns the offset assigned to the first record.
+func (c *Client) Produce(ctx context.Context, topic string, partition int32, records []segment.Record) (int64, error) {
+	if len(records) == 0 {
+		return -1, nil
+	}
+	batch := EncodeRecordBatch(records)
+	var err error
+	for attempt := 1; attempt <= maxProduceAttempts; attempt++ {
+		var offset int64
+		offset, err = c.produce(ctx, topic, partition, batch)
+		if err == nil {
+			return offset, nil
+		}
+		var kerr Error
+		if !errors.As(err, &kerr) || !kerr.Retriable() {
+			return -1, err
+		}
+		// look the leader up again before retrying
+		c.mu.Lock()
+		delete(c.leaders, topicPartition{topic, partition})
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-time.After(time.Duration(attempt) * 250 * time.Millisecond):
+		}
+	}
+	return -1, err
+}
+
+func (c *Client) produce(ctx context.Context, topic string, partition int32, batch []byte) (int64, error) {
+	tp := topicPartition{topic, partition}
+	c.mu.Lock()
+	leader, ok := c.leaders[tp]
+	c.mu.Unlock()
+	if !ok {
+		var err error
+		leader, err = c.PartitionLeader(ctx, topic, partition)
+		if err != nil {
+			return -1, err
+		}
+		c.mu.Lock()
+		c.leaders[tp] = leader
+		c.mu.Unlock()
+	}
+
+	body := encoder{}
+	body.nullableString(nil) // transactional id
+	body.int16(-1)           // acks from all in-sync replicas
+	body.int32(int32(c.Timeout / time.Millisecond))
+	body.int32(1)
+	body.string(topic)
+	body.int32(1)
+	body.int32(partition)
+	body.bytes(batch)
+	resp, err := c.request(ctx, leader, apiKeyProduce, 3, body.buf)
+	if err != nil {
+		return -1, err
+	}
+
+	d := decoder{buf: resp}
+	offset := int64(-1)
+	var produceErr Error
+	found := false
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		name := d.string()
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			index := d.int32()
+			errorCode := Error(d.int16())
+			baseOffset := d.int64()
+			d.int64() // log append time
+			if name == topic && index == partition {
+				offset, produceErr, found = baseOffset, errorCode, true
+			}
+		}
+	}
+	if d.err != nil {
+		return -1, fmt.Errorf("produce: %w", d.err)
+	}
+	if !found {
+		return -1, fmt.Errorf("produce: no response for %s/%d", topic, partition)
+	}
+	if produceErr != 0 {
+		return -1, fmt.Errorf("produce to %s/%d: %w", topic, partition, produceErr)
+	}
+	return offset, nil
+}
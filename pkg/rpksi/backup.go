@@ -0,0 +1,116 @@
+package rpksi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultBackupPrefix is where manifest backups are stored unless
+	// Client.BackupPrefix says otherwise.
+	DefaultBackupPrefix = "rpksi-backups"
+	// BackupTimeFormat is the timestamp format used in backup keys.
+	BackupTimeFormat = "20060102T150405.000Z"
+)
+
+// ManifestBackup is a copy of a partition manifest taken before it was
+// rewritten. Backups are stored as <prefix>/<manifest key>.<timestamp>.
+type ManifestBackup struct {
+	Key         string
+	ManifestKey string
+	Topic       string
+	Partition   int
+	Revision    int
+	Taken       time.Time
+	Size        int64
+}
+
+func (c *Client) backupPrefix() string {
+	if len(c.BackupPrefix) == 0 {
+		return DefaultBackupPrefix
+	}
+	return strings.TrimSuffix(c.BackupPrefix, "/")
+}
+
+// BackupManifest copies the manifest at key to a backup object stamped with
+// at, and returns the key of the copy.
+func (c *Client) BackupManifest(ctx context.Context, key string, at time.Time) (string, error) {
+	backupKey := fmt.Sprintf("%s/%s.%s", c.backupPrefix(), key, at.UTC().Format(BackupTimeFormat))
+	return backupKey, c.copyObject(ctx, key, backupKey)
+}
+
+// ManifestBackups lists the backups of a topic partition, oldest first.
+func (c *Client) ManifestBackups(ctx context.Context, topic string, partition int) ([]ManifestBackup, error) {
+	prefix := c.backupPrefix() + "/"
+	objects, err := c.Store.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var backups []ManifestBackup
+	for _, object := range objects {
+		backup, ok := parseBackupKey(strings.TrimPrefix(object.Key, prefix))
+		if !ok || backup.Topic != topic || backup.Partition != partition {
+			continue
+		}
+		backup.Key = object.Key
+		backup.Size = object.Size
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Taken.Before(backups[j].Taken)
+	})
+	return backups, nil
+}
+
+// RestoreManifest puts a backup back in place of its partition manifest and
+// synchronizes the partition. The manifest being replaced is backed up first,
+// and ErrManifestConflict is returned if it changes before it is replaced.
+func (c *Client) RestoreManifest(ctx context.Context, backup ManifestBackup) error {
+	data, err := c.readObject(ctx, backup.Key)
+	if err != nil {
+		return err
+	}
+	info, err := c.Store.StatObject(ctx, backup.ManifestKey)
+	if err != nil {
+		return err
+	}
+	current, err := c.BackupManifest(ctx, backup.ManifestKey, time.Now())
+	if err != nil {
+		return err
+	}
+	c.logf("  backed up %s -> %s", backup.ManifestKey, current)
+	if err := c.putIfMatch(ctx, backup.ManifestKey, data, info.ETag); err != nil {
+		return fmt.Errorf("%s: %w", backup.ManifestKey, err)
+	}
+	c.logf("  restored %s -> %s", backup.Key, backup.ManifestKey)
+	c.logf("  POST request to %s", c.SyncLocalStateURL(backup.Topic, backup.Partition))
+	return c.SyncLocalState(ctx, backup.Topic, backup.Partition)
+}
+
+// parseBackupKey parses a backup key with the backup prefix removed.
+func parseBackupKey(key string) (ManifestBackup, bool) {
+	i := -1
+	for _, name := range []string{ManifestFileName, BinaryManifestFileName} {
+		if j := strings.LastIndex(key, "/"+name+"."); j >= 0 {
+			i = j + len("/"+name)
+			break
+		}
+	}
+	if i < 0 {
+		return ManifestBackup{}, false
+	}
+	taken, err := time.Parse(BackupTimeFormat, key[i+1:])
+	if err != nil || !isManifestKey(key[:i]) {
+		return ManifestBackup{}, false
+	}
+	backup := ManifestBackup{ManifestKey: key[:i], Taken: taken}
+	parts := strings.Split(backup.ManifestKey, "/")
+	backup.Topic = parts[3]
+	if _, err := fmt.Sscanf(parts[4], "%d_%d", &backup.Partition, &backup.Revision); err != nil {
+		return ManifestBackup{}, false
+	}
+	return backup, true
+}
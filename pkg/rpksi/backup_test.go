@@ -0,0 +1,142 @@
+package rpksi
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBackupKey(t *testing.T) {
+	taken := time.Date(2022, 5, 27, 13, 4, 5, 6e6, time.UTC)
+	for _, tt := range []struct {
+		key  string
+		want ManifestBackup
+		ok   bool
+	}{
+		{
+			key:  "a0000000/meta/kafka/events/3_7/manifest.json.20220527T130405.006Z",
+			want: ManifestBackup{ManifestKey: "a0000000/meta/kafka/events/3_7/manifest.json", Topic: "events", Partition: 3, Revision: 7, Taken: taken},
+			ok:   true,
+		},
+		{
+			key:  "a0000000/meta/kafka/events/3_7/manifest.bin.20220527T130405.006Z",
+			want: ManifestBackup{ManifestKey: "a0000000/meta/kafka/events/3_7/manifest.bin", Topic: "events", Partition: 3, Revision: 7, Taken: taken},
+			ok:   true,
+		},
+		{key: "a0000000/meta/kafka/events/3_7/manifest.json"},
+		{key: "a0000000/meta/kafka/events/3_7/manifest.json.20220527"},
+		{key: "a0000000/meta/kafka/events/3_7/topic_manifest.json.20220527T130405.006Z"},
+		{key: "meta/kafka/events/3_7/manifest.json.20220527T130405.006Z"},
+		{key: "a0000000/meta/kafka/events/three_7/manifest.json.20220527T130405.006Z"},
+	} {
+		got, ok := parseBackupKey(tt.key)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, %v, want %+v, %v", tt.key, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestManifestBackups(t *testing.T) {
+	c, store := newTestClient()
+	c.BackupPrefix = "backups/"
+	events := testManifest("events", 0, 3)
+	other := testManifest("events", 1, 1)
+	orders := testManifest("orders", 0, 1)
+	for _, m := range []Manifest{events, other, orders} {
+		putManifest(t, store, m)
+	}
+	first := time.Date(2022, 5, 27, 0, 0, 0, 0, time.UTC)
+	var want []string
+	for _, at := range []time.Time{first.Add(time.Hour), first} {
+		key, err := c.BackupManifest(context.Background(), manifestKey(events), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append([]string{key}, want...)
+	}
+	for _, m := range []Manifest{other, orders} {
+		if _, err := c.BackupManifest(context.Background(), manifestKey(m), first); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putObject(t, store, "backups/notes.txt", "not a backup")
+
+	backups, err := c.ManifestBackups(context.Background(), "events", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, backup := range backups {
+		got = append(got, backup.Key)
+		if backup.ManifestKey != manifestKey(events) || backup.Size != int64(len(mustJSON(t, events))) {
+			t.Errorf("%s: backup of %s holding %d bytes", backup.Key, backup.ManifestKey, backup.Size)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("backups %v, want %v", got, want)
+	}
+	if want := "backups/" + manifestKey(events) + ".20220527T000000.000Z"; got[0] != want {
+		t.Errorf("backup key %s, want %s", got[0], want)
+	}
+}
+
+func TestRestoreManifest(t *testing.T) {
+	c, store, manifests := newDeletionTest(t)
+	m := manifests[0]
+	if _, err := c.BackupManifest(context.Background(), manifestKey(m), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	changed := readTestManifest(t, c, m)
+	delete(changed.Segments, "0-1-v1.log")
+	putManifest(t, store, changed)
+
+	backups, err := c.ManifestBackups(context.Background(), m.Topic, m.Partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RestoreManifest(context.Background(), backups[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := segmentNames(readTestManifest(t, c, m)); !reflect.DeepEqual(got, segmentNames(m)) {
+		t.Errorf("restored segments %v, want %v", got, segmentNames(m))
+	}
+	// the replaced manifest was backed up
+	backups, err = c.ManifestBackups(context.Background(), m.Topic, m.Partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("%d backups, want 2", len(backups))
+	}
+	replaced, err := c.ReadManifest(context.Background(), backups[1].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := segmentNames(replaced); !reflect.DeepEqual(got, segmentNames(changed)) {
+		t.Errorf("backed up segments %v, want %v", got, segmentNames(changed))
+	}
+}
+
+func TestRestoreManifestConflict(t *testing.T) {
+	c, store, manifests := newDeletionTest(t)
+	m := manifests[0]
+	if _, err := c.BackupManifest(context.Background(), manifestKey(m), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := c.ManifestBackups(context.Background(), m.Topic, m.Partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	racing, appended := newRacingStore(t, c, store, manifests[:1], 1)
+	c.Store = racing
+
+	if err := c.RestoreManifest(context.Background(), backups[0]); !errors.Is(err, ErrManifestConflict) {
+		t.Fatalf("restore over a changed manifest: %v, want %v", err, ErrManifestConflict)
+	}
+	// the manifest written by the archiver is kept
+	if current := readTestManifest(t, c, m); len(*appended) != 1 || !reflect.DeepEqual(segmentNames(current), append(segmentNames(m), (*appended)[0])) {
+		t.Errorf("segments %v after the conflict, appended %v", segmentNames(current), *appended)
+	}
+}
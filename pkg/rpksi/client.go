@@ -0,0 +1,265 @@
+// Package rpksi reads and manages the topic segments Redpanda archives to
+// S3-compatible storage through shadow indexing.
+package rpksi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"rpksi/pkg/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrManifestConflict is returned when a manifest was changed, usually by the
+// Redpanda archiver, since it was read.
+var ErrManifestConflict = errors.New("manifest changed since it was read")
+
+// Client works with the archived topics found in an object store, and with
+// the Redpanda admin API when local state has to be synchronized.
+type Client struct {
+	Store      storage.ObjectStore
+	AdminAPI   string
+	UseSSL     bool
+	HTTPClient *http.Client
+	// BackupPrefix is where manifests are backed up before they are
+	// rewritten, it defaults to DefaultBackupPrefix.
+	BackupPrefix string
+	// Log receives progress messages, it defaults to io.Discard.
+	Log io.Writer
+}
+
+// NewClient creates a client for the given store and admin endpoint.
+func NewClient(store storage.ObjectStore, adminAPI string, useSSL bool) *Client {
+	return &Client{
+		Store:        store,
+		AdminAPI:     adminAPI,
+		UseSSL:       useSSL,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		BackupPrefix: DefaultBackupPrefix,
+		Log:          io.Discard,
+	}
+}
+
+func (c *Client) logf(format string, a ...interface{}) {
+	fmt.Fprintf(c.Log, format+"\n", a...)
+}
+
+// Manifests reads every partition manifest in the bucket, optionally limited
+// to a single topic.
+func (c *Client) Manifests(ctx context.Context, topic string) ([]ManifestObject, error) {
+	objects, err := c.Store.ListObjects(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	manifests, _, err := c.readManifests(ctx, objects, topic)
+	return manifests, err
+}
+
+// readManifests reads the partition manifests found in a bucket listing.
+// Manifests that cannot be decoded are skipped with a warning and their keys
+// returned, so that one manifest in an unknown layout does not stop a run
+// over the whole bucket.
+func (c *Client) readManifests(ctx context.Context, objects []storage.ObjectInfo, topic string) ([]ManifestObject, []string, error) {
+	// Redpanda leaves the JSON manifest of a partition behind when it
+	// switches to the binary one, which is then the current manifest
+	binary := make(map[string]bool)
+	for _, object := range objects {
+		if path.Base(object.Key) == BinaryManifestFileName {
+			binary[path.Dir(object.Key)] = true
+		}
+	}
+	var manifests []ManifestObject
+	var undecodable []string
+	for _, object := range objects {
+		if !isManifestKey(object.Key) {
+			continue
+		}
+		if path.Base(object.Key) == ManifestFileName && binary[path.Dir(object.Key)] {
+			continue
+		}
+		if len(topic) > 0 && topic != strings.Split(object.Key, "/")[3] {
+			continue
+		}
+		data, err := c.readObject(ctx, object.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		manifest, err := decodeManifest(data)
+		if err != nil {
+			c.logf("warning: %s: %v, skipping it", object.Key, err)
+			undecodable = append(undecodable, object.Key)
+			continue
+		}
+		// the listing happens before the read, so a manifest rewritten in
+		// between is seen as changed when it is written back
+		manifests = append(manifests, ManifestObject{Key: object.Key, ETag: object.ETag, Manifest: manifest})
+	}
+	return manifests, undecodable, nil
+}
+
+// ReadManifest reads and decodes the partition manifest stored at key, in
+// either the JSON or the binary format.
+func (c *Client) ReadManifest(ctx context.Context, key string) (Manifest, error) {
+	data, err := c.readObject(ctx, key)
+	if err != nil {
+		return Manifest{}, err
+	}
+	manifest, err := decodeManifest(data)
+	if err != nil {
+		return manifest, fmt.Errorf("%s: %w", key, err)
+	}
+	return manifest, nil
+}
+
+// decodeManifest decodes a partition manifest in either the JSON or the
+// binary format.
+func decodeManifest(data []byte) (Manifest, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var manifest Manifest
+		err := json.Unmarshal(data, &manifest)
+		return manifest, err
+	}
+	return DecodeBinaryManifest(data)
+}
+
+// ReadManifestObject reads the partition manifest stored at key along with its
+// ETag.
+func (c *Client) ReadManifestObject(ctx context.Context, key string) (ManifestObject, error) {
+	info, err := c.Store.StatObject(ctx, key)
+	if err != nil {
+		return ManifestObject{}, err
+	}
+	manifest, err := c.ReadManifest(ctx, key)
+	if err != nil {
+		return ManifestObject{}, err
+	}
+	return ManifestObject{Key: key, ETag: info.ETag, Manifest: manifest}, nil
+}
+
+func (c *Client) readObject(ctx context.Context, key string) ([]byte, error) {
+	reader, err := c.Store.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// WriteManifest encodes manifest in the format of key and uploads it.
+func (c *Client) WriteManifest(ctx context.Context, key string, manifest Manifest) error {
+	data, err := encodeManifest(key, manifest)
+	if err != nil {
+		return err
+	}
+	return c.Store.PutObject(ctx, key, bytes.NewReader(data), int64(len(data)))
+}
+
+// WriteManifestIfMatch uploads manifest to key only if the object still has
+// the given ETag, and returns ErrManifestConflict otherwise. Stores without
+// If-Match support are checked right before the put instead, which narrows
+// the race with the archiver but cannot close it. An empty etag writes
+// unconditionally.
+func (c *Client) WriteManifestIfMatch(ctx context.Context, key string, manifest Manifest, etag string) error {
+	if len(etag) == 0 {
+		return c.WriteManifest(ctx, key, manifest)
+	}
+	data, err := encodeManifest(key, manifest)
+	if err != nil {
+		return err
+	}
+	return c.putIfMatch(ctx, key, data, etag)
+}
+
+// putIfMatch uploads data to key only if the object still has the given
+// ETag, see WriteManifestIfMatch.
+func (c *Client) putIfMatch(ctx context.Context, key string, data []byte, etag string) error {
+	if store, ok := c.Store.(storage.ConditionalPutter); ok {
+		err := store.PutObjectIfMatch(ctx, key, bytes.NewReader(data), int64(len(data)), etag)
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return ErrManifestConflict
+		}
+		return err
+	}
+	info, err := c.Store.StatObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	if info.ETag != etag {
+		return ErrManifestConflict
+	}
+	return c.Store.PutObject(ctx, key, bytes.NewReader(data), int64(len(data)))
+}
+
+// SyncLocalStateURL is the admin API endpoint that makes Redpanda reload the
+// remote state of a partition.
+func (c *Client) SyncLocalStateURL(topic string, partition int) string {
+	protocol := "http"
+	if c.UseSSL {
+		protocol = "https"
+	}
+	return fmt.Sprintf("%s://%s/v1/shadow_indexing/sync_local_state/%s/%d", protocol, c.AdminAPI, topic, partition)
+}
+
+// SyncLocalState asks Redpanda to synchronize a partition with the bucket.
+func (c *Client) SyncLocalState(ctx context.Context, topic string, partition int) error {
+	request, err := http.NewRequestWithContext(ctx, "POST", c.SyncLocalStateURL(topic, partition), new(bytes.Buffer))
+	if err != nil {
+		return err
+	}
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("sync_local_state %s/%d: %s", topic, partition, response.Status)
+	}
+	return nil
+}
+
+// encodeManifest encodes manifest in JSON. Binary manifests are refused with
+// ErrBinaryManifestReadOnly until the serde layout rpksi decodes has been
+// checked against manifests written by Redpanda.
+func encodeManifest(key string, manifest Manifest) ([]byte, error) {
+	if path.Base(key) == BinaryManifestFileName {
+		return nil, ErrBinaryManifestReadOnly
+	}
+	return json.Marshal(manifest)
+}
+
+// parseManifestKey returns the topic, partition and revision of a partition
+// manifest key.
+func parseManifestKey(key string) (string, int, int, bool) {
+	if !isManifestKey(key) {
+		return "", 0, 0, false
+	}
+	parts := strings.Split(key, "/")
+	fields := strings.Split(parts[4], "_")
+	if len(fields) != 2 {
+		return "", 0, 0, false
+	}
+	partition, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return "", 0, 0, false
+	}
+	revision, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, 0, false
+	}
+	return parts[3], partition, revision, true
+}
+
+// isManifestKey reports whether key is a partition manifest, which are stored
+// as <hash>/meta/<namespace>/<topic>/<partition>_<revision>/manifest.json, or
+// manifest.bin in the binary format.
+func isManifestKey(key string) bool {
+	parts := strings.Split(key, "/")
+	return len(parts) == 6 && parts[1] == "meta" && parts[2] == Namespace && (parts[5] == ManifestFileName || parts[5] == BinaryManifestFileName)
+}
@@ -0,0 +1,194 @@
+package rpksi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"rpksi/pkg/kafka"
+	"rpksi/pkg/segment"
+	"rpksi/pkg/storage"
+	"testing"
+)
+
+const testHash = "a0000000"
+
+func newTestClient() (*Client, *storage.MemoryStore) {
+	store := storage.NewMemoryStore()
+	return NewClient(store, "localhost:9644", false), store
+}
+
+// testSegment returns a segment of the offsets base to committed, named
+// <base>-1-v1.log and holding one record per millisecond from the epoch.
+func testSegment(base, committed uint64) (string, Segment) {
+	return fmt.Sprintf("%d-1-v1.log", base), Segment{
+		SizeBytes:       1024,
+		BaseOffset:      base,
+		CommittedOffset: committed,
+		BaseTimestamp:   base,
+		MaxTimestamp:    committed,
+		ArchiverTerm:    1,
+	}
+}
+
+// testManifest returns the manifest of a partition of topic holding
+// consecutive segments of ten offsets each.
+func testManifest(topic string, partition, segments int) Manifest {
+	m := Manifest{
+		Version:   1,
+		Namespace: Namespace,
+		Topic:     topic,
+		Partition: partition,
+		Revision:  7,
+		Segments:  make(map[string]Segment),
+	}
+	for i := 0; i < segments; i++ {
+		name, segment := testSegment(uint64(i*10), uint64(i*10+9))
+		m.Segments[name] = segment
+		m.LastOffset = segment.CommittedOffset
+	}
+	return m
+}
+
+func manifestKey(m Manifest) string {
+	return fmt.Sprintf("%s/meta/%s/%s/%d_%d/%s", testHash, m.Namespace, m.Topic, m.Partition, m.Revision, ManifestFileName)
+}
+
+func segmentObjectKey(m Manifest, name string, term int) string {
+	return fmt.Sprintf("%s/%s/%s/%d_%d/%s.%d", testHash, m.Namespace, m.Topic, m.Partition, m.Revision, name, term)
+}
+
+// putPartition stores the manifest and an object for each of its segments.
+func putPartition(t *testing.T, store *storage.MemoryStore, m Manifest) {
+	t.Helper()
+	putManifest(t, store, m)
+	for name, segment := range m.Segments {
+		putObject(t, store, segmentObjectKey(m, name, segment.ArchiverTerm), "segment")
+	}
+}
+
+func putManifest(t *testing.T, store *storage.MemoryStore, m Manifest) {
+	t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putObject(t, store, manifestKey(m), string(data))
+}
+
+func putObject(t *testing.T, store storage.ObjectStore, key, data string) {
+	t.Helper()
+	if err := store.PutObject(context.Background(), key, bytes.NewReader([]byte(data)), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func objectExists(t *testing.T, store storage.ObjectStore, key string) bool {
+	t.Helper()
+	objects, err := store.ListObjects(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(objects) > 0 && objects[0].Key == key
+}
+
+func readTestManifest(t *testing.T, c *Client, m Manifest) Manifest {
+	t.Helper()
+	manifest, err := c.ReadManifest(context.Background(), manifestKey(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+// testBatch encodes a batch as stored in a segment, holding count records
+// from offset base, each with a timestamp equal to its offset and the key and
+// value key-<offset> and value-<offset>.
+func testBatch(base int64, count int) []byte {
+	return testBatchAt(base, count, base)
+}
+
+// testBatchAt is testBatch with the records one millisecond apart from
+// timestamp.
+func testBatchAt(base int64, count int, timestamp int64) []byte {
+	return encodeTestBatch(segment.Header{
+		BaseOffset:    base,
+		Type:          segment.BatchTypeRaftData,
+		ProducerID:    -1,
+		ProducerEpoch: -1,
+		BaseSequence:  -1,
+	}, testRecords(base, count, timestamp))
+}
+
+// testRecords returns count records from offset base, one millisecond apart
+// from timestamp, with the key and value key-<offset> and value-<offset>.
+func testRecords(base int64, count int, timestamp int64) []segment.Record {
+	records := make([]segment.Record, count)
+	for i := range records {
+		offset := base + int64(i)
+		records[i] = segment.Record{
+			Offset:    offset,
+			Timestamp: timestamp + int64(i),
+			Key:       []byte(fmt.Sprintf("key-%d", offset)),
+			Value:     []byte(fmt.Sprintf("value-%d", offset)),
+		}
+	}
+	return records
+}
+
+// encodeTestBatch encodes records as stored in a segment, under header h
+// completed with the size, offsets, timestamps and CRC of the records.
+func encodeTestBatch(h segment.Header, records []segment.Record) []byte {
+	// the Kafka batch holds the same fields as the segment one after its
+	// first 61 bytes, which differ
+	payload := kafka.EncodeRecordBatch(records)[segment.HeaderSize:]
+	h.SizeBytes = int32(segment.HeaderSize + len(payload))
+	h.LastOffsetDelta = int32(len(records) - 1)
+	h.FirstTimestamp = records[0].Timestamp
+	h.MaxTimestamp = records[len(records)-1].Timestamp
+	h.RecordCount = int32(len(records))
+	b := segment.Batch{Header: h, Payload: payload}
+	b.CRC = b.PayloadCRC()
+
+	raw := make([]byte, segment.HeaderSize)
+	binary.LittleEndian.PutUint32(raw[4:], uint32(b.SizeBytes))
+	binary.LittleEndian.PutUint64(raw[8:], uint64(b.BaseOffset))
+	raw[16] = byte(b.Type)
+	binary.LittleEndian.PutUint32(raw[17:], b.CRC)
+	binary.LittleEndian.PutUint16(raw[21:], uint16(b.Attributes))
+	binary.LittleEndian.PutUint32(raw[23:], uint32(b.LastOffsetDelta))
+	binary.LittleEndian.PutUint64(raw[27:], uint64(b.FirstTimestamp))
+	binary.LittleEndian.PutUint64(raw[35:], uint64(b.MaxTimestamp))
+	binary.LittleEndian.PutUint64(raw[43:], uint64(b.ProducerID))
+	binary.LittleEndian.PutUint16(raw[51:], uint16(b.ProducerEpoch))
+	binary.LittleEndian.PutUint32(raw[53:], uint32(b.BaseSequence))
+	binary.LittleEndian.PutUint32(raw[57:], uint32(b.RecordCount))
+	binary.LittleEndian.PutUint32(raw[0:], crc32.Checksum(raw[4:], crc32.MakeTable(crc32.Castagnoli)))
+	return append(raw, payload...)
+}
+
+// addSegment adds a segment made of batches to m, stores its object and
+// returns its name. The manifest itself is not stored.
+func addSegment(t *testing.T, store storage.ObjectStore, m *Manifest, batches ...[]byte) string {
+	t.Helper()
+	first, err := segment.ParseHeader(batches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := segment.ParseHeader(batches[len(batches)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Join(batches, nil)
+	name, s := testSegment(uint64(first.BaseOffset), uint64(last.LastOffset()))
+	s.SizeBytes = uint64(len(data))
+	s.BaseTimestamp, s.MaxTimestamp = uint64(first.FirstTimestamp), uint64(last.MaxTimestamp)
+	m.Segments[name] = s
+	if s.CommittedOffset > m.LastOffset {
+		m.LastOffset = s.CommittedOffset
+	}
+	putObject(t, store, segmentObjectKey(*m, name, s.ArchiverTerm), string(data))
+	return name
+}
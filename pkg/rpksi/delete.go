@@ -0,0 +1,128 @@
+package rpksi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rpksi/pkg/storage"
+	"strings"
+	"time"
+)
+
+// ErrNoSegmentFilter is returned when a deletion is planned without any
+// criteria that narrow down the segments of a topic.
+var ErrNoSegmentFilter = errors.New("deletion requires a time filter (older-than, newer-than or between) or an offset")
+
+// ManifestRewrite is a partition manifest with the deleted segments removed.
+type ManifestRewrite struct {
+	Key string `json:"key"`
+	// ETag is the version of the manifest the rewrite is based on.
+	ETag     string   `json:"etag"`
+	Manifest Manifest `json:"manifest"`
+	// Removed lists the names of the segments dropped from the manifest.
+	Removed []string `json:"removed"`
+}
+
+// DeletionPlan describes the objects and manifests changed by a deletion.
+type DeletionPlan struct {
+	Version   int               `json:"version"`
+	Created   time.Time         `json:"created"`
+	Filter    Filter            `json:"filter"`
+	Segments  []RowSegment      `json:"segments"`
+	Manifests []ManifestRewrite `json:"manifests"`
+}
+
+// PlanDeletion finds the segments of filter.Topic matching filter (see
+// MatchWithin) along with their objects, and computes the manifests that no
+// longer reference them. Nothing is changed in the bucket.
+func (c *Client) PlanDeletion(ctx context.Context, filter Filter) (*DeletionPlan, error) {
+	if len(filter.Topic) == 0 {
+		return nil, errors.New("deletion requires a topic")
+	}
+	if !filter.selectsSegments() {
+		return nil, ErrNoSegmentFilter
+	}
+	objects, err := c.Store.ListObjects(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	manifests, _, err := c.readManifests(ctx, objects, filter.Topic)
+	if err != nil {
+		return nil, err
+	}
+	objectPaths := segmentObjectPaths(objects)
+
+	plan := &DeletionPlan{Version: planVersion, Created: time.Now().UTC(), Filter: filter}
+	for _, mo := range manifests {
+		rewrite := ManifestRewrite{Key: mo.Key, ETag: mo.ETag, Manifest: mo.Manifest}
+		rewrite.Manifest.Segments = make(map[string]Segment, len(mo.Manifest.Segments))
+		for name, segment := range mo.Manifest.Segments {
+			objectPath, found := objectPaths[mo.Manifest.Key(name)]
+			if !found || !filter.MatchWithin(mo.Manifest, segment) {
+				rewrite.Manifest.Segments[name] = segment
+				continue
+			}
+			row := newRowSegment(mo.Manifest, name, segment)
+			row.ObjectPath = objectPath
+			row.Delete = true
+			plan.Segments = append(plan.Segments, row)
+			rewrite.Removed = append(rewrite.Removed, name)
+		}
+		if len(rewrite.Removed) > 0 {
+			// refuse manifests that cannot be written back before anything
+			// is changed
+			if _, err := encodeManifest(rewrite.Key, rewrite.Manifest); err != nil {
+				return nil, fmt.Errorf("%s: %w", rewrite.Key, err)
+			}
+			rewrite.Manifest.NeedsRewrite = true
+			plan.Manifests = append(plan.Manifests, rewrite)
+		}
+	}
+	sortSegments(plan.Segments)
+	return plan, nil
+}
+
+// replanManifest rebases the i-th manifest rewrite of plan on the current
+// version of the manifest, and reports whether the manifest still references
+// any of the removed segments. Segments appended by the archiver are kept.
+// Planned segments that are no longer in the manifest stay in the plan, as a
+// resumed run finds the manifest it already wrote and their objects still
+// have to be deleted.
+func (c *Client) replanManifest(ctx context.Context, plan *DeletionPlan, i int) (bool, error) {
+	rewrite := &plan.Manifests[i]
+	current, err := c.ReadManifestObject(ctx, rewrite.Key)
+	if err != nil {
+		return false, err
+	}
+	rewrite.ETag = current.ETag
+	rewrite.Manifest = current.Manifest
+	rewrite.Manifest.NeedsRewrite = true
+	rewrite.Manifest.Segments = make(map[string]Segment, len(current.Manifest.Segments))
+	for name, segment := range current.Manifest.Segments {
+		rewrite.Manifest.Segments[name] = segment
+	}
+	referenced := false
+	for _, name := range rewrite.Removed {
+		if _, ok := rewrite.Manifest.Segments[name]; ok {
+			delete(rewrite.Manifest.Segments, name)
+			referenced = true
+		}
+	}
+	return referenced, nil
+}
+
+// segmentObjectPaths maps segments to the keys of their remote objects
+// found in a bucket listing.
+func segmentObjectPaths(objects []storage.ObjectInfo) map[SegmentKey]string {
+	paths := make(map[SegmentKey]string)
+	for _, object := range objects {
+		// .index and .tx files share the name of their segment
+		if strings.HasSuffix(object.Key, ".index") || strings.HasSuffix(object.Key, ".tx") {
+			continue
+		}
+		if key, ok := parseSegmentObjectKey(object.Key); ok {
+			paths[key] = object.Key
+		}
+	}
+	return paths
+}
@@ -0,0 +1,157 @@
+package rpksi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPlanDeletion(t *testing.T) {
+	c, store := newTestClient()
+	events := testManifest("events", 0, 3)
+	putPartition(t, store, events)
+	putPartition(t, store, testManifest("events", 1, 1))
+	putPartition(t, store, testManifest("logs", 0, 3))
+	// a segment without an object is left in the manifest
+	unstored := testManifest("events", 2, 1)
+	putManifest(t, store, unstored)
+
+	plan, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 20, Partitions: []int{0, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := segmentRowNames(plan.Segments), []string{"events/0-1-v1.log", "events/10-1-v1.log"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("segments = %v, want %v", got, want)
+	}
+	for _, row := range plan.Segments {
+		if want := segmentObjectKey(events, row.SegmentName, 1); row.ObjectPath != want {
+			t.Errorf("object path = %s, want %s", row.ObjectPath, want)
+		}
+	}
+	if len(plan.Manifests) != 1 {
+		t.Fatalf("%d manifests rewritten, want 1", len(plan.Manifests))
+	}
+	rewrite := plan.Manifests[0]
+	if rewrite.Key != manifestKey(events) || len(rewrite.ETag) == 0 {
+		t.Errorf("rewrite of %s (etag %q), want %s", rewrite.Key, rewrite.ETag, manifestKey(events))
+	}
+	if got, want := segmentNames(rewrite.Manifest), []string{"20-1-v1.log"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rewritten manifest has segments %v, want %v", got, want)
+	}
+	if got, want := rewrite.Removed, []string{"0-1-v1.log", "10-1-v1.log"}; !reflect.DeepEqual(sortedStrings(got), want) {
+		t.Errorf("removed = %v, want %v", got, want)
+	}
+	// planning changes nothing
+	if got := segmentNames(readTestManifest(t, c, events)); len(got) != 3 {
+		t.Errorf("manifest changed by planning: %v", got)
+	}
+
+	// offset 0 is below every segment rather than no filter
+	plan, err = c.PlanDeletion(context.Background(), Filter{Topic: "events", Offset: offset(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Segments) > 0 || len(plan.Manifests) > 0 {
+		t.Errorf("planned the deletion of %v below offset 0", segmentRowNames(plan.Segments))
+	}
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded DeletionPlan
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Filter.Offset == nil || *decoded.Filter.Offset != 0 {
+		t.Errorf("offset 0 of the plan filter decoded as %v", decoded.Filter.Offset)
+	}
+
+	if _, err := c.PlanDeletion(context.Background(), Filter{Topic: "events"}); !errors.Is(err, ErrNoSegmentFilter) {
+		t.Errorf("planning without segment criteria: %v, want %v", err, ErrNoSegmentFilter)
+	}
+	if _, err := c.PlanDeletion(context.Background(), Filter{OlderThan: 20}); err == nil {
+		t.Error("planning without a topic succeeded")
+	}
+}
+
+func TestPlanDeletionWithin(t *testing.T) {
+	c, store := newTestClient()
+	putPartition(t, store, testManifest("events", 0, 3))
+
+	for _, tt := range []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		// list matches 0-9 and 20-29 as well, they hold records outside
+		// of the ranges
+		{"between", Filter{Topic: "events", Between: &TimeRange{From: 5, To: 25}}, []string{"events/10-1-v1.log"}},
+		{"between bounds", Filter{Topic: "events", Between: &TimeRange{From: 10, To: 29}}, []string{"events/10-1-v1.log", "events/20-1-v1.log"}},
+		{"offsets", Filter{Topic: "events", Offsets: &OffsetRange{From: 11, To: 25}}, nil},
+		{"offsets bounds", Filter{Topic: "events", Offsets: &OffsetRange{From: 0, To: 19}}, []string{"events/0-1-v1.log", "events/10-1-v1.log"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := c.PlanDeletion(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := segmentRowNames(plan.Segments); !reflect.DeepEqual(sortedStrings(got), tt.want) {
+				t.Errorf("segments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDeletion(t *testing.T) {
+	c, store, manifests := newDeletionTest(t)
+	plan, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := NewJournal("", plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RunDeletion(context.Background(), j); err != nil {
+		t.Fatal(err)
+	}
+	if j.Phase != PhaseComplete {
+		t.Errorf("phase %s, want %s", j.Phase, PhaseComplete)
+	}
+	checkDeleted(t, c, store, manifests)
+	for _, m := range manifests {
+		backup, err := c.ReadManifest(context.Background(), j.Backups[manifestKey(m)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(segmentNames(backup), segmentNames(m)) {
+			t.Errorf("backup of partition %d has segments %v, want %v", m.Partition, segmentNames(backup), segmentNames(m))
+		}
+	}
+	if err := c.RollbackDeletion(context.Background(), j); !errors.Is(err, ErrSegmentsDeleted) {
+		t.Errorf("rollback of a complete deletion: %v, want %v", err, ErrSegmentsDeleted)
+	}
+}
+
+func TestRunDeletionKeepsAppendedSegments(t *testing.T) {
+	c, store, manifests := newDeletionTest(t)
+	plan, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the archiver uploads a segment between planning and running
+	name := appendSegment(t, c, store, manifests)
+	if err := c.ApplyDeletion(context.Background(), plan); err != nil {
+		t.Fatal(err)
+	}
+	checkDeleted(t, c, store, manifests, name)
+}
+
+func sortedStrings(values []string) []string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,118 @@
+package rpksi
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"rpksi/pkg/export"
+	"rpksi/pkg/segment"
+	"sort"
+	"time"
+)
+
+// ExportedFile is a file written by Export.
+type ExportedFile struct {
+	Path    string `json:"path" yaml:"path"`
+	Records int    `json:"records" yaml:"records"`
+}
+
+// exportFile is a file being written by Export.
+type exportFile struct {
+	ExportedFile
+	out     export.File
+	buf     *bufio.Writer
+	records export.RecordWriter
+}
+
+// close completes the file, or discards it when it cannot be completed.
+func (f *exportFile) close() error {
+	err := f.records.Close()
+	if err == nil {
+		err = f.buf.Flush()
+	}
+	if err == nil {
+		err = f.out.Close()
+	} else {
+		f.out.Abort()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return nil
+}
+
+// Export writes the archived records matching filter to sink as files of
+// format, one per partition and day (UTC) of the record timestamps, at the
+// paths returned by export.FilePath. The files of a partition are complete
+// once the next partition is read. On error, the files of the partition
+// being read are discarded rather than completed, so the sink only ever
+// holds complete files, those of the partitions read before the error.
+func (c *Client) Export(ctx context.Context, filter Filter, sink export.Sink, format export.Format) ([]ExportedFile, error) {
+	var written []ExportedFile
+	open := make(map[string]*exportFile)
+	closeAll := func() error {
+		dates := make([]string, 0, len(open))
+		for date := range open {
+			dates = append(dates, date)
+		}
+		sort.Strings(dates)
+		var first error
+		for _, date := range dates {
+			f := open[date]
+			delete(open, date)
+			if first != nil {
+				f.out.Abort()
+				continue
+			}
+			if err := f.close(); err != nil {
+				first = err
+				continue
+			}
+			written = append(written, f.ExportedFile)
+		}
+		return first
+	}
+	abortAll := func() {
+		for date, f := range open {
+			f.out.Abort()
+			delete(open, date)
+		}
+	}
+
+	var topic string
+	partition := -1
+	err := c.ReadRecords(ctx, filter, func(row RowSegment, record segment.Record) error {
+		if row.TopicName != topic || row.Partition != partition {
+			if err := closeAll(); err != nil {
+				return err
+			}
+			topic, partition = row.TopicName, row.Partition
+		}
+		date := time.UnixMilli(record.Timestamp).UTC().Truncate(24 * time.Hour)
+		key := date.Format("2006-01-02")
+		f, ok := open[key]
+		if !ok {
+			path := export.FilePath(topic, partition, date, record.Offset, format)
+			out, err := sink.Create(ctx, path)
+			if err != nil {
+				return err
+			}
+			buf := bufio.NewWriterSize(out, 1<<20)
+			records, err := export.NewRecordWriter(format, buf)
+			if err != nil {
+				out.Abort()
+				return err
+			}
+			c.logf("  writing %s", path)
+			f = &exportFile{ExportedFile: ExportedFile{Path: path}, out: out, buf: buf, records: records}
+			open[key] = f
+		}
+		f.Records++
+		return f.records.Write(record)
+	})
+	if err != nil {
+		abortAll()
+		return written, err
+	}
+	return written, closeAll()
+}
@@ -0,0 +1,51 @@
+package rpksi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"rpksi/pkg/export"
+	"sort"
+	"testing"
+)
+
+func TestExportDiscardsFilesOnError(t *testing.T) {
+	c, store := newTestClient()
+	complete := testManifest("events", 0, 0)
+	addSegment(t, store, &complete, testBatch(0, 5))
+	putManifest(t, store, complete)
+	// partition 1 ends in the middle of its second batch
+	broken := testManifest("events", 1, 0)
+	name := addSegment(t, store, &broken, testBatch(0, 5), testBatch(5, 5))
+	putManifest(t, store, broken)
+	data := string(testBatch(0, 5)) + string(testBatch(5, 5))
+	putObject(t, store, segmentObjectKey(broken, name, 1), data[:len(data)-3])
+
+	dir := t.TempDir()
+	files, err := c.Export(context.Background(), Filter{Topic: "events"}, export.DirSink{Dir: dir}, export.FormatJSONL)
+	if err == nil {
+		t.Fatal("export of a truncated segment succeeded")
+	}
+	want := []ExportedFile{{Path: "topic=events/partition=0/date=1970-01-01/events-0-0.jsonl", Records: 5}}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("exported %+v, want %+v", files, want)
+	}
+	// the file of partition 1 holding its first batch is gone, as are the
+	// files it was staged in
+	var found []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			found = append(found, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(found)
+	if !reflect.DeepEqual(found, []string{want[0].Path}) {
+		t.Errorf("files %v, want only %s", found, want[0].Path)
+	}
+}
@@ -0,0 +1,223 @@
+package rpksi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity is how serious a finding of CheckManifests is.
+type Severity string
+
+const (
+	// SeverityError means the manifest is inconsistent and readers of the
+	// partition will miss or misread records.
+	SeverityError Severity = "error"
+	// SeverityWarning means the manifest is unusual but still readable.
+	SeverityWarning Severity = "warning"
+)
+
+// Checks run by CheckManifests.
+const (
+	CheckGap       = "gap"
+	CheckOverlap   = "overlap"
+	CheckOffsets   = "offsets"
+	CheckTimestamp = "timestamp"
+	CheckName      = "name"
+	CheckMissing   = "missing"
+	CheckDecode    = "decode"
+)
+
+// Finding is an inconsistency found in a partition manifest.
+type Finding struct {
+	Severity    Severity `json:"severity" yaml:"severity"`
+	Check       string   `json:"check" yaml:"check"`
+	TopicName   string   `json:"topic_name" yaml:"topic_name"`
+	Partition   int      `json:"partition" yaml:"partition"`
+	ManifestKey string   `json:"manifest_key" yaml:"manifest_key"`
+	SegmentName string   `json:"segment_name" yaml:"segment_name"`
+	Message     string   `json:"message" yaml:"message"`
+}
+
+// FsckResult holds the findings of CheckManifests and the number of
+// manifests and segments checked.
+type FsckResult struct {
+	Manifests int       `json:"manifests" yaml:"manifests"`
+	Segments  int       `json:"segments" yaml:"segments"`
+	Findings  []Finding `json:"findings" yaml:"findings"`
+}
+
+// Count returns the number of findings of a severity.
+func (r FsckResult) Count(severity Severity) int {
+	n := 0
+	for _, finding := range r.Findings {
+		if finding.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckManifests validates the partition manifests of the topics and
+// partitions selected by filter, its segment criteria being ignored. Within a
+// manifest, segments sorted by base offset must follow each other without
+// gaps or overlaps, end at or before last_offset and have increasing
+// timestamps, their names must agree with their base offset and term, and
+// their objects must exist. Manifests that cannot be decoded are reported
+// with the decode check. Inconsistencies are reported as findings, an
+// error is only returned if the bucket can not be read.
+func (c *Client) CheckManifests(ctx context.Context, filter Filter) (FsckResult, error) {
+	var result FsckResult
+	objects, err := c.Store.ListObjects(ctx, "")
+	if err != nil {
+		return result, err
+	}
+	manifests, undecodable, err := c.readManifests(ctx, objects, filter.Topic)
+	if err != nil {
+		return result, err
+	}
+	sort.Strings(undecodable)
+	for _, key := range undecodable {
+		topic, partition, _, _ := parseManifestKey(key)
+		if !filter.MatchPartition(partition) {
+			continue
+		}
+		result.Manifests++
+		result.Findings = append(result.Findings, Finding{
+			Severity:    SeverityError,
+			Check:       CheckDecode,
+			TopicName:   topic,
+			Partition:   partition,
+			ManifestKey: key,
+			Message:     "the manifest could not be decoded",
+		})
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		a, b := manifests[i].Manifest, manifests[j].Manifest
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		return a.Revision < b.Revision
+	})
+	objectPaths := segmentObjectPaths(objects)
+	for _, mo := range manifests {
+		if !filter.MatchPartition(mo.Manifest.Partition) {
+			continue
+		}
+		result.Manifests++
+		result.Segments += len(mo.Manifest.Segments)
+		result.Findings = append(result.Findings, checkManifest(mo, objectPaths)...)
+	}
+	return result, nil
+}
+
+func checkManifest(mo ManifestObject, objectPaths map[SegmentKey]string) []Finding {
+	var findings []Finding
+	report := func(severity Severity, check, name, format string, a ...interface{}) {
+		findings = append(findings, Finding{
+			Severity:    severity,
+			Check:       check,
+			TopicName:   mo.Manifest.Topic,
+			Partition:   mo.Manifest.Partition,
+			ManifestKey: mo.Key,
+			SegmentName: name,
+			Message:     fmt.Sprintf(format, a...),
+		})
+	}
+
+	names := make([]string, 0, len(mo.Manifest.Segments))
+	for name := range mo.Manifest.Segments {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := mo.Manifest.Segments[names[i]], mo.Manifest.Segments[names[j]]
+		if a.BaseOffset != b.BaseOffset {
+			return a.BaseOffset < b.BaseOffset
+		}
+		return names[i] < names[j]
+	})
+
+	for i, name := range names {
+		s := mo.Manifest.Segments[name]
+		if s.CommittedOffset < s.BaseOffset {
+			report(SeverityError, CheckOffsets, name, "committed_offset %d is before base_offset %d", s.CommittedOffset, s.BaseOffset)
+		}
+		if s.CommittedOffset > mo.Manifest.LastOffset {
+			report(SeverityError, CheckOffsets, name, "committed_offset %d exceeds last_offset %d", s.CommittedOffset, mo.Manifest.LastOffset)
+		}
+		if s.MaxTimestamp < s.BaseTimestamp {
+			report(SeverityError, CheckTimestamp, name, "max_timestamp %d is before base_timestamp %d", s.MaxTimestamp, s.BaseTimestamp)
+		}
+		if problem := checkSegmentName(name, s); len(problem) > 0 {
+			report(SeverityError, CheckName, name, "%s", problem)
+		}
+		if _, ok := objectPaths[mo.Manifest.Key(name)]; !ok {
+			report(SeverityError, CheckMissing, name, "no object in the bucket")
+		}
+		if i == 0 {
+			continue
+		}
+		prevName := names[i-1]
+		prev := mo.Manifest.Segments[prevName]
+		switch {
+		case s.BaseOffset > prev.CommittedOffset+1:
+			report(SeverityError, CheckGap, name, "offsets %d to %d are missing after %s", prev.CommittedOffset+1, s.BaseOffset-1, prevName)
+		case s.BaseOffset <= prev.CommittedOffset:
+			report(SeverityError, CheckOverlap, name, "offsets %d to %d are also in %s", s.BaseOffset, minUint64(s.CommittedOffset, prev.CommittedOffset), prevName)
+		}
+		// producers may set timestamps, so records can go back in time
+		if s.BaseTimestamp < prev.MaxTimestamp {
+			report(SeverityWarning, CheckTimestamp, name, "base_timestamp %d is before max_timestamp %d of %s", s.BaseTimestamp, prev.MaxTimestamp, prevName)
+		}
+	}
+	return findings
+}
+
+// checkSegmentName returns how the name of a segment disagrees with its base
+// offset and term, or with its committed offset and size for names in the
+// <base>-<committed>-<size>-<term>-v1.log format. The term is only checked
+// when the manifest records the segment_term.
+func checkSegmentName(name string, s Segment) string {
+	fields := strings.Split(strings.TrimSuffix(name, "-v1.log"), "-")
+	if !strings.HasSuffix(name, "-v1.log") || (len(fields) != 2 && len(fields) != 4) {
+		return fmt.Sprintf("name %s is not <base>-<term>-v1.log nor <base>-<committed>-<size>-<term>-v1.log", name)
+	}
+	values := make([]uint64, len(fields))
+	for i, field := range fields {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return fmt.Sprintf("name %s has an invalid number %q", name, field)
+		}
+		values[i] = value
+	}
+	if values[0] != s.BaseOffset {
+		return fmt.Sprintf("name has base offset %d, base_offset is %d", values[0], s.BaseOffset)
+	}
+	if len(values) == 4 {
+		if values[1] != s.CommittedOffset {
+			return fmt.Sprintf("name has committed offset %d, committed_offset is %d", values[1], s.CommittedOffset)
+		}
+		if values[2] != s.SizeBytes {
+			return fmt.Sprintf("name has size %d, size_bytes is %d", values[2], s.SizeBytes)
+		}
+	}
+	if raw, ok := s.Extra["segment_term"]; ok {
+		term, err := strconv.ParseUint(string(raw), 10, 64)
+		if err == nil && values[len(values)-1] != term {
+			return fmt.Sprintf("name has term %d, segment_term is %d", values[len(values)-1], term)
+		}
+	}
+	return ""
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
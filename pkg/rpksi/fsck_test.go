@@ -0,0 +1,108 @@
+package rpksi
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCheckManifests(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// change breaks the manifest after its objects are stored
+		change   func(m *Manifest)
+		findings []Finding
+	}{
+		{
+			name:   "consistent",
+			change: func(m *Manifest) {},
+		},
+		{
+			name: "gap",
+			change: func(m *Manifest) {
+				delete(m.Segments, "10-1-v1.log")
+			},
+			findings: []Finding{
+				{Severity: SeverityError, Check: CheckGap, SegmentName: "20-1-v1.log", Message: "offsets 10 to 19 are missing after 0-1-v1.log"},
+			},
+		},
+		{
+			name: "overlap",
+			change: func(m *Manifest) {
+				s := m.Segments["0-1-v1.log"]
+				s.CommittedOffset = 14
+				m.Segments["0-1-v1.log"] = s
+			},
+			findings: []Finding{
+				{Severity: SeverityError, Check: CheckOverlap, SegmentName: "10-1-v1.log", Message: "offsets 10 to 14 are also in 0-1-v1.log"},
+			},
+		},
+		{
+			name: "unparsable name",
+			change: func(m *Manifest) {
+				m.Segments["10-x-v1.log"] = m.Segments["10-1-v1.log"]
+				m.Segments["20.log"] = m.Segments["20-1-v1.log"]
+				delete(m.Segments, "10-1-v1.log")
+				delete(m.Segments, "20-1-v1.log")
+			},
+			findings: []Finding{
+				{Severity: SeverityError, Check: CheckName, SegmentName: "10-x-v1.log", Message: `name 10-x-v1.log has an invalid number "x"`},
+				{Severity: SeverityError, Check: CheckMissing, SegmentName: "10-x-v1.log", Message: "no object in the bucket"},
+				{Severity: SeverityError, Check: CheckName, SegmentName: "20.log", Message: "name 20.log is not <base>-<term>-v1.log nor <base>-<committed>-<size>-<term>-v1.log"},
+				{Severity: SeverityError, Check: CheckMissing, SegmentName: "20.log", Message: "no object in the bucket"},
+			},
+		},
+		{
+			name: "missing object",
+			change: func(m *Manifest) {
+				name, s := testSegment(30, 39)
+				m.Segments[name] = s
+				m.LastOffset = 39
+			},
+			findings: []Finding{
+				{Severity: SeverityError, Check: CheckMissing, SegmentName: "30-1-v1.log", Message: "no object in the bucket"},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, store := newTestClient()
+			m := testManifest("events", 0, 3)
+			putPartition(t, store, m)
+			tt.change(&m)
+			putManifest(t, store, m)
+
+			result, err := c.CheckManifests(context.Background(), Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Manifests != 1 || result.Segments != len(m.Segments) {
+				t.Errorf("checked %d manifests and %d segments, want 1 and %d", result.Manifests, result.Segments, len(m.Segments))
+			}
+			for i := range tt.findings {
+				tt.findings[i].TopicName = "events"
+				tt.findings[i].ManifestKey = manifestKey(m)
+			}
+			if !reflect.DeepEqual(result.Findings, tt.findings) {
+				t.Errorf("found %+v, want %+v", result.Findings, tt.findings)
+			}
+		})
+	}
+}
+
+func TestCheckSegmentName(t *testing.T) {
+	_, s := testSegment(10, 19)
+	for name, want := range map[string]string{
+		"10-1-v1.log":         "",
+		"10-19-1024-1-v1.log": "",
+		"11-1-v1.log":         "name has base offset 11, base_offset is 10",
+		"10-18-1024-1-v1.log": "name has committed offset 18, committed_offset is 19",
+		"10-19-512-1-v1.log":  "name has size 512, size_bytes is 1024",
+		"10-1-v2.log":         "name 10-1-v2.log is not <base>-<term>-v1.log nor <base>-<committed>-<size>-<term>-v1.log",
+		"10-1-1-v1.log":       "name 10-1-1-v1.log is not <base>-<term>-v1.log nor <base>-<committed>-<size>-<term>-v1.log",
+		"-1-v1.log":           `name -1-v1.log has an invalid number ""`,
+	} {
+		if got := checkSegmentName(name, s); got != want {
+			t.Errorf("%s: %q, want %q", name, got, want)
+		}
+	}
+}
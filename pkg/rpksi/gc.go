@@ -0,0 +1,295 @@
+package rpksi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OrphanReason is why an object is not referenced by any partition manifest.
+type OrphanReason string
+
+const (
+	// OrphanSegment is a segment missing from the manifest of its
+	// partition, usually left behind by retention, compaction or a deletion.
+	OrphanSegment OrphanReason = "segment"
+	// OrphanTerm is a segment listed in the manifest but uploaded under
+	// another term than the manifest records, left behind by a failed or
+	// retried upload.
+	OrphanTerm OrphanReason = "term"
+	// OrphanIndex is the .index file of an orphaned or missing segment.
+	OrphanIndex OrphanReason = "index"
+	// OrphanTx is the .tx file of an orphaned or missing segment.
+	OrphanTx OrphanReason = "tx"
+	// OrphanRevision is an object of a partition revision without a
+	// manifest, left behind by a deleted or recreated topic.
+	OrphanRevision OrphanReason = "revision"
+)
+
+// Orphan is an object under a topic prefix that no partition manifest
+// references.
+type Orphan struct {
+	Key          string       `json:"key" yaml:"key"`
+	Size         int64        `json:"size" yaml:"size"`
+	LastModified time.Time    `json:"last_modified" yaml:"last_modified"`
+	TopicName    string       `json:"topic_name" yaml:"topic_name"`
+	Partition    int          `json:"partition" yaml:"partition"`
+	Revision     int          `json:"revision" yaml:"revision"`
+	Reason       OrphanReason `json:"reason" yaml:"reason"`
+}
+
+// OrphanReport lists the orphans found by FindOrphans, and the manifests of
+// the partitions that were skipped because some of their segments are listed
+// in spillover manifests, which rpksi does not read, or because their
+// manifest is binary or could not be decoded.
+type OrphanReport struct {
+	Orphans []Orphan `json:"orphans" yaml:"orphans"`
+	Skipped []string `json:"skipped" yaml:"skipped"`
+}
+
+// dataObject is an object stored under a partition prefix, named
+// <segment>.<term> for segments and <segment>.<term>.index or
+// <segment>.<term>.tx for their companion files.
+type dataObject struct {
+	segment SegmentKey
+	term    string
+	suffix  string
+}
+
+// parseDataObjectKey parses the key of a segment or companion file under a
+// partition prefix, <hash>/<namespace>/<topic>/<partition>_<revision>/<name>.
+func parseDataObjectKey(objectKey string) (dataObject, bool) {
+	key, ok := parseSegmentObjectKey(objectKey)
+	if !ok || key.Namespace != Namespace || !strings.HasSuffix(key.Name, ".log") {
+		return dataObject{}, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path.Base(objectKey), key.Name+"."), ".", 2)
+	object := dataObject{segment: key, term: parts[0]}
+	if _, err := strconv.ParseUint(object.term, 10, 64); err != nil {
+		return dataObject{}, false
+	}
+	if len(parts) == 2 {
+		object.suffix = parts[1]
+		if object.suffix != "index" && object.suffix != "tx" {
+			return dataObject{}, false
+		}
+	}
+	return object, true
+}
+
+// FindOrphans lists the objects under the partition prefixes of topic (of
+// every topic when empty) that no partition manifest references: segments
+// missing from the manifest of their partition, segments uploaded under a
+// term the manifest does not record, .index and .tx files of segments that
+// are not referenced, and objects of partition revisions without a manifest.
+// Segments in the replaced list of a manifest, which Redpanda deletes
+// itself, are kept. Partitions with spillover manifests, binary manifests or
+// manifests that cannot be decoded are skipped entirely.
+// Objects modified less than minAge ago are left out, as the archiver
+// uploads a segment before adding it to the manifest. Objects whose names are
+// not understood are never reported.
+func (c *Client) FindOrphans(ctx context.Context, topic string, minAge time.Duration) (OrphanReport, error) {
+	var report OrphanReport
+	objects, err := c.Store.ListObjects(ctx, "")
+	if err != nil {
+		return report, err
+	}
+	manifests, undecodable, err := c.readManifests(ctx, objects, topic)
+	if err != nil {
+		return report, err
+	}
+	spillover := make(map[string]bool)
+	for _, object := range objects {
+		name := path.Base(object.Key)
+		if strings.HasPrefix(name, "manifest.") && name != ManifestFileName && name != BinaryManifestFileName {
+			spillover[path.Dir(object.Key)] = true
+		}
+	}
+
+	type partition struct {
+		topic     string
+		partition int
+		revision  int
+	}
+	partitions := make(map[partition]Manifest)
+	skipped := make(map[partition]bool)
+	replaced := make(map[partition]map[uint64]bool)
+	// a partition whose manifest could not be decoded still has one, none
+	// of its objects is an orphan
+	for _, key := range undecodable {
+		if topic, p, revision, ok := parseManifestKey(key); ok {
+			skipped[partition{topic, p, revision}] = true
+			report.Skipped = append(report.Skipped, key)
+		}
+	}
+	for _, mo := range manifests {
+		m := mo.Manifest
+		p := partition{m.Topic, m.Partition, m.Revision}
+		partitions[p] = m
+		// the binary layout is not verified, a misread manifest must not
+		// turn the segments it lists into orphans
+		if spillover[path.Dir(mo.Key)] || hasArchive(m) || path.Base(mo.Key) == BinaryManifestFileName {
+			skipped[p] = true
+			report.Skipped = append(report.Skipped, mo.Key)
+			continue
+		}
+		offsets, err := replacedOffsets(m)
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", mo.Key, err)
+		}
+		replaced[p] = offsets
+	}
+	sort.Strings(report.Skipped)
+	// segments of the manifests with an object uploaded under the term the
+	// manifest records, the others keep every object of the segment
+	uploaded := make(map[SegmentKey]bool)
+	for _, object := range objects {
+		data, ok := parseDataObjectKey(object.Key)
+		if !ok || len(data.suffix) > 0 {
+			continue
+		}
+		m, ok := partitions[partition{data.segment.Topic, data.segment.Partition, data.segment.Revision}]
+		if !ok {
+			continue
+		}
+		if segment, ok := m.Segments[data.segment.Name]; ok && data.term == strconv.Itoa(segment.ArchiverTerm) {
+			uploaded[data.segment] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-minAge)
+	for _, object := range objects {
+		data, ok := parseDataObjectKey(object.Key)
+		if !ok || (len(topic) > 0 && data.segment.Topic != topic) || object.LastModified.After(cutoff) {
+			continue
+		}
+		p := partition{data.segment.Topic, data.segment.Partition, data.segment.Revision}
+		if skipped[p] || replaced[p][segmentNameOffset(data.segment.Name)] {
+			continue
+		}
+		orphan := Orphan{
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+			TopicName:    data.segment.Topic,
+			Partition:    data.segment.Partition,
+			Revision:     data.segment.Revision,
+		}
+		m, ok := partitions[p]
+		switch {
+		case !ok:
+			orphan.Reason = OrphanRevision
+		case len(data.suffix) > 0:
+			segment, ok := m.Segments[data.segment.Name]
+			if ok && (!uploaded[data.segment] || data.term == strconv.Itoa(segment.ArchiverTerm)) {
+				continue
+			}
+			orphan.Reason = OrphanReason(data.suffix)
+		default:
+			segment, ok := m.Segments[data.segment.Name]
+			if !ok {
+				orphan.Reason = OrphanSegment
+			} else if uploaded[data.segment] && data.term != strconv.Itoa(segment.ArchiverTerm) {
+				orphan.Reason = OrphanTerm
+			} else {
+				continue
+			}
+		}
+		report.Orphans = append(report.Orphans, orphan)
+	}
+	sort.Slice(report.Orphans, func(i, j int) bool {
+		return report.Orphans[i].Key < report.Orphans[j].Key
+	})
+	return report, nil
+}
+
+// hasArchive reports whether the manifest has an archive, segments below its
+// start offset that Redpanda moved to spillover manifests.
+func hasArchive(m Manifest) bool {
+	archive, ok := extraInt(m.Extra, "archive_start_offset")
+	if !ok || archive < 0 {
+		return false
+	}
+	start, ok := extraInt(m.Extra, "start_offset")
+	return !ok || archive < start
+}
+
+// replacedOffsets returns the base offsets of the segments in the replaced
+// list of a manifest. Replaced segments are named after their base offset
+// whatever the name format, so any object of those base offsets is kept.
+func replacedOffsets(m Manifest) (map[uint64]bool, error) {
+	offsets := make(map[uint64]bool)
+	raw, ok := m.Extra["replaced"]
+	if !ok {
+		return offsets, nil
+	}
+	var segments []Segment
+	if err := json.Unmarshal(raw, &segments); err != nil {
+		return nil, fmt.Errorf("replaced: %w", err)
+	}
+	for _, segment := range segments {
+		offsets[segment.BaseOffset] = true
+	}
+	return offsets, nil
+}
+
+// segmentNameOffset returns the base offset a segment name starts with, or
+// the largest offset when it has none.
+func segmentNameOffset(name string) uint64 {
+	offset, err := strconv.ParseUint(strings.SplitN(name, "-", 2)[0], 10, 64)
+	if err != nil {
+		return ^uint64(0)
+	}
+	return offset
+}
+
+// extraInt returns an integer member of extra.
+func extraInt(extra map[string]json.RawMessage, name string) (int64, bool) {
+	raw, ok := extra[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(string(raw), 10, 64)
+	return n, err == nil
+}
+
+// DeleteOrphans deletes orphaned objects found by FindOrphans. The manifests
+// are read again first, and objects that are referenced by then are kept.
+// It returns the objects deleted.
+func (c *Client) DeleteOrphans(ctx context.Context, orphans []Orphan) ([]Orphan, error) {
+	topic := ""
+	for i, orphan := range orphans {
+		if i == 0 {
+			topic = orphan.TopicName
+		} else if orphan.TopicName != topic {
+			topic = ""
+			break
+		}
+	}
+	current, err := c.FindOrphans(ctx, topic, 0)
+	if err != nil {
+		return nil, err
+	}
+	stillOrphaned := make(map[string]bool, len(current.Orphans))
+	for _, orphan := range current.Orphans {
+		stillOrphaned[orphan.Key] = true
+	}
+	var deleted []Orphan
+	for _, orphan := range orphans {
+		if !stillOrphaned[orphan.Key] {
+			c.logf("  keeping %s, it is referenced now", orphan.Key)
+			continue
+		}
+		if err := c.Store.RemoveObject(ctx, orphan.Key); err != nil {
+			return deleted, fmt.Errorf("deleting %s: %w", orphan.Key, err)
+		}
+		c.logf("  deleted %s", orphan.Key)
+		deleted = append(deleted, orphan)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,142 @@
+package rpksi
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func orphanKeys(orphans []Orphan) map[string]OrphanReason {
+	keys := make(map[string]OrphanReason)
+	for _, orphan := range orphans {
+		keys[orphan.Key] = orphan.Reason
+	}
+	return keys
+}
+
+func TestFindOrphans(t *testing.T) {
+	c, store := newTestClient()
+	m := testManifest("events", 0, 2)
+	putPartition(t, store, m)
+	name, _ := testSegment(100, 109)
+	putObject(t, store, segmentObjectKey(m, name, 1), "segment")
+	putObject(t, store, segmentObjectKey(m, name, 1)+".index", "index")
+	putObject(t, store, segmentObjectKey(m, "0-1-v1.log", 2), "segment")
+	gone := m
+	gone.Revision = 3
+	putObject(t, store, segmentObjectKey(gone, "0-1-v1.log", 1), "segment")
+
+	report, err := c.FindOrphans(context.Background(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]OrphanReason{
+		segmentObjectKey(m, name, 1):            OrphanSegment,
+		segmentObjectKey(m, name, 1) + ".index": OrphanIndex,
+		segmentObjectKey(m, "0-1-v1.log", 2):    OrphanTerm,
+		segmentObjectKey(gone, "0-1-v1.log", 1): OrphanRevision,
+	}
+	if got := orphanKeys(report.Orphans); !reflect.DeepEqual(got, want) {
+		t.Errorf("orphans = %v, want %v", got, want)
+	}
+
+	report, err = c.FindOrphans(context.Background(), "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) > 0 {
+		t.Errorf("objects younger than min age reported: %v", orphanKeys(report.Orphans))
+	}
+}
+
+func TestFindOrphansKeepsReplaced(t *testing.T) {
+	c, store := newTestClient()
+	m := testManifest("events", 0, 1)
+	name, replaced := testSegment(0, 4)
+	replaced.SizeBytes = 512
+	m.Extra = map[string]json.RawMessage{"replaced": mustJSON(t, []Segment{replaced})}
+	putPartition(t, store, m)
+	putObject(t, store, segmentObjectKey(m, "0-4-512-1-v1.log", 1), "segment")
+	putObject(t, store, segmentObjectKey(m, name, 0), "segment")
+	putObject(t, store, segmentObjectKey(m, "50-1-v1.log", 1), "segment")
+
+	report, err := c.FindOrphans(context.Background(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]OrphanReason{segmentObjectKey(m, "50-1-v1.log", 1): OrphanSegment}
+	if got := orphanKeys(report.Orphans); !reflect.DeepEqual(got, want) {
+		t.Errorf("orphans = %v, want %v", got, want)
+	}
+}
+
+func TestFindOrphansSkipsSpillover(t *testing.T) {
+	c, store := newTestClient()
+	spilled := testManifest("events", 0, 1)
+	putPartition(t, store, spilled)
+	putObject(t, store, manifestKey(spilled)[:len(manifestKey(spilled))-len(ManifestFileName)]+"manifest.bin.0.9", "spillover")
+	putObject(t, store, segmentObjectKey(spilled, "100-1-v1.log", 1), "segment")
+
+	archived := testManifest("events", 1, 1)
+	archived.Extra = map[string]json.RawMessage{
+		"archive_start_offset": json.RawMessage("0"),
+		"start_offset":         json.RawMessage("10"),
+	}
+	putPartition(t, store, archived)
+	putObject(t, store, segmentObjectKey(archived, "100-1-v1.log", 1), "segment")
+
+	report, err := c.FindOrphans(context.Background(), "events", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) > 0 {
+		t.Errorf("orphans of skipped partitions reported: %v", orphanKeys(report.Orphans))
+	}
+	want := []string{manifestKey(spilled), manifestKey(archived)}
+	if !reflect.DeepEqual(report.Skipped, want) {
+		t.Errorf("skipped = %v, want %v", report.Skipped, want)
+	}
+}
+
+func TestDeleteOrphansKeepsReferenced(t *testing.T) {
+	c, store := newTestClient()
+	m := testManifest("events", 0, 1)
+	putPartition(t, store, m)
+	first, _ := testSegment(10, 19)
+	second, _ := testSegment(20, 29)
+	putObject(t, store, segmentObjectKey(m, first, 1), "segment")
+	putObject(t, store, segmentObjectKey(m, second, 1), "segment")
+
+	report, err := c.FindOrphans(context.Background(), "events", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 2 {
+		t.Fatalf("found %d orphans, want 2", len(report.Orphans))
+	}
+	// the archiver adds the first segment to the manifest in the meantime
+	_, m.Segments[first] = testSegment(10, 19)
+	putManifest(t, store, m)
+
+	deleted, err := c.DeleteOrphans(context.Background(), report.Orphans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].Key != segmentObjectKey(m, second, 1) {
+		t.Errorf("deleted %v, want only %s", orphanKeys(deleted), segmentObjectKey(m, second, 1))
+	}
+	if !objectExists(t, store, segmentObjectKey(m, first, 1)) {
+		t.Errorf("referenced segment %s deleted", first)
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) json.RawMessage {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
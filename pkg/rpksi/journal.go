@@ -0,0 +1,469 @@
+package rpksi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Phase is a step of a deletion. Phases run in the order they are declared,
+// and each one is recorded in the journal once it has completed.
+type Phase string
+
+const (
+	PhasePlanned          Phase = "planned"
+	PhaseBackedUp         Phase = "backed-up"
+	PhaseManifestsWritten Phase = "manifests-written"
+	PhaseVerified         Phase = "verified"
+	PhaseSynchronized     Phase = "synchronized"
+	PhaseComplete         Phase = "complete"
+	PhaseRolledBack       Phase = "rolled-back"
+)
+
+var phaseOrder = []Phase{
+	PhasePlanned,
+	PhaseBackedUp,
+	PhaseManifestsWritten,
+	PhaseVerified,
+	PhaseSynchronized,
+	PhaseComplete,
+}
+
+// ErrSegmentsDeleted is returned when rolling back a deletion that already
+// removed segment objects, restoring the old manifests would reference them.
+var ErrSegmentsDeleted = errors.New("segments have already been deleted, the deletion can only be resumed")
+
+// Journal records the progress of a deletion so an interrupted run can be
+// resumed or rolled back. A journal without a path is kept in memory only.
+type Journal struct {
+	Phase   Phase         `json:"phase"`
+	Started time.Time     `json:"started"`
+	Plan    *DeletionPlan `json:"plan"`
+	// Backups maps manifest keys to the keys of their backup copies.
+	Backups map[string]string `json:"backups"`
+	// Written lists the manifests uploaded so far.
+	Written []string `json:"written"`
+	// Deleted lists the segment objects removed so far.
+	Deleted []string `json:"deleted"`
+	// Reviewed is set when the plan was reviewed before it was run, its
+	// manifests are then written as planned or not at all: a manifest that
+	// changed since it was read fails the deletion with ErrPlanStale rather
+	// than being re-planned.
+	Reviewed bool `json:"reviewed,omitempty"`
+
+	path string
+}
+
+// NewJournal starts a journal for plan, saved to path.
+func NewJournal(path string, plan *DeletionPlan) (*Journal, error) {
+	j := &Journal{
+		Phase:   PhasePlanned,
+		Started: time.Now().UTC(),
+		Plan:    plan,
+		Backups: make(map[string]string),
+		path:    path,
+	}
+	return j, j.save()
+}
+
+// OpenJournal reads the journal saved at path.
+func OpenJournal(path string) (*Journal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{path: path}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if j.Backups == nil {
+		j.Backups = make(map[string]string)
+	}
+	return j, nil
+}
+
+// Done reports whether the deletion either completed or was rolled back.
+func (j *Journal) Done() bool {
+	return j.Phase == PhaseComplete || j.Phase == PhaseRolledBack
+}
+
+// SetReviewed marks the plan as reviewed, see Reviewed.
+func (j *Journal) SetReviewed() error {
+	j.Reviewed = true
+	return j.save()
+}
+
+// wrote reports whether the manifest stored at key was uploaded.
+func (j *Journal) wrote(key string) bool {
+	for _, written := range j.Written {
+		if written == key {
+			return true
+		}
+	}
+	return false
+}
+
+// reached reports whether phase has already completed.
+func (j *Journal) reached(phase Phase) bool {
+	for _, p := range phaseOrder {
+		if p == phase {
+			return true
+		}
+		if p == j.Phase {
+			return false
+		}
+	}
+	return false
+}
+
+func (j *Journal) advance(phase Phase) error {
+	j.Phase = phase
+	return j.save()
+}
+
+// save writes the journal to a temporary file and renames it over the
+// previous version, so a crash never leaves a partially written journal.
+func (j *Journal) save() error {
+	if len(j.path) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
+
+// ApplyDeletion runs plan without a journal on disk.
+func (c *Client) ApplyDeletion(ctx context.Context, plan *DeletionPlan) error {
+	j, err := NewJournal("", plan)
+	if err != nil {
+		return err
+	}
+	return c.RunDeletion(ctx, j)
+}
+
+// RunDeletion runs the remaining phases of the journaled deletion. The old
+// manifests are backed up, the new manifests are uploaded and verified, local
+// state is synchronized and only then are the segment objects deleted.
+func (c *Client) RunDeletion(ctx context.Context, j *Journal) error {
+	if j.Phase == PhaseRolledBack {
+		return errors.New("deletion was rolled back")
+	}
+
+	if !j.reached(PhaseBackedUp) {
+		c.logf("Backing up manifests...")
+		for _, rewrite := range j.Plan.Manifests {
+			if _, ok := j.Backups[rewrite.Key]; ok {
+				continue
+			}
+			backupKey, err := c.BackupManifest(ctx, rewrite.Key, j.Started)
+			if err != nil {
+				return err
+			}
+			c.logf("  %s -> %s", rewrite.Key, backupKey)
+			j.Backups[rewrite.Key] = backupKey
+			if err := j.save(); err != nil {
+				return err
+			}
+		}
+		if err := j.advance(PhaseBackedUp); err != nil {
+			return err
+		}
+	}
+
+	if !j.reached(PhaseManifestsWritten) {
+		c.logf("Writing new manifest...")
+		for i := range j.Plan.Manifests {
+			if err := c.writeRewrite(ctx, j, i); err != nil {
+				return err
+			}
+			c.logf("  uploaded manifest %s", j.Plan.Manifests[i].Key)
+		}
+		if err := j.advance(PhaseManifestsWritten); err != nil {
+			return err
+		}
+	}
+
+	if !j.reached(PhaseVerified) {
+		c.logf("Verifying new manifest...")
+		for _, rewrite := range j.Plan.Manifests {
+			if err := c.verifyManifest(ctx, rewrite); err != nil {
+				return err
+			}
+			c.logf("  verified manifest %s", rewrite.Key)
+		}
+		if err := j.advance(PhaseVerified); err != nil {
+			return err
+		}
+	}
+
+	if !j.reached(PhaseSynchronized) {
+		c.logf("Synchronizing local state...")
+		if err := c.syncPartitions(ctx, j.Plan); err != nil {
+			return err
+		}
+		if err := j.advance(PhaseSynchronized); err != nil {
+			return err
+		}
+	}
+
+	if !j.reached(PhaseComplete) {
+		c.logf("Deleting segments...")
+		deleted := make(map[string]bool, len(j.Deleted))
+		for _, objectPath := range j.Deleted {
+			deleted[objectPath] = true
+		}
+		for _, segment := range j.Plan.Segments {
+			if deleted[segment.ObjectPath] {
+				continue
+			}
+			c.logf("  delete %s", segment.ObjectPath)
+			if err := c.Store.RemoveObject(ctx, segment.ObjectPath); err != nil {
+				return err
+			}
+			j.Deleted = append(j.Deleted, segment.ObjectPath)
+			if err := j.save(); err != nil {
+				return err
+			}
+		}
+		if err := j.advance(PhaseComplete); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RollbackDeletion restores the manifests backed up by an interrupted
+// deletion. It fails with ErrSegmentsDeleted once segment objects have been
+// removed. A backup is only copied over a manifest that still is the one the
+// deletion wrote, on the condition that its ETag has not changed since it was
+// read. When the archiver changed the manifest since, the removed segments are
+// added back to the current manifest instead. Manifests the deletion did not
+// write are left alone.
+func (c *Client) RollbackDeletion(ctx context.Context, j *Journal) error {
+	if j.Phase == PhaseRolledBack {
+		return nil
+	}
+	if j.Phase == PhaseComplete || len(j.Deleted) > 0 {
+		return ErrSegmentsDeleted
+	}
+
+	if j.reached(PhaseBackedUp) || len(j.Backups) > 0 {
+		c.logf("Restoring manifests...")
+		for _, rewrite := range j.Plan.Manifests {
+			backupKey, ok := j.Backups[rewrite.Key]
+			if !ok {
+				continue
+			}
+			if err := c.restoreRewrite(ctx, rewrite, backupKey, j.wrote(rewrite.Key)); err != nil {
+				return err
+			}
+		}
+		c.logf("Synchronizing local state...")
+		if err := c.syncPartitions(ctx, j.Plan); err != nil {
+			return err
+		}
+	}
+	return j.advance(PhaseRolledBack)
+}
+
+// restoreRewrite undoes a manifest rewrite with the backup taken before it
+// was written, see RollbackDeletion. A manifest that was not recorded as
+// written is only restored when it still is the rewritten one, the upload
+// having succeeded right before the deletion was interrupted.
+func (c *Client) restoreRewrite(ctx context.Context, rewrite ManifestRewrite, backupKey string, written bool) error {
+	backupData, err := c.readObject(ctx, backupKey)
+	if err != nil {
+		return err
+	}
+	backup, err := c.ReadManifest(ctx, backupKey)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		current, err := c.ReadManifestObject(ctx, rewrite.Key)
+		if err != nil {
+			return err
+		}
+		restored := current.Manifest
+		restored.Segments = make(map[string]Segment, len(current.Manifest.Segments)+len(rewrite.Removed))
+		for name, segment := range current.Manifest.Segments {
+			restored.Segments[name] = segment
+		}
+		rewritten, err := sameManifest(current.Manifest, rewrite.Manifest)
+		if err != nil {
+			return err
+		}
+		if !written && !rewritten {
+			c.logf("  %s was not rewritten, leaving it", rewrite.Key)
+			return nil
+		}
+		var missing []string
+		for _, name := range rewrite.Removed {
+			if _, ok := restored.Segments[name]; ok {
+				continue
+			}
+			if segment, ok := backup.Segments[name]; ok {
+				restored.Segments[name] = segment
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) == 0 {
+			c.logf("  %s already references the removed segments", rewrite.Key)
+			return nil
+		}
+
+		if rewritten {
+			err = c.putIfMatch(ctx, rewrite.Key, backupData, current.ETag)
+		} else {
+			err = c.WriteManifestIfMatch(ctx, rewrite.Key, restored, current.ETag)
+		}
+		if err == nil {
+			if rewritten {
+				c.logf("  %s -> %s", backupKey, rewrite.Key)
+			} else {
+				c.logf("  added %d segments back to %s, it changed since it was rewritten", len(missing), rewrite.Key)
+			}
+			return nil
+		}
+		if !errors.Is(err, ErrManifestConflict) {
+			return err
+		}
+		if attempt == maxReplans {
+			return fmt.Errorf("%s: %w (gave up after %d attempts)", rewrite.Key, err, attempt+1)
+		}
+		c.logf("  manifest %s changed while restoring it, retrying", rewrite.Key)
+	}
+}
+
+func (c *Client) copyObject(ctx context.Context, src, dst string) error {
+	data, err := c.readObject(ctx, src)
+	if err != nil {
+		return err
+	}
+	return c.Store.PutObject(ctx, dst, bytes.NewReader(data), int64(len(data)))
+}
+
+// maxReplans bounds how often a manifest rewrite is re-planned when the
+// archiver keeps changing the manifest.
+const maxReplans = 5
+
+// writeRewrite uploads the i-th manifest rewrite of the journaled plan on the
+// condition that the manifest has not changed since it was read. On conflict
+// the rewrite is re-planned against the current manifest, which is backed up
+// again, and the upload retried, unless the plan was reviewed.
+func (c *Client) writeRewrite(ctx context.Context, j *Journal, i int) error {
+	for attempt := 0; ; attempt++ {
+		rewrite := j.Plan.Manifests[i]
+		// a resumed run may find the rewrite already uploaded
+		written, err := c.manifestMatches(ctx, rewrite)
+		if err != nil {
+			return err
+		}
+		if !written {
+			err = c.WriteManifestIfMatch(ctx, rewrite.Key, rewrite.Manifest, rewrite.ETag)
+		}
+		if err == nil {
+			if j.wrote(rewrite.Key) {
+				return nil
+			}
+			j.Written = append(j.Written, rewrite.Key)
+			return j.save()
+		}
+		if !errors.Is(err, ErrManifestConflict) {
+			return err
+		}
+		if j.Reviewed {
+			return fmt.Errorf("%w: manifest %s changed since it was read", ErrPlanStale, rewrite.Key)
+		}
+		if attempt == maxReplans {
+			return fmt.Errorf("%s: %w (gave up after %d attempts)", rewrite.Key, err, attempt+1)
+		}
+		c.logf("  manifest %s changed since it was read, re-planning", rewrite.Key)
+		referenced, err := c.replanManifest(ctx, j.Plan, i)
+		if err != nil {
+			return err
+		}
+		// the backup must hold the removed segments for a rollback to
+		// restore them, a manifest without them is not backed up again
+		if referenced {
+			backupKey, err := c.BackupManifest(ctx, rewrite.Key, time.Now())
+			if err != nil {
+				return err
+			}
+			c.logf("  %s -> %s", rewrite.Key, backupKey)
+			j.Backups[rewrite.Key] = backupKey
+		}
+		if err := j.save(); err != nil {
+			return err
+		}
+	}
+}
+
+// manifestMatches reports whether the manifest stored at the rewrite's key is
+// the rewritten manifest.
+func (c *Client) manifestMatches(ctx context.Context, rewrite ManifestRewrite) (bool, error) {
+	current, err := c.ReadManifest(ctx, rewrite.Key)
+	if err != nil {
+		return false, err
+	}
+	return sameManifest(current, rewrite.Manifest)
+}
+
+// sameManifest reports whether two manifests have the same contents.
+func sameManifest(a, b Manifest) (bool, error) {
+	want, err := json.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	got, err := json.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(want, got), nil
+}
+
+// verifyManifest reads back an uploaded manifest and checks that none of the
+// removed segments are referenced. Segments the archiver appended since the
+// upload are fine.
+func (c *Client) verifyManifest(ctx context.Context, rewrite ManifestRewrite) error {
+	uploaded, err := c.ReadManifest(ctx, rewrite.Key)
+	if err != nil {
+		return err
+	}
+	for _, name := range rewrite.Removed {
+		if _, ok := uploaded.Segments[name]; ok {
+			return fmt.Errorf("manifest %s still references segment %s", rewrite.Key, name)
+		}
+	}
+	return nil
+}
+
+func (c *Client) syncPartitions(ctx context.Context, plan *DeletionPlan) error {
+	for _, rewrite := range plan.Manifests {
+		c.logf("  POST request to %s", c.SyncLocalStateURL(rewrite.Manifest.Topic, rewrite.Manifest.Partition))
+		if err := c.SyncLocalState(ctx, rewrite.Manifest.Topic, rewrite.Manifest.Partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+package rpksi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"rpksi/pkg/storage"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// interruptingStore fails every change to the bucket after the first
+// remaining ones, as if the deletion was interrupted there.
+type interruptingStore struct {
+	*storage.MemoryStore
+	remaining int
+}
+
+func (s *interruptingStore) change() error {
+	if s.remaining == 0 {
+		return errInterrupted
+	}
+	s.remaining--
+	return nil
+}
+
+func (s *interruptingStore) PutObject(ctx context.Context, key string, reader io.Reader, size int64) error {
+	if err := s.change(); err != nil {
+		return err
+	}
+	return s.MemoryStore.PutObject(ctx, key, reader, size)
+}
+
+func (s *interruptingStore) PutObjectIfMatch(ctx context.Context, key string, reader io.Reader, size int64, etag string) error {
+	if err := s.change(); err != nil {
+		return err
+	}
+	return s.MemoryStore.PutObjectIfMatch(ctx, key, reader, size, etag)
+}
+
+func (s *interruptingStore) RemoveObject(ctx context.Context, key string) error {
+	if err := s.change(); err != nil {
+		return err
+	}
+	return s.MemoryStore.RemoveObject(ctx, key)
+}
+
+// newDeletionTest returns a client whose admin API accepts every request,
+// and two partitions of three segments each in its store.
+func newDeletionTest(t *testing.T) (*Client, *storage.MemoryStore, []Manifest) {
+	t.Helper()
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(admin.Close)
+	c, store := newTestClient()
+	c.AdminAPI = strings.TrimPrefix(admin.URL, "http://")
+	manifests := []Manifest{testManifest("events", 0, 3), testManifest("events", 1, 3)}
+	for _, m := range manifests {
+		putPartition(t, store, m)
+	}
+	return c, store, manifests
+}
+
+func segmentNames(m Manifest) []string {
+	var names []string
+	for name := range m.Segments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkDeleted checks that the first two segments of each partition are gone
+// from the manifests and the bucket, and that the others are kept.
+func checkDeleted(t *testing.T, c *Client, store storage.ObjectStore, manifests []Manifest, kept ...string) {
+	t.Helper()
+	for _, m := range manifests {
+		want := append([]string{"20-1-v1.log"}, kept...)
+		sort.Strings(want)
+		if got := segmentNames(readTestManifest(t, c, m)); !reflect.DeepEqual(got, want) {
+			t.Errorf("partition %d has segments %v, want %v", m.Partition, got, want)
+		}
+		for _, name := range []string{"0-1-v1.log", "10-1-v1.log"} {
+			if objectExists(t, store, segmentObjectKey(m, name, 1)) {
+				t.Errorf("partition %d: object of %s not deleted", m.Partition, name)
+			}
+		}
+		if !objectExists(t, store, segmentObjectKey(m, "20-1-v1.log", 1)) {
+			t.Errorf("partition %d: object of 20-1-v1.log deleted", m.Partition)
+		}
+	}
+}
+
+// checkRestored checks that the manifests reference every segment they did
+// before the deletion, and that their objects exist.
+func checkRestored(t *testing.T, c *Client, store storage.ObjectStore, manifests []Manifest, kept ...string) {
+	t.Helper()
+	for _, m := range manifests {
+		want := append(segmentNames(m), kept...)
+		sort.Strings(want)
+		if got := segmentNames(readTestManifest(t, c, m)); !reflect.DeepEqual(got, want) {
+			t.Errorf("partition %d has segments %v, want %v", m.Partition, got, want)
+		}
+		for name := range m.Segments {
+			if !objectExists(t, store, segmentObjectKey(m, name, 1)) {
+				t.Errorf("partition %d: object of %s deleted", m.Partition, name)
+			}
+		}
+	}
+}
+
+// interruptDeletion plans the deletion of the segments before offset 20 and
+// runs it until the store fails after changes changes. It returns the path of
+// the journal and whether the run completed.
+func interruptDeletion(t *testing.T, c *Client, store *storage.MemoryStore, changes int) (string, bool) {
+	t.Helper()
+	plan, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "journal.json")
+	j, err := NewJournal(path, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Store = &interruptingStore{MemoryStore: store, remaining: changes}
+	err = c.RunDeletion(context.Background(), j)
+	c.Store = store
+	if err != nil && !errors.Is(err, errInterrupted) {
+		t.Fatal(err)
+	}
+	return path, err == nil
+}
+
+func openJournal(t *testing.T, path string) *Journal {
+	t.Helper()
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// appendSegment adds a segment to every manifest as the archiver would.
+func appendSegment(t *testing.T, c *Client, store *storage.MemoryStore, manifests []Manifest) string {
+	t.Helper()
+	name, segment := testSegment(30, 39)
+	for _, m := range manifests {
+		current := readTestManifest(t, c, m)
+		current.Segments[name] = segment
+		current.LastOffset = segment.CommittedOffset
+		putObject(t, store, segmentObjectKey(m, name, 1), "segment")
+		putManifest(t, store, current)
+	}
+	return name
+}
+
+func TestResumeDeletion(t *testing.T) {
+	for _, archiver := range []bool{false, true} {
+		for changes := 0; ; changes++ {
+			c, store, manifests := newDeletionTest(t)
+			path, done := interruptDeletion(t, c, store, changes)
+			if done {
+				break
+			}
+			var kept []string
+			if archiver {
+				kept = append(kept, appendSegment(t, c, store, manifests))
+			}
+			j := openJournal(t, path)
+			if err := c.RunDeletion(context.Background(), j); err != nil {
+				t.Fatalf("resuming after %d changes (phase %s): %v", changes, j.Phase, err)
+			}
+			if j.Phase != PhaseComplete {
+				t.Errorf("resuming after %d changes: phase %s, want %s", changes, j.Phase, PhaseComplete)
+			}
+			checkDeleted(t, c, store, manifests, kept...)
+		}
+	}
+}
+
+func TestRollbackDeletion(t *testing.T) {
+	for _, archiver := range []bool{false, true} {
+		for changes := 0; ; changes++ {
+			c, store, manifests := newDeletionTest(t)
+			path, done := interruptDeletion(t, c, store, changes)
+			if done {
+				break
+			}
+			var kept []string
+			if archiver {
+				kept = append(kept, appendSegment(t, c, store, manifests))
+			}
+			j := openJournal(t, path)
+			err := c.RollbackDeletion(context.Background(), j)
+			if errors.Is(err, ErrSegmentsDeleted) {
+				if len(j.Deleted) == 0 {
+					t.Errorf("rollback after %d changes refused without deleted segments", changes)
+				}
+				if err := c.RunDeletion(context.Background(), j); err != nil {
+					t.Fatalf("resuming after %d changes: %v", changes, err)
+				}
+				checkDeleted(t, c, store, manifests, kept...)
+				continue
+			}
+			if err != nil {
+				t.Fatalf("rolling back after %d changes (phase %s): %v", changes, j.Phase, err)
+			}
+			if j.Phase != PhaseRolledBack {
+				t.Errorf("rolling back after %d changes: phase %s, want %s", changes, j.Phase, PhaseRolledBack)
+			}
+			checkRestored(t, c, store, manifests, kept...)
+		}
+	}
+}
+
+func TestRollbackLeavesUnwrittenManifests(t *testing.T) {
+	c, store, manifests := newDeletionTest(t)
+	// back up both manifests, then stop before the first one is written
+	path, _ := interruptDeletion(t, c, store, 2)
+	j := openJournal(t, path)
+	if j.Phase != PhaseBackedUp {
+		t.Fatalf("phase %s, want %s", j.Phase, PhaseBackedUp)
+	}
+	// the archiver uploads a manifest without the first segment meanwhile
+	changed := readTestManifest(t, c, manifests[0])
+	delete(changed.Segments, "0-1-v1.log")
+	putManifest(t, store, changed)
+	if err := c.RollbackDeletion(context.Background(), j); err != nil {
+		t.Fatal(err)
+	}
+	// 0-1-v1.log was not removed by the deletion, it is not restored
+	if got := segmentNames(readTestManifest(t, c, manifests[0])); reflect.DeepEqual(got, segmentNames(manifests[0])) {
+		t.Errorf("rollback overwrote a manifest the deletion did not write")
+	}
+}
+
+// racingStore runs race right before the next races uploads of the manifest
+// at key, as if the archiver changed it between the read and the write.
+// Without conditional puts it races on the check preceding the upload.
+type racingStore struct {
+	*storage.MemoryStore
+	key   string
+	races int
+	race  func()
+}
+
+func (s *racingStore) maybeRace(key string) {
+	if key == s.key && s.races > 0 {
+		s.races--
+		s.race()
+	}
+}
+
+func (s *racingStore) PutObjectIfMatch(ctx context.Context, key string, reader io.Reader, size int64, etag string) error {
+	s.maybeRace(key)
+	return s.MemoryStore.PutObjectIfMatch(ctx, key, reader, size, etag)
+}
+
+// unconditionalStore hides the conditional puts of racingStore, its
+// PutObjectIfMatch field shadowing the promoted method.
+type unconditionalStore struct {
+	*racingStore
+	PutObjectIfMatch struct{}
+}
+
+func (s *unconditionalStore) StatObject(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	s.maybeRace(key)
+	return s.racingStore.StatObject(ctx, key)
+}
+
+// newRacingStore returns a store appending a new segment to every manifest
+// when racing uploads of the first one, and the names of the segments
+// appended so far.
+func newRacingStore(t *testing.T, c *Client, store *storage.MemoryStore, manifests []Manifest, races int) (*racingStore, *[]string) {
+	var appended []string
+	s := &racingStore{MemoryStore: store, key: manifestKey(manifests[0]), races: races}
+	s.race = func() {
+		base := uint64(30 + 10*len(appended))
+		name, segment := testSegment(base, base+9)
+		for _, m := range manifests {
+			current := readTestManifest(t, c, m)
+			current.Segments[name] = segment
+			current.LastOffset = segment.CommittedOffset
+			putObject(t, store, segmentObjectKey(m, name, 1), "segment")
+			putManifest(t, store, current)
+		}
+		appended = append(appended, name)
+	}
+	return s, &appended
+}
+
+func TestRunDeletionConflicts(t *testing.T) {
+	for _, conditional := range []bool{true, false} {
+		t.Run(fmt.Sprintf("conditional=%v", conditional), func(t *testing.T) {
+			c, store, manifests := newDeletionTest(t)
+			plan, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 20})
+			if err != nil {
+				t.Fatal(err)
+			}
+			j, err := NewJournal("", plan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			racing, appended := newRacingStore(t, c, store, manifests, 2)
+			c.Store = racing
+			if !conditional {
+				c.Store = &unconditionalStore{racingStore: racing}
+			}
+			if _, ok := c.Store.(storage.ConditionalPutter); ok != conditional {
+				t.Fatalf("store implements ConditionalPutter: %v", ok)
+			}
+			if err := c.RunDeletion(context.Background(), j); err != nil {
+				t.Fatal(err)
+			}
+			if racing.races != 0 || len(*appended) != 2 {
+				t.Fatalf("%d races left, %d segments appended", racing.races, len(*appended))
+			}
+			// the segments appended by each race are kept, and the backups
+			// taken when re-planning hold them for a rollback
+			checkDeleted(t, c, store, manifests, *appended...)
+			for _, m := range manifests {
+				backup, err := c.ReadManifest(context.Background(), j.Backups[manifestKey(m)])
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, name := range append([]string{"0-1-v1.log", "10-1-v1.log"}, *appended...) {
+					if _, ok := backup.Segments[name]; !ok {
+						t.Errorf("partition %d: backup misses %s", m.Partition, name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRunDeletionGivesUp(t *testing.T) {
+	c, store, manifests := newDeletionTest(t)
+	plan, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := NewJournal("", plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	racing, appended := newRacingStore(t, c, store, manifests, 100)
+	c.Store = racing
+	err = c.RunDeletion(context.Background(), j)
+	if !errors.Is(err, ErrManifestConflict) {
+		t.Fatalf("deletion racing the archiver: %v, want %v", err, ErrManifestConflict)
+	}
+	if attempts := len(*appended); attempts != maxReplans+1 {
+		t.Errorf("%d attempts, want %d", attempts, maxReplans+1)
+	}
+	// nothing was deleted and the first manifest was not written
+	c.Store = store
+	checkRestored(t, c, store, manifests[:1], *appended...)
+	for _, m := range manifests {
+		for name := range m.Segments {
+			if !objectExists(t, store, segmentObjectKey(m, name, 1)) {
+				t.Errorf("partition %d: object of %s deleted", m.Partition, name)
+			}
+		}
+	}
+}
+
+func TestRunDeletionReviewedPlan(t *testing.T) {
+	c, store, manifests := newDeletionTest(t)
+	plan, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckPlan(context.Background(), plan); err != nil {
+		t.Fatal(err)
+	}
+	j, err := NewJournal(filepath.Join(t.TempDir(), "journal.json"), plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.SetReviewed(); err != nil {
+		t.Fatal(err)
+	}
+	// the archiver writes the first manifest after the plan was checked
+	racing, appended := newRacingStore(t, c, store, manifests, 1)
+	c.Store = racing
+	err = c.RunDeletion(context.Background(), j)
+	if !errors.Is(err, ErrPlanStale) {
+		t.Fatalf("deletion of a reviewed plan racing the archiver: %v, want %v", err, ErrPlanStale)
+	}
+	if len(*appended) != 1 {
+		t.Fatalf("%d segments appended, want 1", len(*appended))
+	}
+	if j.Plan.Manifests[0].ETag != plan.Manifests[0].ETag || len(j.Written) != 0 {
+		t.Errorf("the plan was re-planned, %d manifests written", len(j.Written))
+	}
+	// the reviewed flag survives a resume
+	if resumed := openJournal(t, j.path); !resumed.Reviewed {
+		t.Error("resumed journal lost the reviewed flag")
+	}
+	// nothing was written nor deleted
+	c.Store = store
+	checkRestored(t, c, store, manifests, *appended...)
+}
@@ -0,0 +1,271 @@
+package rpksi
+
+import (
+	"context"
+	"rpksi/pkg/storage"
+	"sort"
+)
+
+// Filter selects the segments an operation applies to. The zero value
+// matches every segment of every topic.
+type Filter struct {
+	// Topic limits results to a single topic.
+	Topic string `json:"topic,omitempty"`
+	// Partitions limits results to the given partitions, nil matches all.
+	Partitions []int `json:"partitions,omitempty"`
+	// OlderThan matches segments whose max timestamp is below this unix
+	// timestamp in milliseconds (exclusive), 0 disables the check.
+	OlderThan int64 `json:"older_than,omitempty"`
+	// NewerThan matches segments whose base timestamp is above this unix
+	// timestamp in milliseconds (exclusive), 0 disables the check.
+	NewerThan int64 `json:"newer_than,omitempty"`
+	// Between matches segments holding any record timestamped within the
+	// range, nil disables the check. Deletions only match segments whose
+	// records are all within the range, see MatchWithin.
+	Between *TimeRange `json:"between,omitempty"`
+	// Offset matches segments whose committed offset is below this offset
+	// (exclusive), nil disables the check. An offset of 0 matches nothing.
+	Offset *int64 `json:"offset,omitempty"`
+	// Offsets matches segments holding any offset within the range, nil
+	// disables the check.
+	Offsets *OffsetRange `json:"offsets,omitempty"`
+}
+
+// OffsetRange is an inclusive range of log offsets, as recorded by the
+// base_offset and committed_offset of manifest segments.
+type OffsetRange struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// MatchTopic reports whether the filter includes topic.
+func (f Filter) MatchTopic(topic string) bool {
+	return len(f.Topic) == 0 || f.Topic == topic
+}
+
+// MatchPartition reports whether the filter includes partition.
+func (f Filter) MatchPartition(partition int) bool {
+	if len(f.Partitions) == 0 {
+		return true
+	}
+	for _, p := range f.Partitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the filter includes segment s of manifest m.
+func (f Filter) Match(m Manifest, s Segment) bool {
+	if !f.MatchTopic(m.Topic) || !f.MatchPartition(m.Partition) {
+		return false
+	}
+	if f.OlderThan != 0 && f.OlderThan <= int64(s.MaxTimestamp) {
+		return false
+	}
+	if f.NewerThan != 0 && f.NewerThan >= int64(s.BaseTimestamp) {
+		return false
+	}
+	if f.Between != nil && (int64(s.MaxTimestamp) < f.Between.From || int64(s.BaseTimestamp) > f.Between.To) {
+		return false
+	}
+	if f.Offset != nil && *f.Offset <= int64(s.CommittedOffset) {
+		return false
+	}
+	if f.Offsets != nil && (s.CommittedOffset < f.Offsets.From || s.BaseOffset > f.Offsets.To) {
+		return false
+	}
+	return true
+}
+
+// MatchWithin is Match for deletions: the segment must lie entirely within
+// the Between and Offsets ranges, rather than overlap them, so that no
+// record outside of the ranges is deleted along with it.
+func (f Filter) MatchWithin(m Manifest, s Segment) bool {
+	if !f.Match(m, s) {
+		return false
+	}
+	if f.Between != nil && (int64(s.BaseTimestamp) < f.Between.From || int64(s.MaxTimestamp) > f.Between.To) {
+		return false
+	}
+	if f.Offsets != nil && (s.BaseOffset < f.Offsets.From || s.CommittedOffset > f.Offsets.To) {
+		return false
+	}
+	return true
+}
+
+// selectsSegments reports whether the filter narrows down segments, rather
+// than matching every segment of a topic.
+func (f Filter) selectsSegments() bool {
+	return f.OlderThan != 0 || f.NewerThan != 0 || f.Between != nil || f.Offset != nil || f.Offsets != nil
+}
+
+// RowTopic summarizes the archived segments of a topic. Sizes are in bytes.
+// PartitionCount is the number of archived partitions and
+// ManifestPartitionCount the partition count of the topic manifest, 0 when
+// the topic has none.
+type RowTopic struct {
+	TopicName              string `json:"topic_name" yaml:"topic_name"`
+	TopicSize              uint64 `json:"topic_size" yaml:"topic_size"`
+	SegmentCount           int    `json:"segment_count" yaml:"segment_count"`
+	SegmentOldOffsetId     uint64 `json:"segment_old_offset_id" yaml:"segment_old_offset_id"`
+	SegmentNewOffsetId     uint64 `json:"segment_new_offset_id" yaml:"segment_new_offset_id"`
+	PartitionCount         int    `json:"partition_count" yaml:"partition_count"`
+	ManifestPartitionCount int    `json:"manifest_partition_count" yaml:"manifest_partition_count"`
+	PartitionMismatch      bool   `json:"partition_mismatch" yaml:"partition_mismatch"`
+}
+
+// RowSegment describes a single archived segment. Sizes are in bytes and
+// dates are unix timestamps in milliseconds.
+type RowSegment struct {
+	Delete               bool   `json:"-" yaml:"-"`
+	ObjectPath           string `json:"object_path" yaml:"object_path"`
+	Partition            int    `json:"partition" yaml:"partition"`
+	TopicName            string `json:"topic_name" yaml:"topic_name"`
+	SegmentName          string `json:"segment_name" yaml:"segment_name"`
+	SegmentSize          uint64 `json:"segment_size" yaml:"segment_size"`
+	SegmentOldOffsetDate uint64 `json:"segment_old_offset_date" yaml:"segment_old_offset_date"`
+	SegmentNewOffsetDate uint64 `json:"segment_new_offset_date" yaml:"segment_new_offset_date"`
+	SegmentOldOffsetId   uint64 `json:"segment_old_offset_id" yaml:"segment_old_offset_id"`
+	SegmentNewOffsetId   uint64 `json:"segment_new_offset_id" yaml:"segment_new_offset_id"`
+}
+
+func newRowSegment(m Manifest, name string, s Segment) RowSegment {
+	return RowSegment{
+		Partition:            m.Partition,
+		TopicName:            m.Topic,
+		SegmentName:          name,
+		SegmentSize:          s.SizeBytes,
+		SegmentOldOffsetDate: s.BaseTimestamp,
+		SegmentNewOffsetDate: s.MaxTimestamp,
+		SegmentOldOffsetId:   s.BaseOffset,
+		SegmentNewOffsetId:   s.CommittedOffset,
+	}
+}
+
+// ListTopics summarizes the segments matching filter for each archived topic,
+// sorted by topic name. Topics whose number of archived partitions differs
+// from the partition count of their topic manifest are flagged, including
+// topics with a topic manifest but no archived partitions.
+func (c *Client) ListTopics(ctx context.Context, filter Filter) ([]RowTopic, error) {
+	objects, err := c.Store.ListObjects(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	manifests, _, err := c.readManifests(ctx, objects, filter.Topic)
+	if err != nil {
+		return nil, err
+	}
+	topicManifests, err := c.readTopicManifests(ctx, objects, filter.Topic)
+	if err != nil {
+		return nil, err
+	}
+	return topicRows(filter, manifests, topicManifests), nil
+}
+
+// ListSegments returns the segments matching filter along with the keys of
+// their objects, sorted by topic, partition and base offset.
+func (c *Client) ListSegments(ctx context.Context, filter Filter) ([]RowSegment, error) {
+	objects, err := c.Store.ListObjects(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	manifests, _, err := c.readManifests(ctx, objects, filter.Topic)
+	if err != nil {
+		return nil, err
+	}
+	return segmentRows(filter, objects, manifests), nil
+}
+
+// ListTopicsAndSegments returns both ListTopics and ListSegments, listing the
+// bucket and reading the manifests once.
+func (c *Client) ListTopicsAndSegments(ctx context.Context, filter Filter) ([]RowTopic, []RowSegment, error) {
+	objects, err := c.Store.ListObjects(ctx, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	manifests, _, err := c.readManifests(ctx, objects, filter.Topic)
+	if err != nil {
+		return nil, nil, err
+	}
+	topicManifests, err := c.readTopicManifests(ctx, objects, filter.Topic)
+	if err != nil {
+		return nil, nil, err
+	}
+	return topicRows(filter, manifests, topicManifests), segmentRows(filter, objects, manifests), nil
+}
+
+func topicRows(filter Filter, manifests []ManifestObject, topicManifests map[string]TopicManifestObject) []RowTopic {
+	topics := make(map[string]*RowTopic)
+	partitions := make(map[string]map[int]bool)
+	for name := range topicManifests {
+		topics[name] = &RowTopic{TopicName: name}
+	}
+	for _, mo := range manifests {
+		manifest := mo.Manifest
+		topic, ok := topics[manifest.Topic]
+		if !ok {
+			topic = &RowTopic{TopicName: manifest.Topic}
+			topics[manifest.Topic] = topic
+		}
+		if partitions[manifest.Topic] == nil {
+			partitions[manifest.Topic] = make(map[int]bool)
+		}
+		partitions[manifest.Topic][manifest.Partition] = true
+		if manifest.LastOffset > topic.SegmentNewOffsetId {
+			topic.SegmentNewOffsetId = manifest.LastOffset
+		}
+		for _, segment := range manifest.Segments {
+			if !filter.Match(manifest, segment) {
+				continue
+			}
+			if topic.SegmentCount == 0 || segment.BaseOffset < topic.SegmentOldOffsetId {
+				topic.SegmentOldOffsetId = segment.BaseOffset
+			}
+			topic.SegmentCount++
+			topic.TopicSize += segment.SizeBytes
+		}
+	}
+	rows := make([]RowTopic, 0, len(topics))
+	for name, topic := range topics {
+		topic.PartitionCount = len(partitions[name])
+		if tmo, ok := topicManifests[name]; ok {
+			topic.ManifestPartitionCount = tmo.Manifest.PartitionCount
+			topic.PartitionMismatch = topic.PartitionCount != topic.ManifestPartitionCount
+		}
+		rows = append(rows, *topic)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].TopicName < rows[j].TopicName
+	})
+	return rows
+}
+
+func segmentRows(filter Filter, objects []storage.ObjectInfo, manifests []ManifestObject) []RowSegment {
+	objectPaths := segmentObjectPaths(objects)
+	var rows []RowSegment
+	for _, mo := range manifests {
+		for name, segment := range mo.Manifest.Segments {
+			if filter.Match(mo.Manifest, segment) {
+				row := newRowSegment(mo.Manifest, name, segment)
+				row.ObjectPath = objectPaths[mo.Manifest.Key(name)]
+				rows = append(rows, row)
+			}
+		}
+	}
+	sortSegments(rows)
+	return rows
+}
+
+func sortSegments(rows []RowSegment) {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].TopicName != rows[j].TopicName {
+			return rows[i].TopicName < rows[j].TopicName
+		}
+		if rows[i].Partition != rows[j].Partition {
+			return rows[i].Partition < rows[j].Partition
+		}
+		return rows[i].SegmentOldOffsetId < rows[j].SegmentOldOffsetId
+	})
+}
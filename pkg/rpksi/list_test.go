@@ -0,0 +1,120 @@
+package rpksi
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"rpksi/pkg/storage"
+	"testing"
+)
+
+func segmentRowNames(rows []RowSegment) []string {
+	var names []string
+	for _, row := range rows {
+		names = append(names, row.TopicName+"/"+row.SegmentName)
+	}
+	return names
+}
+
+func offset(o int64) *int64 {
+	return &o
+}
+
+func TestListSegments(t *testing.T) {
+	c, store := newTestClient()
+	events := testManifest("events", 0, 3)
+	putPartition(t, store, events)
+	putPartition(t, store, testManifest("logs", 0, 1))
+
+	for _, tt := range []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"all", Filter{}, []string{"events/0-1-v1.log", "events/10-1-v1.log", "events/20-1-v1.log", "logs/0-1-v1.log"}},
+		{"topic", Filter{Topic: "logs"}, []string{"logs/0-1-v1.log"}},
+		{"partition", Filter{Partitions: []int{1}}, nil},
+		{"older than", Filter{Topic: "events", OlderThan: 20}, []string{"events/0-1-v1.log", "events/10-1-v1.log"}},
+		{"newer than", Filter{Topic: "events", NewerThan: 15}, []string{"events/20-1-v1.log"}},
+		{"between", Filter{Topic: "events", Between: &TimeRange{From: 12, To: 25}}, []string{"events/10-1-v1.log", "events/20-1-v1.log"}},
+		{"offsets", Filter{Topic: "events", Offsets: &OffsetRange{From: 19, To: 20}}, []string{"events/10-1-v1.log", "events/20-1-v1.log"}},
+		{"offset", Filter{Topic: "events", Offset: offset(20)}, []string{"events/0-1-v1.log", "events/10-1-v1.log"}},
+		{"offset 0", Filter{Topic: "events", Offset: offset(0)}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := c.ListSegments(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := segmentRowNames(rows); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("segments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	rows, err := c.ListSegments(context.Background(), Filter{Topic: "events", OlderThan: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RowSegment{
+		ObjectPath:           segmentObjectKey(events, "0-1-v1.log", 1),
+		TopicName:            "events",
+		SegmentName:          "0-1-v1.log",
+		SegmentSize:          1024,
+		SegmentOldOffsetDate: 0,
+		SegmentNewOffsetDate: 9,
+		SegmentOldOffsetId:   0,
+		SegmentNewOffsetId:   9,
+	}
+	if len(rows) != 1 || rows[0] != want {
+		t.Errorf("rows = %+v, want %+v", rows, want)
+	}
+}
+
+// countingStore counts the listings and object reads of a store.
+type countingStore struct {
+	*storage.MemoryStore
+	lists, gets int
+}
+
+func (s *countingStore) ListObjects(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	s.lists++
+	return s.MemoryStore.ListObjects(ctx, prefix)
+}
+
+func (s *countingStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.gets++
+	return s.MemoryStore.GetObject(ctx, key)
+}
+
+func TestListTopicsAndSegments(t *testing.T) {
+	c, store := newTestClient()
+	putPartition(t, store, testManifest("events", 0, 3))
+	putPartition(t, store, testManifest("events", 1, 2))
+	putPartition(t, store, testManifest("logs", 0, 1))
+	putObject(t, store, testHash+"/meta/kafka/events/"+TopicManifestFileName, `{"version":1,"namespace":"kafka","topic":"events","partition_count":3}`)
+	filter := Filter{OlderThan: 20}
+
+	wantTopics, err := c.ListTopics(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSegments, err := c.ListSegments(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counting := &countingStore{MemoryStore: store}
+	c.Store = counting
+	topics, segments, err := c.ListTopicsAndSegments(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(topics, wantTopics) || !reflect.DeepEqual(segments, wantSegments) {
+		t.Errorf("listed %+v and %+v, want %+v and %+v", topics, segments, wantTopics, wantSegments)
+	}
+	// one listing, and one read of each partition and topic manifest
+	if counting.lists != 1 || counting.gets != 4 {
+		t.Errorf("%d listings and %d reads, want 1 and 4", counting.lists, counting.gets)
+	}
+}
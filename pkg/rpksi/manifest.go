@@ -0,0 +1,161 @@
+package rpksi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	// Namespace is the namespace Redpanda archives kafka topics under.
+	Namespace = "kafka"
+	// ManifestFileName is the object name of a partition manifest.
+	ManifestFileName = "manifest.json"
+	// BinaryManifestFileName is the object name of a partition manifest in
+	// the serde binary format written by newer Redpanda versions.
+	BinaryManifestFileName = "manifest.bin"
+)
+
+// Segment is the entry of a segment in a partition manifest. Fields not
+// modeled here are kept in Extra and written back unchanged.
+type Segment struct {
+	IsCompacted     bool                       `json:"is_compacted"`
+	SizeBytes       uint64                     `json:"size_bytes"`
+	CommittedOffset uint64                     `json:"committed_offset"`
+	BaseOffset      uint64                     `json:"base_offset"`
+	BaseTimestamp   uint64                     `json:"base_timestamp"`
+	MaxTimestamp    uint64                     `json:"max_timestamp"`
+	DeltaOffset     uint64                     `json:"delta_offset"`
+	ArchiverTerm    int                        `json:"archiver_term"`
+	Extra           map[string]json.RawMessage `json:"-"`
+}
+
+func (s *Segment) UnmarshalJSON(data []byte) error {
+	type segment Segment
+	extra, err := unmarshalWithExtra(data, (*segment)(s))
+	s.Extra = extra
+	return err
+}
+
+func (s Segment) MarshalJSON() ([]byte, error) {
+	type segment Segment
+	return marshalWithExtra(segment(s), s.Extra)
+}
+
+// Manifest is a partition manifest. Fields not modeled here, such as the
+// ones added by newer Redpanda versions, are kept in Extra and written back
+// unchanged when the manifest is rewritten.
+type Manifest struct {
+	Version      int                        `json:"version"`
+	Namespace    string                     `json:"namespace"`
+	Topic        string                     `json:"topic"`
+	Partition    int                        `json:"partition"`
+	Revision     int                        `json:"revision"`
+	LastOffset   uint64                     `json:"last_offset"`
+	Segments     map[string]Segment         `json:"segments"`
+	Extra        map[string]json.RawMessage `json:"-"`
+	NeedsRewrite bool                       `json:"-"`
+}
+
+func (m *Manifest) UnmarshalJSON(data []byte) error {
+	type manifest Manifest
+	extra, err := unmarshalWithExtra(data, (*manifest)(m))
+	m.Extra = extra
+	return err
+}
+
+func (m Manifest) MarshalJSON() ([]byte, error) {
+	type manifest Manifest
+	return marshalWithExtra(manifest(m), m.Extra)
+}
+
+// unmarshalWithExtra decodes the JSON object data into v, a pointer to a
+// struct without custom decoding, and returns the members that no field of
+// v has a json tag for.
+func unmarshalWithExtra(data []byte, v interface{}) (map[string]json.RawMessage, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		delete(members, name)
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+	return members, nil
+}
+
+// marshalWithExtra encodes v, a struct without custom encoding, along with
+// the extra members.
+func marshalWithExtra(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	for name, value := range extra {
+		if _, ok := members[name]; !ok {
+			members[name] = value
+		}
+	}
+	return json.Marshal(members)
+}
+
+// SegmentKey identifies a segment across every partition in the bucket. Two
+// partitions of a topic can contain segments with the same name.
+type SegmentKey struct {
+	Namespace string
+	Topic     string
+	Partition int
+	Revision  int
+	Name      string
+}
+
+// Key returns the key of the named segment of the manifest's partition.
+func (m Manifest) Key(name string) SegmentKey {
+	return SegmentKey{
+		Namespace: m.Namespace,
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Revision:  m.Revision,
+		Name:      name,
+	}
+}
+
+// parseSegmentObjectKey parses the key of a remote segment object, stored as
+// <hash>/<namespace>/<topic>/<partition>_<revision>/<segment>.<term>.
+func parseSegmentObjectKey(objectKey string) (SegmentKey, bool) {
+	parts := strings.Split(objectKey, "/")
+	if len(parts) != 5 || parts[1] == "meta" {
+		return SegmentKey{}, false
+	}
+	var key SegmentKey
+	if _, err := fmt.Sscanf(parts[3], "%d_%d", &key.Partition, &key.Revision); err != nil {
+		return SegmentKey{}, false
+	}
+	key.Namespace = parts[1]
+	key.Topic = parts[2]
+	key.Name = parts[4]
+	if i := strings.LastIndex(key.Name, ".log"); i >= 0 {
+		key.Name = key.Name[:i+len(".log")]
+	}
+	return key, true
+}
+
+// ManifestObject is a partition manifest along with the key it was read
+// from. ETag identifies the version of the object that was read.
+type ManifestObject struct {
+	Key      string
+	ETag     string
+	Manifest Manifest
+}
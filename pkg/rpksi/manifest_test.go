@@ -0,0 +1,149 @@
+package rpksi
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// redpandaManifest is a manifest.json with the fields of newer Redpanda
+// versions that Manifest and Segment do not model.
+const redpandaManifest = `{
+  "version": 1,
+  "namespace": "kafka",
+  "topic": "events",
+  "partition": 0,
+  "revision": 7,
+  "last_offset": 29,
+  "insync_offset": 31,
+  "start_offset": 10,
+  "last_uploaded_compacted_offset": -1,
+  "archive_start_offset": -1,
+  "archive_start_offset_delta": 0,
+  "archive_clean_offset": 0,
+  "archive_size_bytes": 0,
+  "cloud_log_size_bytes": 2048,
+  "replaced": [],
+  "segments": {
+    "10-1-v1.log": {
+      "is_compacted": false,
+      "size_bytes": 1024,
+      "committed_offset": 19,
+      "base_offset": 10,
+      "base_timestamp": 10,
+      "max_timestamp": 19,
+      "delta_offset": 0,
+      "archiver_term": 1,
+      "segment_term": 1,
+      "delta_offset_end": 0,
+      "ntp_revision": 7,
+      "sname_format": 2
+    },
+    "20-1-v1.log": {
+      "is_compacted": false,
+      "size_bytes": 1024,
+      "committed_offset": 29,
+      "base_offset": 20,
+      "base_timestamp": 20,
+      "max_timestamp": 29,
+      "delta_offset": 0,
+      "archiver_term": 1,
+      "segment_term": 1,
+      "delta_offset_end": 0,
+      "ntp_revision": 7,
+      "sname_format": 2
+    }
+  }
+}`
+
+var unknownManifestFields = []string{
+	"insync_offset",
+	"start_offset",
+	"last_uploaded_compacted_offset",
+	"archive_start_offset",
+	"archive_start_offset_delta",
+	"archive_clean_offset",
+	"archive_size_bytes",
+	"cloud_log_size_bytes",
+	"replaced",
+}
+
+var unknownSegmentFields = []string{"segment_term", "delta_offset_end", "ntp_revision", "sname_format"}
+
+// jsonMembers decodes data as nested JSON objects and arrays so that two
+// encodings can be compared regardless of member order and spacing.
+func jsonMembers(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestManifestKeepsUnknownFields(t *testing.T) {
+	var m Manifest
+	if err := json.Unmarshal([]byte(redpandaManifest), &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range unknownManifestFields {
+		if _, ok := m.Extra[name]; !ok {
+			t.Errorf("manifest field %s not kept", name)
+		}
+	}
+	for _, name := range []string{"version", "topic", "segments"} {
+		if _, ok := m.Extra[name]; ok {
+			t.Errorf("known manifest field %s kept as extra", name)
+		}
+	}
+	segment := m.Segments["10-1-v1.log"]
+	for _, name := range unknownSegmentFields {
+		if _, ok := segment.Extra[name]; !ok {
+			t.Errorf("segment field %s not kept", name)
+		}
+	}
+	if segment.CommittedOffset != 19 || segment.ArchiverTerm != 1 {
+		t.Errorf("segment decoded as %+v", segment)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jsonMembers(t, data), jsonMembers(t, []byte(redpandaManifest)); !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest encoded as %s", data)
+	}
+}
+
+func TestManifestRewriteKeepsUnknownFields(t *testing.T) {
+	c, store, _ := newDeletionTest(t)
+	var m Manifest
+	if err := json.Unmarshal([]byte(redpandaManifest), &m); err != nil {
+		t.Fatal(err)
+	}
+	putObject(t, store, manifestKey(m), redpandaManifest)
+	for name, segment := range m.Segments {
+		putObject(t, store, segmentObjectKey(m, name, segment.ArchiverTerm), "segment")
+	}
+
+	plan, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", Partitions: []int{0}, OlderThan: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ApplyDeletion(context.Background(), plan); err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.readObject(context.Background(), manifestKey(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want map[string]interface{}
+	if err := json.Unmarshal([]byte(redpandaManifest), &want); err != nil {
+		t.Fatal(err)
+	}
+	delete(want["segments"].(map[string]interface{}), "10-1-v1.log")
+	if got := jsonMembers(t, data); !reflect.DeepEqual(got, interface{}(want)) {
+		t.Errorf("manifest rewritten as %s", data)
+	}
+}
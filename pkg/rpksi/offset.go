@@ -0,0 +1,93 @@
+package rpksi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"rpksi/pkg/segment"
+)
+
+// ErrOffsetNotFound is returned when no archived record is at or after the
+// requested time.
+var ErrOffsetNotFound = errors.New("no archived record at or after the time")
+
+// OffsetLookup is the first archived record of a partition at or after a
+// time.
+type OffsetLookup struct {
+	Offset    int64      `json:"offset" yaml:"offset"`
+	Timestamp int64      `json:"timestamp" yaml:"timestamp"`
+	Segment   RowSegment `json:"segment" yaml:"segment"`
+	// Requests and BytesRead count the ranged reads made by the lookup.
+	Requests  int   `json:"requests" yaml:"requests"`
+	BytesRead int64 `json:"bytes_read" yaml:"bytes_read"`
+}
+
+// OffsetForTime returns the offset of the first record of the partition with
+// a timestamp at or after ts (unix milliseconds), like a Kafka ListOffsets
+// request would. Segments are narrowed down with the timestamps recorded in
+// the manifest, then their batch headers are walked with ranged reads, so
+// only the batch holding the record is downloaded in full.
+func (c *Client) OffsetForTime(ctx context.Context, topic string, partition int, ts int64) (OffsetLookup, error) {
+	var lookup OffsetLookup
+	rows, err := c.ListSegments(ctx, Filter{Topic: topic, Partitions: []int{partition}})
+	if err != nil {
+		return lookup, err
+	}
+	for _, row := range rows {
+		if ts > 0 && row.SegmentNewOffsetDate < uint64(ts) {
+			continue
+		}
+		if len(row.ObjectPath) == 0 {
+			return lookup, fmt.Errorf("%w: no object for %s/%d/%s", ErrSegmentNotFound, topic, partition, row.SegmentName)
+		}
+		err := c.segmentOffsetForTime(ctx, row, ts, &lookup)
+		if errors.Is(err, ErrOffsetNotFound) {
+			// the manifest timestamps are a hint, keep looking
+			continue
+		}
+		if err != nil {
+			return lookup, fmt.Errorf("%s: %w", row.ObjectPath, err)
+		}
+		return lookup, nil
+	}
+	return lookup, ErrOffsetNotFound
+}
+
+func (c *Client) segmentOffsetForTime(ctx context.Context, row RowSegment, ts int64, lookup *OffsetLookup) error {
+	info, err := c.Store.StatObject(ctx, row.ObjectPath)
+	if err != nil {
+		return err
+	}
+	walker := segment.NewHeaderWalker(ctx, c.Store, row.ObjectPath, info.Size)
+	defer func() {
+		lookup.Requests += walker.Requests
+		lookup.BytesRead += walker.BytesRead
+	}()
+	for {
+		batch, err := walker.Next()
+		if err == io.EOF {
+			return ErrOffsetNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if batch.Type != segment.BatchTypeRaftData || batch.Attributes.Control() || batch.MaxTimestamp < ts {
+			continue
+		}
+		batch, err = walker.ReadBatch(batch)
+		if err != nil {
+			return err
+		}
+		records, err := batch.Records()
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if record.Timestamp >= ts {
+				lookup.Offset, lookup.Timestamp, lookup.Segment = record.Offset, record.Timestamp, row
+				return nil
+			}
+		}
+	}
+}
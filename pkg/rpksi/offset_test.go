@@ -0,0 +1,53 @@
+package rpksi
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestOffsetForTime(t *testing.T) {
+	c, store := newTestClient()
+	m := testManifest("events", 0, 0)
+	// offsets 0 to 9 at 1000 to 1009 in two batches, then 10 to 19 at 2000
+	// to 2009
+	first := addSegment(t, store, &m, testBatchAt(0, 5, 1000), testBatchAt(5, 5, 1005))
+	second := addSegment(t, store, &m, testBatchAt(10, 10, 2000))
+	putManifest(t, store, m)
+
+	for _, tt := range []struct {
+		name      string
+		ts        int64
+		offset    int64
+		timestamp int64
+		segment   string
+	}{
+		{"before the first batch", 500, 0, 1000, first},
+		{"first record", 1000, 0, 1000, first},
+		{"inside a segment", 1007, 7, 1007, first},
+		{"between segments", 1500, 10, 2000, second},
+		{"last record", 2009, 19, 2009, second},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup, err := c.OffsetForTime(context.Background(), "events", 0, tt.ts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lookup.Offset != tt.offset || lookup.Timestamp != tt.timestamp || lookup.Segment.SegmentName != tt.segment {
+				t.Errorf("found offset %d at %d in %s, want %d at %d in %s",
+					lookup.Offset, lookup.Timestamp, lookup.Segment.SegmentName, tt.offset, tt.timestamp, tt.segment)
+			}
+			// segments ending before the time are skipped with the manifest
+			if lookup.Requests != 1 {
+				t.Errorf("%d requests, want 1", lookup.Requests)
+			}
+		})
+	}
+
+	if _, err := c.OffsetForTime(context.Background(), "events", 0, 2010); !errors.Is(err, ErrOffsetNotFound) {
+		t.Errorf("after the last batch: %v, want %v", err, ErrOffsetNotFound)
+	}
+	if _, err := c.OffsetForTime(context.Background(), "events", 1, 0); !errors.Is(err, ErrOffsetNotFound) {
+		t.Errorf("partition without segments: %v, want %v", err, ErrOffsetNotFound)
+	}
+}
@@ -0,0 +1,81 @@
+package rpksi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"rpksi/pkg/storage"
+	"strings"
+)
+
+// planVersion is the format version of plan files.
+const planVersion = 1
+
+// ErrPlanStale is returned when the bucket no longer matches a deletion plan.
+var ErrPlanStale = errors.New("bucket state has changed since the plan was made")
+
+// WritePlan encodes plan as indented json.
+func WritePlan(w io.Writer, plan *DeletionPlan) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+// ReadPlanFile reads a plan written by WritePlan.
+func ReadPlanFile(path string) (*DeletionPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan DeletionPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("%s: unsupported plan version %d", path, plan.Version)
+	}
+	for i := range plan.Segments {
+		plan.Segments[i].Delete = true
+	}
+	for i := range plan.Manifests {
+		plan.Manifests[i].Manifest.NeedsRewrite = true
+	}
+	return &plan, nil
+}
+
+// CheckPlan verifies that every manifest in plan still has the ETag it was
+// planned against and that every segment object still exists. The returned
+// error wraps ErrPlanStale and lists each difference.
+func (c *Client) CheckPlan(ctx context.Context, plan *DeletionPlan) error {
+	var changes []string
+	for _, rewrite := range plan.Manifests {
+		info, err := c.Store.StatObject(ctx, rewrite.Key)
+		if errors.Is(err, storage.ErrNotFound) {
+			changes = append(changes, "manifest "+rewrite.Key+" no longer exists")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.ETag != rewrite.ETag {
+			changes = append(changes, fmt.Sprintf("manifest %s changed (etag %s, planned %s)", rewrite.Key, info.ETag, rewrite.ETag))
+		}
+	}
+	for _, segment := range plan.Segments {
+		_, err := c.Store.StatObject(ctx, segment.ObjectPath)
+		if errors.Is(err, storage.ErrNotFound) {
+			changes = append(changes, "segment "+segment.ObjectPath+" no longer exists")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("%w:\n  %s", ErrPlanStale, strings.Join(changes, "\n  "))
+	}
+	return nil
+}
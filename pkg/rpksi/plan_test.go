@@ -0,0 +1,78 @@
+package rpksi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckPlan(t *testing.T) {
+	c, store := newTestClient()
+	m := testManifest("events", 0, 3)
+	putPartition(t, store, m)
+	plan, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckPlan(context.Background(), plan); err != nil {
+		t.Fatalf("fresh plan: %v", err)
+	}
+
+	if err := store.RemoveObject(context.Background(), segmentObjectKey(m, "0-1-v1.log", 1)); err != nil {
+		t.Fatal(err)
+	}
+	m.LastOffset++
+	putManifest(t, store, m)
+	err = c.CheckPlan(context.Background(), plan)
+	if !errors.Is(err, ErrPlanStale) {
+		t.Fatalf("stale plan: %v, want %v", err, ErrPlanStale)
+	}
+	for _, change := range []string{"manifest " + manifestKey(m) + " changed", "segment " + segmentObjectKey(m, "0-1-v1.log", 1) + " no longer exists"} {
+		if !strings.Contains(err.Error(), change) {
+			t.Errorf("%q does not mention %q", err, change)
+		}
+	}
+
+	if err := store.RemoveObject(context.Background(), manifestKey(m)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckPlan(context.Background(), plan); err == nil || !strings.Contains(err.Error(), "no longer exists") {
+		t.Errorf("plan of a deleted manifest: %v", err)
+	}
+}
+
+func TestPlanFile(t *testing.T) {
+	c, store := newTestClient()
+	putPartition(t, store, testManifest("events", 0, 3))
+	plan, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WritePlan(&buf, plan); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadPlanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.Created.Equal(plan.Created) {
+		t.Errorf("created %v, want %v", read.Created, plan.Created)
+	}
+	read.Created = plan.Created
+	if !reflect.DeepEqual(read, plan) {
+		t.Errorf("plan read back as %+v, want %+v", read, plan)
+	}
+	if err := c.CheckPlan(context.Background(), read); err != nil {
+		t.Errorf("plan read back: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package rpksi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"rpksi/pkg/segment"
+	"sort"
+)
+
+// MatchBatch reports whether a batch may hold records matching the offset
+// and time criteria of the filter, so whole batches can be skipped without
+// decoding their records.
+func (f Filter) MatchBatch(h segment.Header) bool {
+	if f.Offsets != nil && (uint64(h.LastOffset()) < f.Offsets.From || uint64(h.BaseOffset) > f.Offsets.To) {
+		return false
+	}
+	if f.Between != nil && (h.MaxTimestamp < f.Between.From || h.FirstTimestamp > f.Between.To) {
+		return false
+	}
+	return true
+}
+
+// MatchRecord reports whether a record matches the offset and time criteria
+// of the filter.
+func (f Filter) MatchRecord(r segment.Record) bool {
+	if f.Offsets != nil && (uint64(r.Offset) < f.Offsets.From || uint64(r.Offset) > f.Offsets.To) {
+		return false
+	}
+	if f.Between != nil && (r.Timestamp < f.Between.From || r.Timestamp > f.Between.To) {
+		return false
+	}
+	return true
+}
+
+// ReadRecords calls fn, in partition and offset order, for each record
+// produced by clients that matches filter. Segments are selected through the
+// manifests with Match and streamed from the bucket, then records are
+// narrowed down with MatchRecord.
+func (c *Client) ReadRecords(ctx context.Context, filter Filter, fn func(RowSegment, segment.Record) error) error {
+	rows, err := c.ListSegments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return c.readRecords(ctx, rows, filter, nil, fn)
+}
+
+// readRecords reads the records of rows like ReadRecords, skipping the
+// batches of the aborted transactions of each partition.
+func (c *Client) readRecords(ctx context.Context, rows []RowSegment, filter Filter, aborted map[int][]abortedRange, fn func(RowSegment, segment.Record) error) error {
+	for _, row := range rows {
+		if len(row.ObjectPath) == 0 {
+			return fmt.Errorf("%w: no object for %s/%d/%s", ErrSegmentNotFound, row.TopicName, row.Partition, row.SegmentName)
+		}
+		if err := c.readSegmentRecords(ctx, row, filter, aborted[row.Partition], fn); err != nil {
+			return fmt.Errorf("%s: %w", row.ObjectPath, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) readSegmentRecords(ctx context.Context, row RowSegment, filter Filter, aborted []abortedRange, fn func(RowSegment, segment.Record) error) error {
+	object, err := c.Store.GetObject(ctx, row.ObjectPath)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	reader := segment.NewReader(object)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if batch.Type != segment.BatchTypeRaftData || batch.Attributes.Control() || !filter.MatchBatch(batch.Header) || isAborted(aborted, batch.Header) {
+			continue
+		}
+		records, err := batch.Records()
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if !filter.MatchRecord(record) {
+				continue
+			}
+			if err := fn(row, record); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// abortedRange holds the offsets of a transaction its producer aborted.
+// Only the transactional batches of the producer within the range belong to
+// the transaction.
+type abortedRange struct {
+	ProducerID int64
+	First      int64
+	Last       int64
+}
+
+func isAborted(aborted []abortedRange, h segment.Header) bool {
+	if !h.Attributes.Transactional() {
+		return false
+	}
+	for _, r := range aborted {
+		if h.ProducerID == r.ProducerID && h.BaseOffset >= r.First && h.BaseOffset <= r.Last {
+			return true
+		}
+	}
+	return false
+}
+
+// abortedTransactions walks the batch headers of rows, sorted by partition
+// and offset, and returns the aborted transactions of each partition. The
+// transactional batches of a producer between two of its markers belong to
+// the transaction the second marker commits or aborts. Transactions without
+// a marker in rows are logged as warnings and not reported.
+func (c *Client) abortedTransactions(ctx context.Context, rows []RowSegment) (map[int][]abortedRange, error) {
+	aborted := make(map[int][]abortedRange)
+	// the offset following the last marker of each producer, and the first
+	// offset of its open transaction, -1 when it has none
+	type producer struct{ start, open int64 }
+	var producers map[int64]*producer
+	warnOpen := func(row RowSegment) {
+		ids := make([]int64, 0, len(producers))
+		for id := range producers {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			if open := producers[id].open; open >= 0 {
+				c.logf("warning: %s/%d: the transaction of producer %d from offset %d has no marker in the segments read, its records are kept", row.TopicName, row.Partition, id, open)
+			}
+		}
+	}
+
+	for i, row := range rows {
+		if i == 0 || row.Partition != rows[i-1].Partition {
+			if i > 0 {
+				warnOpen(rows[i-1])
+			}
+			producers = make(map[int64]*producer)
+		}
+		if len(row.ObjectPath) == 0 {
+			return nil, fmt.Errorf("%w: no object for %s/%d/%s", ErrSegmentNotFound, row.TopicName, row.Partition, row.SegmentName)
+		}
+		info, err := c.Store.StatObject(ctx, row.ObjectPath)
+		if err != nil {
+			return nil, err
+		}
+		walker := segment.NewHeaderWalker(ctx, c.Store, row.ObjectPath, info.Size)
+		for {
+			batch, err := walker.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", row.ObjectPath, err)
+			}
+			if batch.Type != segment.BatchTypeRaftData || !batch.Attributes.Transactional() {
+				continue
+			}
+			p, ok := producers[batch.ProducerID]
+			if !ok {
+				p = &producer{start: -1, open: -1}
+				producers[batch.ProducerID] = p
+			}
+			if !batch.Attributes.Control() {
+				if p.open < 0 {
+					p.open = batch.BaseOffset
+				}
+				continue
+			}
+			if batch, err = walker.ReadBatch(batch); err != nil {
+				return nil, fmt.Errorf("%s: %w", row.ObjectPath, err)
+			}
+			marker, err := batch.ControlType()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", row.ObjectPath, err)
+			}
+			if marker == segment.ControlAbort {
+				// the transaction may have started before the first segment
+				aborted[row.Partition] = append(aborted[row.Partition], abortedRange{ProducerID: batch.ProducerID, First: p.start, Last: batch.BaseOffset})
+			}
+			p.start, p.open = batch.BaseOffset+1, -1
+		}
+	}
+	if len(rows) > 0 {
+		warnOpen(rows[len(rows)-1])
+	}
+	return aborted, nil
+}
@@ -0,0 +1,169 @@
+package rpksi
+
+import (
+	"context"
+	"errors"
+	"rpksi/pkg/segment"
+	"time"
+)
+
+// RecordProducer produces records to a partition, kafka.Client implements
+// it.
+type RecordProducer interface {
+	Produce(ctx context.Context, topic string, partition int32, records []segment.Record) (int64, error)
+}
+
+// Default batch limits of a replay.
+const (
+	DefaultReplayBatchRecords = 500
+	DefaultReplayBatchBytes   = 512 * 1024
+)
+
+// ReplayOptions configures a replay. Zero rates disable rate limiting.
+type ReplayOptions struct {
+	TargetTopic string
+	// TargetPartition is the partition records are produced to, -1 keeps
+	// the partition of each record.
+	TargetPartition int
+	// BatchRecords and BatchBytes bound the records produced at a time.
+	BatchRecords     int
+	BatchBytes       int
+	RecordsPerSecond float64
+	BytesPerSecond   float64
+}
+
+// ReplayStats summarizes a replay. Bytes counts keys, values and headers,
+// Aborted the aborted transactions found in the segments read.
+type ReplayStats struct {
+	Records int
+	Batches int
+	Bytes   int64
+	Aborted int
+	Elapsed time.Duration
+}
+
+// Replay produces the archived records matching filter to the target topic,
+// keeping their keys, headers and timestamps, in partition and offset order.
+// Like a read_committed consumer, it skips the records of aborted
+// transactions, found from the transaction markers of the segments read.
+func (c *Client) Replay(ctx context.Context, filter Filter, producer RecordProducer, opts ReplayOptions) (ReplayStats, error) {
+	if len(opts.TargetTopic) == 0 {
+		return ReplayStats{}, errors.New("replay: target topic required")
+	}
+	if opts.BatchRecords <= 0 {
+		opts.BatchRecords = DefaultReplayBatchRecords
+	}
+	if opts.BatchBytes <= 0 {
+		opts.BatchBytes = DefaultReplayBatchBytes
+	}
+
+	rows, err := c.ListSegments(ctx, filter)
+	if err != nil {
+		return ReplayStats{}, err
+	}
+	aborted, err := c.abortedTransactions(ctx, rows)
+	if err != nil {
+		return ReplayStats{}, err
+	}
+
+	var stats ReplayStats
+	for _, ranges := range aborted {
+		stats.Aborted += len(ranges)
+	}
+	start := time.Now()
+	limit := throttle{start: start, recordsPerSecond: opts.RecordsPerSecond, bytesPerSecond: opts.BytesPerSecond}
+	var batch []segment.Record
+	var batchBytes int
+	var partition int32
+	var object string
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := limit.wait(ctx, len(batch), batchBytes); err != nil {
+			return err
+		}
+		if _, err := producer.Produce(ctx, opts.TargetTopic, partition, batch); err != nil {
+			return err
+		}
+		stats.Records += len(batch)
+		stats.Batches++
+		stats.Bytes += int64(batchBytes)
+		batch, batchBytes = nil, 0
+		return nil
+	}
+
+	err = c.readRecords(ctx, rows, filter, aborted, func(row RowSegment, record segment.Record) error {
+		target := int32(row.Partition)
+		if opts.TargetPartition >= 0 {
+			target = int32(opts.TargetPartition)
+		}
+		size := recordSize(record)
+		if target != partition || len(batch) >= opts.BatchRecords || (len(batch) > 0 && batchBytes+size > opts.BatchBytes) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if row.ObjectPath != object {
+			object = row.ObjectPath
+			c.logf("  replaying %s", object)
+		}
+		partition = target
+		batch = append(batch, record)
+		batchBytes += size
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	stats.Elapsed = time.Since(start)
+	return stats, err
+}
+
+func recordSize(r segment.Record) int {
+	size := len(r.Key) + len(r.Value)
+	for _, header := range r.Headers {
+		size += len(header.Key) + len(header.Value)
+	}
+	return size
+}
+
+// throttle paces a replay so it stays below its record and byte rates.
+type throttle struct {
+	start            time.Time
+	recordsPerSecond float64
+	bytesPerSecond   float64
+	records          float64
+	bytes            float64
+}
+
+// wait sleeps until the records sent so far are within the rates, then
+// accounts for the next records. The first batch is sent right away.
+func (t *throttle) wait(ctx context.Context, records, bytes int) error {
+	defer func() {
+		t.records += float64(records)
+		t.bytes += float64(bytes)
+	}()
+	var due time.Duration
+	if t.recordsPerSecond > 0 {
+		due = time.Duration(t.records / t.recordsPerSecond * float64(time.Second))
+	}
+	if t.bytesPerSecond > 0 {
+		if d := time.Duration(t.bytes / t.bytesPerSecond * float64(time.Second)); d > due {
+			due = d
+		}
+	}
+	delay := time.Until(t.start.Add(due))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
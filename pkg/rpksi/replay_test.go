@@ -0,0 +1,98 @@
+package rpksi
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"rpksi/pkg/segment"
+	"strings"
+	"testing"
+)
+
+// recordingProducer records the offsets of the records produced to each
+// partition.
+type recordingProducer struct {
+	offsets map[int32][]int64
+}
+
+func (p *recordingProducer) Produce(ctx context.Context, topic string, partition int32, records []segment.Record) (int64, error) {
+	if p.offsets == nil {
+		p.offsets = make(map[int32][]int64)
+	}
+	for _, record := range records {
+		p.offsets[partition] = append(p.offsets[partition], record.Offset)
+	}
+	return 0, nil
+}
+
+// txBatch encodes count records from offset base in a transaction of
+// producer.
+func txBatch(base int64, count int, producer int64) []byte {
+	return encodeTestBatch(segment.Header{
+		BaseOffset: base,
+		Type:       segment.BatchTypeRaftData,
+		Attributes: 0x10,
+		ProducerID: producer,
+	}, testRecords(base, count, base))
+}
+
+// markerBatch encodes a marker committing or aborting the transaction of
+// producer.
+func markerBatch(offset int64, producer int64, marker segment.ControlType) []byte {
+	return encodeTestBatch(segment.Header{
+		BaseOffset: offset,
+		Type:       segment.BatchTypeRaftData,
+		Attributes: 0x30,
+		ProducerID: producer,
+	}, []segment.Record{{
+		Offset:    offset,
+		Timestamp: offset,
+		Key:       []byte{0, 0, 0, byte(marker)},
+		Value:     []byte{0, 0, 0, 0, 0, 0},
+	}})
+}
+
+func TestReplaySkipsAbortedTransactions(t *testing.T) {
+	c, store := newTestClient()
+	var log bytes.Buffer
+	c.Log = &log
+	m := testManifest("events", 0, 0)
+	addSegment(t, store, &m,
+		testBatch(0, 2),
+		txBatch(2, 2, 7),
+		txBatch(4, 2, 8),
+		markerBatch(6, 7, segment.ControlAbort))
+	addSegment(t, store, &m,
+		txBatch(7, 2, 7),
+		markerBatch(9, 8, segment.ControlCommit),
+		markerBatch(10, 7, segment.ControlCommit),
+		testBatch(11, 2),
+		txBatch(13, 1, 9))
+	putManifest(t, store, m)
+	// the transaction aborted in the second segment started in the first
+	other := testManifest("events", 1, 0)
+	addSegment(t, store, &other, txBatch(0, 2, 7))
+	addSegment(t, store, &other, txBatch(2, 2, 7), markerBatch(4, 7, segment.ControlAbort), testBatch(5, 2))
+	putManifest(t, store, other)
+
+	producer := &recordingProducer{}
+	filter := Filter{Topic: "events", Offsets: &OffsetRange{From: 2, To: 100}}
+	stats, err := c.Replay(context.Background(), filter, producer, ReplayOptions{TargetTopic: "scratch", TargetPartition: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int32][]int64{
+		0: {4, 5, 7, 8, 11, 12, 13},
+		1: {5, 6},
+	}
+	if !reflect.DeepEqual(producer.offsets, want) {
+		t.Errorf("produced %v, want %v", producer.offsets, want)
+	}
+	if stats.Records != 9 || stats.Aborted != 2 {
+		t.Errorf("produced %d records and skipped %d transactions, want 9 and 2", stats.Records, stats.Aborted)
+	}
+	warning := "warning: events/0: the transaction of producer 9 from offset 13 has no marker in the segments read, its records are kept"
+	if !strings.Contains(log.String(), warning) {
+		t.Errorf("log %q does not warn about the open transaction", log.String())
+	}
+}
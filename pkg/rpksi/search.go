@@ -0,0 +1,140 @@
+package rpksi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"rpksi/pkg/segment"
+)
+
+// RecordMatcher selects records by key, headers and value. All of the set
+// criteria must match, the zero value matches every record.
+type RecordMatcher struct {
+	// Key matches records with exactly this key, nil disables the check.
+	Key []byte
+	// KeyRegexp matches records whose key matches the expression.
+	KeyRegexp *regexp.Regexp
+	// Headers matches records having a header with each key and value.
+	Headers map[string]string
+	// ValueContains matches records whose value contains these bytes.
+	ValueContains []byte
+	// Decoder, when set, decodes keys and values before they are matched.
+	// Hits then hold the decoded records. Keys and values that fail to
+	// decode are logged as warnings and matched as they are.
+	Decoder RecordDecoder
+}
+
+// RecordDecoder rewrites the keys and values of records, for instance
+// schemaregistry.Decoder.
+type RecordDecoder interface {
+	DecodeRecord(ctx context.Context, r segment.Record) (segment.Record, error)
+}
+
+// Match reports whether the record matches.
+func (m RecordMatcher) Match(r segment.Record) bool {
+	if m.Key != nil && (r.Key == nil || !bytes.Equal(m.Key, r.Key)) {
+		return false
+	}
+	if m.KeyRegexp != nil && (r.Key == nil || !m.KeyRegexp.Match(r.Key)) {
+		return false
+	}
+	for key, value := range m.Headers {
+		found := false
+		for _, header := range r.Headers {
+			if header.Key == key && string(header.Value) == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.ValueContains != nil && !bytes.Contains(r.Value, m.ValueContains) {
+		return false
+	}
+	return true
+}
+
+// RecordHit is a record found by SearchRecords.
+type RecordHit struct {
+	Segment RowSegment
+	Record  segment.Record
+}
+
+// SearchRecords scans the segments matching filter, up to parallelism at a
+// time, and calls fn with the records matching both filter and matcher. Hits
+// are passed to fn in partition and offset order, from a single goroutine.
+func (c *Client) SearchRecords(ctx context.Context, filter Filter, matcher RecordMatcher, parallelism int, fn func(RecordHit) error) error {
+	rows, err := c.ListSegments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		hits []RecordHit
+		err  error
+	}
+	results := make([]chan result, len(rows))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	// a slot is taken when a segment scan starts and released once its hits
+	// are reported, so at most parallelism segments are scanned or buffered
+	slots := make(chan struct{}, parallelism)
+	go func() {
+		for i, row := range rows {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				for ; i < len(rows); i++ {
+					results[i] <- result{err: ctx.Err()}
+				}
+				return
+			}
+			go func(i int, row RowSegment) {
+				var r result
+				if len(row.ObjectPath) == 0 {
+					r.err = fmt.Errorf("%w: no object for %s/%d/%s", ErrSegmentNotFound, row.TopicName, row.Partition, row.SegmentName)
+				} else {
+					r.err = c.readSegmentRecords(ctx, row, filter, nil, func(row RowSegment, record segment.Record) error {
+						if matcher.Decoder != nil {
+							var err error
+							if record, err = matcher.Decoder.DecodeRecord(ctx, record); err != nil {
+								c.logf("warning: %s: %v, matching the raw bytes", row.ObjectPath, err)
+							}
+						}
+						if matcher.Match(record) {
+							r.hits = append(r.hits, RecordHit{Segment: row, Record: record})
+						}
+						return nil
+					})
+					if r.err != nil {
+						r.err = fmt.Errorf("%s: %w", row.ObjectPath, r.err)
+					}
+				}
+				results[i] <- r
+			}(i, row)
+		}
+	}()
+
+	for i := range rows {
+		r := <-results[i]
+		if r.err != nil {
+			return r.err
+		}
+		<-slots
+		for _, hit := range r.hits {
+			if err := fn(hit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package rpksi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"rpksi/pkg/segment"
+	"strings"
+	"testing"
+)
+
+func TestSearchRecordsOrder(t *testing.T) {
+	c, store := newTestClient()
+	// several segments per partition, of different sizes so the scans end
+	// out of order
+	first := testManifest("events", 0, 0)
+	addSegment(t, store, &first, testBatch(0, 50), testBatch(50, 50))
+	addSegment(t, store, &first, testBatch(100, 5))
+	addSegment(t, store, &first, testBatch(105, 20), testBatch(125, 5))
+	putManifest(t, store, first)
+	second := testManifest("events", 1, 0)
+	addSegment(t, store, &second, testBatch(0, 3))
+	addSegment(t, store, &second, testBatch(3, 40))
+	putManifest(t, store, second)
+
+	// the offsets holding a 5, partition 0 first
+	var want []string
+	for partition, end := range []int64{130, 43} {
+		for offset := int64(0); offset < end; offset++ {
+			if strings.Contains(fmt.Sprint(offset), "5") {
+				want = append(want, fmt.Sprintf("%d/%d", partition, offset))
+			}
+		}
+	}
+
+	matcher := RecordMatcher{ValueContains: []byte("5")}
+	for _, parallelism := range []int{1, 3, 10} {
+		var got []string
+		err := c.SearchRecords(context.Background(), Filter{Topic: "events"}, matcher, parallelism, func(hit RecordHit) error {
+			got = append(got, fmt.Sprintf("%d/%d", hit.Segment.Partition, hit.Record.Offset))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parallelism %d: found %v, want %v", parallelism, got, want)
+		}
+	}
+}
+
+// failingDecoder prefixes values with decoded- and fails on the key fail.
+type failingDecoder struct{}
+
+func (failingDecoder) DecodeRecord(ctx context.Context, r segment.Record) (segment.Record, error) {
+	if string(r.Key) == "key-3" {
+		return r, errors.New("unknown schema")
+	}
+	r.Value = append([]byte("decoded-"), r.Value...)
+	return r, nil
+}
+
+func TestSearchRecordsDecodeError(t *testing.T) {
+	c, store := newTestClient()
+	var log bytes.Buffer
+	c.Log = &log
+	m := testManifest("events", 0, 0)
+	name := addSegment(t, store, &m, testBatch(0, 5))
+	putManifest(t, store, m)
+
+	matcher := RecordMatcher{ValueContains: []byte("value-"), Decoder: failingDecoder{}}
+	var got []string
+	err := c.SearchRecords(context.Background(), Filter{Topic: "events"}, matcher, 1, func(hit RecordHit) error {
+		got = append(got, string(hit.Record.Value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the record failing to decode is matched as it is
+	want := []string{"decoded-value-0", "decoded-value-1", "decoded-value-2", "value-3", "decoded-value-4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("found %v, want %v", got, want)
+	}
+	warning := fmt.Sprintf("warning: %s: unknown schema, matching the raw bytes\n", segmentObjectKey(m, name, 1))
+	if log.String() != warning {
+		t.Errorf("logged %q, want %q", log.String(), warning)
+	}
+}
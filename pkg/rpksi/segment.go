@@ -0,0 +1,30 @@
+package rpksi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrSegmentNotFound is returned when a segment is not in the manifest of its
+// partition, or its object is missing from the bucket.
+var ErrSegmentNotFound = errors.New("segment not found")
+
+// FindSegment returns the segment of a partition by name, as shown by
+// ListSegments, along with the key of its object.
+func (c *Client) FindSegment(ctx context.Context, topic string, partition int, name string) (RowSegment, error) {
+	rows, err := c.ListSegments(ctx, Filter{Topic: topic, Partitions: []int{partition}})
+	if err != nil {
+		return RowSegment{}, err
+	}
+	for _, row := range rows {
+		if row.SegmentName != name {
+			continue
+		}
+		if len(row.ObjectPath) == 0 {
+			return row, fmt.Errorf("%w: no object for %s/%d/%s", ErrSegmentNotFound, topic, partition, name)
+		}
+		return row, nil
+	}
+	return RowSegment{}, fmt.Errorf("%w: %s/%d/%s", ErrSegmentNotFound, topic, partition, name)
+}
@@ -0,0 +1,529 @@
+package rpksi
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Binary partition manifests (manifest.bin) use the serde encoding of
+// Redpanda: little endian integers, strings and vectors prefixed with a
+// uint32 length, and structs wrapped in versioned envelopes. An envelope
+// starts with its version, the oldest version able to read it and the size
+// of the fields that follow. Fields are only ever appended, so an envelope
+// may end before the last field known here, or carry fields added after it.
+//
+// The manifest envelope holds:
+//
+//	ntp                             envelope {namespace, envelope {topic, int32 partition}}
+//	revision                        int64
+//	segments                        map of int64 base offset to segment envelope
+//	replaced                        vector of segment envelopes
+//	last_offset                     int64
+//	start_offset                    int64
+//	last_uploaded_compacted_offset  int64
+//	insync_offset                   int64
+//	cloud_log_size_bytes            uint64
+//	archive_start_offset            int64
+//	archive_start_offset_delta      int64
+//	archive_clean_offset            int64
+//	archive_size_bytes              uint64
+//
+// and a segment envelope:
+//
+//	is_compacted        bool
+//	size_bytes          uint64
+//	base_offset         int64
+//	committed_offset    int64
+//	base_timestamp      int64
+//	max_timestamp       int64
+//	delta_offset        int64
+//	ntp_revision        int64
+//	archiver_term       int64
+//	segment_term        int64
+//	delta_offset_end    int64
+//	sname_format        int16
+//	metadata_size_hint  uint64
+//
+// Fields without a Manifest or Segment field are kept in Extra under their
+// JSON manifest names. The envelope versions, those of the ntp envelopes
+// included, and any unknown trailing bytes are kept in Extra under "serde".
+//
+// This layout has not been checked against manifests written by Redpanda,
+// and newer versions store segments in the columns of a segment_meta_cstore
+// rather than as a map of envelopes, which is not decoded here. Binary
+// manifests are therefore only read, see ErrBinaryManifestReadOnly, and
+// TestBinaryManifestGolden fails until manifests captured from a cluster are
+// placed in testdata. Envelopes carrying bytes
+// after the fields known here are read, but a manifest holding any is never
+// written back, as rpksi cannot tell whether those bytes still agree with the
+// segments it removed. The map key of each segment must match its base
+// offset, so a manifest in another layout fails to decode rather than being
+// misread.
+
+var errSerdeShort = errors.New("serde: unexpected end of data")
+
+// ErrBinaryManifestReadOnly is returned when writing a manifest.bin, which
+// rpksi does not do until its decoding is proven on captured manifests.
+var ErrBinaryManifestReadOnly = errors.New("serde: writing binary manifests is not supported, the binary layout is not verified")
+
+// ErrSerdeUnparsed is returned when encoding a binary manifest with envelopes
+// that carried fields unknown to rpksi.
+var ErrSerdeUnparsed = errors.New("serde: the manifest has fields rpksi does not know, refusing to write it")
+
+// serdeExtraKey is the Extra member holding the envelope of a manifest or
+// segment decoded from the binary format.
+const serdeExtraKey = "serde"
+
+// serdeEnvelope is the version of an envelope and its fields unknown to
+// rpksi. The envelope of a manifest also holds those of its ntp and of the
+// topic partition within.
+type serdeEnvelope struct {
+	Version       uint8          `json:"version"`
+	CompatVersion uint8          `json:"compat_version"`
+	Tail          []byte         `json:"tail,omitempty"`
+	NTP           *serdeEnvelope `json:"ntp,omitempty"`
+	TP            *serdeEnvelope `json:"tp,omitempty"`
+	// Unparsed is set on the envelope of a manifest when the envelope of
+	// any of its segments held unknown fields, removed segments included.
+	Unparsed bool `json:"unparsed,omitempty"`
+}
+
+// parsed reports whether the envelope and the ones nested in it held no
+// unknown fields.
+func (env serdeEnvelope) parsed() bool {
+	return len(env.Tail) == 0 && !env.Unparsed && (env.NTP == nil || env.NTP.parsed()) && (env.TP == nil || env.TP.parsed())
+}
+
+// serdeField is a trailing integer field that older envelopes may lack.
+type serdeField struct {
+	name string
+	size int
+}
+
+var manifestSerdeFields = []serdeField{
+	{"start_offset", 8},
+	{"last_uploaded_compacted_offset", 8},
+	{"insync_offset", 8},
+	{"cloud_log_size_bytes", 8},
+	{"archive_start_offset", 8},
+	{"archive_start_offset_delta", 8},
+	{"archive_clean_offset", 8},
+	{"archive_size_bytes", 8},
+}
+
+var segmentSerdeFields = []serdeField{
+	{"segment_term", 8},
+	{"delta_offset_end", 8},
+	{"sname_format", 2},
+	{"metadata_size_hint", 8},
+}
+
+// unsignedSerdeFields are read as unsigned integers.
+var unsignedSerdeFields = map[string]bool{
+	"cloud_log_size_bytes": true,
+	"archive_size_bytes":   true,
+	"metadata_size_hint":   true,
+}
+
+// serdeReader reads serde fields. The first error is kept and later reads
+// return zero values.
+type serdeReader struct {
+	buf []byte
+	err error
+}
+
+func (r *serdeReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf) {
+		r.err = errSerdeShort
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *serdeReader) uint8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *serdeReader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *serdeReader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *serdeReader) uint64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *serdeReader) string() string {
+	return string(r.take(int(r.uint32())))
+}
+
+// envelope reads an envelope header and returns a reader of its fields.
+func (r *serdeReader) envelope() (serdeEnvelope, *serdeReader) {
+	env := serdeEnvelope{Version: r.uint8(), CompatVersion: r.uint8()}
+	body := &serdeReader{buf: r.take(int(r.uint32()))}
+	body.err = r.err
+	return env, body
+}
+
+// trailing reads the fields that the envelope has, in order, into extra.
+func (r *serdeReader) trailing(fields []serdeField, extra map[string]json.RawMessage) {
+	for _, field := range fields {
+		if len(r.buf) == 0 || r.err != nil {
+			return
+		}
+		var value string
+		switch {
+		case field.size == 2:
+			value = strconv.FormatInt(int64(int16(r.uint16())), 10)
+		case unsignedSerdeFields[field.name]:
+			value = strconv.FormatUint(r.uint64(), 10)
+		default:
+			value = strconv.FormatInt(int64(r.uint64()), 10)
+		}
+		extra[field.name] = json.RawMessage(value)
+	}
+}
+
+// end keeps the unread bytes of an envelope as its tail, in extra.
+func (r *serdeReader) end(env serdeEnvelope, extra map[string]json.RawMessage) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.buf) > 0 {
+		env.Tail = append([]byte(nil), r.buf...)
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	extra[serdeExtraKey] = data
+	return nil
+}
+
+// serdeWriter appends serde fields to a buffer.
+type serdeWriter struct {
+	buf []byte
+}
+
+func (w *serdeWriter) uint8(v uint8) {
+	w.buf = append(w.buf, v)
+}
+
+func (w *serdeWriter) uint16(v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *serdeWriter) uint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *serdeWriter) uint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *serdeWriter) string(s string) {
+	w.uint32(uint32(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// envelope writes an envelope with the fields written by fn.
+func (w *serdeWriter) envelope(env serdeEnvelope, fn func() error) error {
+	w.uint8(env.Version)
+	w.uint8(env.CompatVersion)
+	start := len(w.buf)
+	w.uint32(0)
+	if err := fn(); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(w.buf[start:], uint32(len(w.buf)-start-4))
+	return nil
+}
+
+// trailing writes the fields found in extra, in order, stopping at the
+// first missing one. A field found after a missing one cannot be written.
+func (w *serdeWriter) trailing(fields []serdeField, extra map[string]json.RawMessage) error {
+	for i, field := range fields {
+		raw, ok := extra[field.name]
+		if !ok {
+			for _, later := range fields[i+1:] {
+				if _, ok := extra[later.name]; ok {
+					return fmt.Errorf("serde: %s without %s", later.name, field.name)
+				}
+			}
+			return nil
+		}
+		var value json.Number
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("serde: %s: %w", field.name, err)
+		}
+		if field.size == 2 {
+			v, err := strconv.ParseInt(value.String(), 10, 16)
+			if err != nil {
+				return fmt.Errorf("serde: %s: %w", field.name, err)
+			}
+			w.uint16(uint16(v))
+			continue
+		}
+		if unsignedSerdeFields[field.name] {
+			v, err := strconv.ParseUint(value.String(), 10, 64)
+			if err != nil {
+				return fmt.Errorf("serde: %s: %w", field.name, err)
+			}
+			w.uint64(v)
+			continue
+		}
+		v, err := strconv.ParseInt(value.String(), 10, 64)
+		if err != nil {
+			return fmt.Errorf("serde: %s: %w", field.name, err)
+		}
+		w.uint64(uint64(v))
+	}
+	return nil
+}
+
+// serdeEnvelopeOf returns the envelope kept in extra, or the first version.
+func serdeEnvelopeOf(extra map[string]json.RawMessage) (serdeEnvelope, error) {
+	var env serdeEnvelope
+	raw, ok := extra[serdeExtraKey]
+	if !ok {
+		return env, nil
+	}
+	err := json.Unmarshal(raw, &env)
+	return env, err
+}
+
+// DecodeBinaryManifest decodes a partition manifest in the serde binary
+// format.
+func DecodeBinaryManifest(data []byte) (Manifest, error) {
+	r := &serdeReader{buf: data}
+	m := Manifest{Segments: make(map[string]Segment), Extra: make(map[string]json.RawMessage)}
+	env, body := r.envelope()
+
+	ntpEnv, ntp := body.envelope()
+	m.Namespace = ntp.string()
+	tpEnv, tp := ntp.envelope()
+	m.Topic = tp.string()
+	m.Partition = int(int32(tp.uint32()))
+	if tp.err != nil || ntp.err != nil {
+		body.err = errSerdeShort
+	}
+	if len(tp.buf) > 0 {
+		tpEnv.Tail = append([]byte(nil), tp.buf...)
+	}
+	if len(ntp.buf) > 0 {
+		ntpEnv.Tail = append([]byte(nil), ntp.buf...)
+	}
+	env.NTP, env.TP = &ntpEnv, &tpEnv
+	m.Revision = int(int64(body.uint64()))
+
+	for i, n := 0, int(body.uint32()); i < n && body.err == nil; i++ {
+		baseOffset := body.uint64()
+		name, segment, err := decodeSerdeSegment(body)
+		if err != nil {
+			return m, err
+		}
+		if body.err == nil && baseOffset != segment.BaseOffset {
+			return m, fmt.Errorf("serde: segment key %d does not match its base offset %d, unsupported manifest layout", baseOffset, segment.BaseOffset)
+		}
+		env.Unparsed = env.Unparsed || !segmentParsed(segment)
+		m.Segments[name] = segment
+	}
+	var replaced []Segment
+	for i, n := 0, int(body.uint32()); i < n && body.err == nil; i++ {
+		_, segment, err := decodeSerdeSegment(body)
+		if err != nil {
+			return m, err
+		}
+		env.Unparsed = env.Unparsed || !segmentParsed(segment)
+		replaced = append(replaced, segment)
+	}
+	if len(replaced) > 0 {
+		data, err := json.Marshal(replaced)
+		if err != nil {
+			return m, err
+		}
+		m.Extra["replaced"] = data
+	}
+	m.LastOffset = body.uint64()
+	body.trailing(manifestSerdeFields, m.Extra)
+	if err := body.end(env, m.Extra); err != nil {
+		return m, err
+	}
+	return m, r.err
+}
+
+func decodeSerdeSegment(r *serdeReader) (string, Segment, error) {
+	env, body := r.envelope()
+	s := Segment{Extra: make(map[string]json.RawMessage)}
+	s.IsCompacted = body.uint8() != 0
+	s.SizeBytes = body.uint64()
+	s.BaseOffset = body.uint64()
+	s.CommittedOffset = body.uint64()
+	s.BaseTimestamp = body.uint64()
+	s.MaxTimestamp = body.uint64()
+	s.DeltaOffset = body.uint64()
+	s.Extra["ntp_revision"] = json.RawMessage(strconv.FormatInt(int64(body.uint64()), 10))
+	s.ArchiverTerm = int(int64(body.uint64()))
+	body.trailing(segmentSerdeFields, s.Extra)
+	if err := body.end(env, s.Extra); err != nil {
+		return "", s, err
+	}
+	return serdeSegmentName(s), s, nil
+}
+
+// segmentParsed reports whether the envelope of a decoded segment held no
+// unknown fields.
+func segmentParsed(s Segment) bool {
+	env, err := serdeEnvelopeOf(s.Extra)
+	return err == nil && env.parsed()
+}
+
+// serdeSegmentName returns the name of a segment, made of its base offset
+// and term, or with the v2 and v3 name formats of its base offset,
+// committed offset, size and term.
+func serdeSegmentName(s Segment) string {
+	term := int64(s.ArchiverTerm)
+	if raw, ok := s.Extra["segment_term"]; ok {
+		term, _ = strconv.ParseInt(string(raw), 10, 64)
+	}
+	if raw, ok := s.Extra["sname_format"]; ok && string(raw) != "1" {
+		return fmt.Sprintf("%d-%d-%d-%d-v1.log", s.BaseOffset, s.CommittedOffset, s.SizeBytes, term)
+	}
+	return fmt.Sprintf("%d-%d-v1.log", s.BaseOffset, term)
+}
+
+// EncodeBinaryManifest encodes a partition manifest in the serde binary
+// format. Manifests decoded by DecodeBinaryManifest are encoded back to the
+// same bytes, with the same envelope versions. It fails with ErrSerdeUnparsed
+// when any envelope of the manifest held fields unknown to rpksi.
+func EncodeBinaryManifest(m Manifest) ([]byte, error) {
+	env, err := serdeEnvelopeOf(m.Extra)
+	if err != nil {
+		return nil, err
+	}
+	if !env.parsed() {
+		return nil, ErrSerdeUnparsed
+	}
+	ntpEnv, tpEnv := serdeEnvelope{}, serdeEnvelope{}
+	if env.NTP != nil {
+		ntpEnv = *env.NTP
+	}
+	if env.TP != nil {
+		tpEnv = *env.TP
+	}
+	w := &serdeWriter{}
+	err = w.envelope(env, func() error {
+		err := w.envelope(ntpEnv, func() error {
+			w.string(m.Namespace)
+			return w.envelope(tpEnv, func() error {
+				w.string(m.Topic)
+				w.uint32(uint32(int32(m.Partition)))
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		w.uint64(uint64(int64(m.Revision)))
+
+		segments := make([]Segment, 0, len(m.Segments))
+		for _, segment := range m.Segments {
+			segments = append(segments, segment)
+		}
+		sort.Slice(segments, func(i, j int) bool {
+			return segments[i].BaseOffset < segments[j].BaseOffset
+		})
+		w.uint32(uint32(len(segments)))
+		for _, segment := range segments {
+			w.uint64(segment.BaseOffset)
+			if err := encodeSerdeSegment(w, segment); err != nil {
+				return err
+			}
+		}
+		var replaced []Segment
+		if raw, ok := m.Extra["replaced"]; ok {
+			if err := json.Unmarshal(raw, &replaced); err != nil {
+				return fmt.Errorf("serde: replaced: %w", err)
+			}
+		}
+		w.uint32(uint32(len(replaced)))
+		for _, segment := range replaced {
+			if err := encodeSerdeSegment(w, segment); err != nil {
+				return err
+			}
+		}
+		w.uint64(m.LastOffset)
+		if err := w.trailing(manifestSerdeFields, m.Extra); err != nil {
+			return err
+		}
+		w.buf = append(w.buf, env.Tail...)
+		return nil
+	})
+	return w.buf, err
+}
+
+func encodeSerdeSegment(w *serdeWriter, s Segment) error {
+	env, err := serdeEnvelopeOf(s.Extra)
+	if err != nil {
+		return err
+	}
+	if !env.parsed() {
+		return ErrSerdeUnparsed
+	}
+	return w.envelope(env, func() error {
+		var compacted uint8
+		if s.IsCompacted {
+			compacted = 1
+		}
+		w.uint8(compacted)
+		w.uint64(s.SizeBytes)
+		w.uint64(s.BaseOffset)
+		w.uint64(s.CommittedOffset)
+		w.uint64(s.BaseTimestamp)
+		w.uint64(s.MaxTimestamp)
+		w.uint64(s.DeltaOffset)
+		var revision int64
+		if raw, ok := s.Extra["ntp_revision"]; ok {
+			if revision, err = strconv.ParseInt(string(raw), 10, 64); err != nil {
+				return fmt.Errorf("serde: ntp_revision: %w", err)
+			}
+		}
+		w.uint64(uint64(revision))
+		w.uint64(uint64(int64(s.ArchiverTerm)))
+		if err := w.trailing(segmentSerdeFields, s.Extra); err != nil {
+			return err
+		}
+		w.buf = append(w.buf, env.Tail...)
+		return nil
+	})
+}
@@ -0,0 +1,262 @@
+package rpksi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixtures below are built field by field following the layout described
+// in serde.go, they are not captured from Redpanda. Manifests captured from a
+// cluster go in testdata/*.bin and are checked by TestBinaryManifestGolden,
+// which fails until there are some.
+
+func le16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func le64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
+
+func serdeString(s string) []byte {
+	return append(le32(uint32(len(s))), s...)
+}
+
+func serdeEnvelopeBytes(version, compat uint8, fields ...[]byte) []byte {
+	body := bytes.Join(fields, nil)
+	return append(append([]byte{version, compat}, le32(uint32(len(body)))...), body...)
+}
+
+// serdeSegmentBytes is the envelope of a segment of ten offsets in the v2 name
+// format, followed by extra bytes.
+func serdeSegmentBytes(base uint64, extra ...[]byte) []byte {
+	fields := [][]byte{
+		{0},            // is_compacted
+		le64(1024),     // size_bytes
+		le64(base),     // base_offset
+		le64(base + 9), // committed_offset
+		le64(base),     // base_timestamp
+		le64(base + 9), // max_timestamp
+		le64(0),        // delta_offset
+		le64(7),        // ntp_revision
+		le64(1),        // archiver_term
+		le64(1),        // segment_term
+		le64(0),        // delta_offset_end
+		le16(2),        // sname_format
+		le64(0),        // metadata_size_hint
+	}
+	return serdeEnvelopeBytes(3, 0, append(fields, extra...)...)
+}
+
+// serdeManifestBytes is a manifest of topic events, partition 3, holding two
+// segments. ntpTail is appended to the ntp envelope and segmentTail to the
+// envelope of the first segment.
+func serdeManifestBytes(ntpTail, segmentTail []byte) []byte {
+	return serdeEnvelopeBytes(2, 0,
+		serdeEnvelopeBytes(1, 0,
+			serdeString("kafka"),
+			serdeEnvelopeBytes(1, 0, serdeString("events"), le32(3)),
+			ntpTail,
+		),
+		le64(7), // revision
+		le32(2), // segments
+		le64(0), serdeSegmentBytes(0, segmentTail),
+		le64(10), serdeSegmentBytes(10),
+		le32(0),  // replaced
+		le64(19), // last_offset
+		le64(0),  // start_offset
+		le64(^uint64(0)),
+		le64(19), // insync_offset
+		le64(2048),
+		le64(^uint64(0)), // archive_start_offset
+		le64(0),
+		le64(0),
+		le64(0),
+	)
+}
+
+func TestBinaryManifestRoundTrip(t *testing.T) {
+	data := serdeManifestBytes(nil, nil)
+	m, err := DecodeBinaryManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Namespace != "kafka" || m.Topic != "events" || m.Partition != 3 || m.Revision != 7 || m.LastOffset != 19 {
+		t.Errorf("manifest decoded as %s/%s/%d_%d, last offset %d", m.Namespace, m.Topic, m.Partition, m.Revision, m.LastOffset)
+	}
+	segment, ok := m.Segments["10-19-1024-1-v1.log"]
+	if !ok {
+		t.Fatalf("segments decoded as %v", segmentNames(m))
+	}
+	if segment.BaseOffset != 10 || segment.CommittedOffset != 19 || segment.SizeBytes != 1024 || segment.ArchiverTerm != 1 {
+		t.Errorf("segment decoded as %+v", segment)
+	}
+	if got := string(m.Extra["archive_start_offset"]); got != "-1" {
+		t.Errorf("archive_start_offset = %s, want -1", got)
+	}
+	if got := string(m.Extra["cloud_log_size_bytes"]); got != "2048" {
+		t.Errorf("cloud_log_size_bytes = %s, want 2048", got)
+	}
+
+	encoded, err := EncodeBinaryManifest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Errorf("manifest encoded as\n%x\nwant\n%x", encoded, data)
+	}
+}
+
+func TestBinaryManifestGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no manifest.bin captured from Redpanda in testdata, the binary layout is not verified")
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := DecodeBinaryManifest(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := EncodeBinaryManifest(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(encoded, data) {
+				t.Errorf("%s is not encoded back to the same bytes", path)
+			}
+		})
+	}
+}
+
+func TestBinaryManifestRefusesUnparsed(t *testing.T) {
+	for _, tt := range []struct {
+		name                 string
+		ntpTail, segmentTail []byte
+	}{
+		{"ntp", le64(1), nil},
+		{"segment", nil, le64(1)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := DecodeBinaryManifest(serdeManifestBytes(tt.ntpTail, tt.segmentTail))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Segments) != 2 {
+				t.Errorf("segments decoded as %v", segmentNames(m))
+			}
+			if _, err := EncodeBinaryManifest(m); !errors.Is(err, ErrSerdeUnparsed) {
+				t.Errorf("encoding: %v, want %v", err, ErrSerdeUnparsed)
+			}
+
+			c, store := newTestClient()
+			key := testHash + "/meta/kafka/events/3_7/" + BinaryManifestFileName
+			putObject(t, store, key, string(serdeManifestBytes(tt.ntpTail, tt.segmentTail)))
+			for name := range m.Segments {
+				putObject(t, store, testHash+"/kafka/events/3_7/"+name+".1", "segment")
+			}
+			_, err = c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 10})
+			if !errors.Is(err, ErrBinaryManifestReadOnly) {
+				t.Errorf("planning a deletion: %v, want %v", err, ErrBinaryManifestReadOnly)
+			}
+		})
+	}
+}
+
+func TestBinaryManifestReadOnly(t *testing.T) {
+	c, store := newTestClient()
+	key := testHash + "/meta/kafka/events/3_7/" + BinaryManifestFileName
+	data := string(serdeManifestBytes(nil, nil))
+	putObject(t, store, key, data)
+	m, err := c.ReadManifest(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range m.Segments {
+		putObject(t, store, testHash+"/kafka/events/3_7/"+name+".1", "segment")
+	}
+	if _, err := c.PlanDeletion(context.Background(), Filter{Topic: "events", OlderThan: 10}); !errors.Is(err, ErrBinaryManifestReadOnly) {
+		t.Errorf("planning a deletion: %v, want %v", err, ErrBinaryManifestReadOnly)
+	}
+	if err := c.WriteManifest(context.Background(), key, m); !errors.Is(err, ErrBinaryManifestReadOnly) {
+		t.Errorf("writing: %v, want %v", err, ErrBinaryManifestReadOnly)
+	}
+	if got, err := c.readObject(context.Background(), key); err != nil || string(got) != data {
+		t.Errorf("manifest.bin was rewritten: %v", err)
+	}
+}
+
+func TestUndecodableManifestSkipped(t *testing.T) {
+	ctx := context.Background()
+	c, store := newTestClient()
+	var log bytes.Buffer
+	c.Log = &log
+	putPartition(t, store, testManifest("events", 0, 2))
+	badKey := testHash + "/meta/kafka/events/1_7/" + BinaryManifestFileName
+	putObject(t, store, badKey, string(serdeManifestBytes(nil, nil)[:40]))
+	orphanKey := testHash + "/kafka/events/1_7/0-1-v1.log.1"
+	putObject(t, store, orphanKey, "segment")
+
+	rows, err := c.ListSegments(ctx, Filter{Topic: "events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Errorf("listed %d segments, want the 2 of the decodable manifest", len(rows))
+	}
+	if !strings.Contains(log.String(), "warning: "+badKey+": ") {
+		t.Errorf("no warning about the undecodable manifest in %q", log.String())
+	}
+
+	result, err := c.CheckManifests(ctx, Filter{Topic: "events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Manifests != 2 || result.Count(SeverityError) != 1 || result.Findings[0].Check != CheckDecode || result.Findings[0].Partition != 1 {
+		t.Errorf("fsck found %+v", result)
+	}
+
+	report, err := c.FindOrphans(ctx, "events", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 0 || len(report.Skipped) != 1 || report.Skipped[0] != badKey {
+		t.Errorf("gc found %+v, want the partition of the undecodable manifest skipped", report)
+	}
+}
+
+func TestBinaryManifestOtherLayout(t *testing.T) {
+	data := serdeManifestBytes(nil, nil)
+	// the map key of the second segment no longer matches its base offset
+	i := bytes.Index(data, append(le64(10), serdeSegmentBytes(10)...))
+	copy(data[i:], le64(11))
+	if _, err := DecodeBinaryManifest(data); err == nil {
+		t.Error("manifest with a segment key not matching its base offset decoded")
+	}
+	if _, err := DecodeBinaryManifest(serdeManifestBytes(nil, nil)[:40]); err == nil {
+		t.Error("truncated manifest decoded")
+	}
+}
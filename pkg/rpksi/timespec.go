@@ -0,0 +1,117 @@
+package rpksi
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeRange is an inclusive range of unix timestamps in milliseconds.
+type TimeRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+var (
+	durationPattern = regexp.MustCompile(`^(\d+[wdhm])+$`)
+	durationPart    = regexp.MustCompile(`(\d+)([wdhm])`)
+	epochPattern    = regexp.MustCompile(`^(\d+)(ms|s)?$`)
+)
+
+// durationUnits are the units of both durations and times before now. Seconds
+// are not among them, as a number of seconds is a unix epoch.
+var durationUnits = map[string]time.Duration{
+	"w": 7 * 24 * time.Hour,
+	"d": 24 * time.Hour,
+	"h": time.Hour,
+	"m": time.Minute,
+}
+
+// ParseTime parses a point in time and returns it as a unix timestamp in
+// milliseconds, the unit of the manifest's timestamps. Accepted values are:
+//
+//	30d, 12h, 1w2d, 1h30m   a duration before now (units w, d, h and m)
+//	2022-05-28T03:15:00Z    an RFC3339 timestamp
+//	2022-05-28              a date (UTC midnight, the start of the day)
+//	1653707348951ms         a unix epoch in milliseconds
+//	1653707348951           a unix epoch in milliseconds
+//	1653707348s             a unix epoch in seconds
+//	now                     the current time
+func ParseTime(value string, now time.Time) (int64, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "now":
+		return now.UnixMilli(), nil
+	case durationPattern.MatchString(value):
+		d, err := parseDurationUnits(value)
+		if err != nil {
+			return 0, err
+		}
+		return now.Add(-d).UnixMilli(), nil
+	case epochPattern.MatchString(value):
+		match := epochPattern.FindStringSubmatch(value)
+		n, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if match[2] == "s" {
+			return n * 1000, nil
+		}
+		return n, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UnixMilli(), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("invalid time %q (expected a duration like 30d or 12h, an RFC3339 timestamp, a date, or a unix epoch like 1653707348951ms or 1653707348s)", value)
+}
+
+// ParseDuration parses a duration made of the units of ParseTime, w, d, h and
+// m (7d, 1w2d, 1d12h, 30m), or 0.
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "0" {
+		return 0, nil
+	}
+	if !durationPattern.MatchString(value) {
+		return 0, fmt.Errorf("invalid duration %q (expected a duration like 30m, 24h, 7d or 1w2d)", value)
+	}
+	return parseDurationUnits(value)
+}
+
+// parseDurationUnits adds up the parts of a value matching durationPattern.
+func parseDurationUnits(value string) (time.Duration, error) {
+	var d time.Duration
+	for _, part := range durationPart.FindAllStringSubmatch(value, -1) {
+		n, err := strconv.ParseInt(part[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(n) * durationUnits[part[2]]
+	}
+	return d, nil
+}
+
+// ParseTimeRange parses two times separated by a comma, see ParseTime.
+func ParseTimeRange(value string, now time.Time) (TimeRange, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return TimeRange{}, fmt.Errorf("invalid time range %q (expected <from>,<to>)", value)
+	}
+	from, err := ParseTime(parts[0], now)
+	if err != nil {
+		return TimeRange{}, err
+	}
+	to, err := ParseTime(parts[1], now)
+	if err != nil {
+		return TimeRange{}, err
+	}
+	if from > to {
+		return TimeRange{}, fmt.Errorf("invalid time range %q (from is after to)", value)
+	}
+	return TimeRange{From: from, To: to}, nil
+}
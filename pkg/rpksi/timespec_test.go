@@ -0,0 +1,65 @@
+package rpksi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"0", 0},
+		{"30m", 30 * time.Minute},
+		{"24h", 24 * time.Hour},
+		{"1h30m", 90 * time.Minute},
+		{"7d", 7 * 24 * time.Hour},
+		{"1w2d", 9 * 24 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+	} {
+		got, err := ParseDuration(tt.value)
+		if err != nil {
+			t.Errorf("ParseDuration(%q): %v", tt.value, err)
+		} else if got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	// seconds are not a unit, as they are for ParseTime
+	for _, value := range []string{"", "30s", "1m30s", "500ms", "2022-05-28", "1653707348s0", "-1h", "now", "3x"} {
+		if got, err := ParseDuration(value); err == nil {
+			t.Errorf("ParseDuration(%q) = %v, want an error", value, got)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2022, 5, 28, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		value string
+		want  int64
+	}{
+		{"now", now.UnixMilli()},
+		{"12h", now.Add(-12 * time.Hour).UnixMilli()},
+		{"1w2d", now.Add(-9 * 24 * time.Hour).UnixMilli()},
+		{"1h30m", now.Add(-90 * time.Minute).UnixMilli()},
+		{"2022-05-28T03:15:00Z", time.Date(2022, 5, 28, 3, 15, 0, 0, time.UTC).UnixMilli()},
+		{"2022-05-28", time.Date(2022, 5, 28, 0, 0, 0, 0, time.UTC).UnixMilli()},
+		{"1653707348951", 1653707348951},
+		{"1653707348951ms", 1653707348951},
+		{"1653707348s", 1653707348000},
+		{"30s", 30000},
+	} {
+		got, err := ParseTime(tt.value, now)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %v", tt.value, err)
+		} else if got != tt.want {
+			t.Errorf("ParseTime(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+	for _, value := range []string{"1h30s", "@1653707348", "1653707348sms", "-1h", "yesterday"} {
+		if got, err := ParseTime(value, now); err == nil {
+			t.Errorf("ParseTime(%q) = %d, want an error", value, got)
+		}
+	}
+}
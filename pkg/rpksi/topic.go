@@ -0,0 +1,189 @@
+package rpksi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"rpksi/pkg/storage"
+	"sort"
+	"strings"
+)
+
+// TopicManifestFileName is the object name of a topic manifest, stored as
+// <hash>/meta/<namespace>/<topic>/topic_manifest.json.
+const TopicManifestFileName = "topic_manifest.json"
+
+// ErrTopicNotFound is returned when a topic has neither a topic manifest nor
+// partition manifests in the bucket.
+var ErrTopicNotFound = errors.New("topic not found")
+
+// TopicManifest is the configuration of a topic as archived by Redpanda.
+// Settings left to the cluster defaults are nil, and retention durations are
+// in milliseconds. Fields not modeled here are kept in Extra.
+type TopicManifest struct {
+	Version            int                        `json:"version" yaml:"version"`
+	Namespace          string                     `json:"namespace" yaml:"namespace"`
+	Topic              string                     `json:"topic" yaml:"topic"`
+	PartitionCount     int                        `json:"partition_count" yaml:"partition_count"`
+	ReplicationFactor  int                        `json:"replication_factor" yaml:"replication_factor"`
+	RevisionID         int                        `json:"revision_id" yaml:"revision_id"`
+	Compression        *string                    `json:"compression" yaml:"compression"`
+	CleanupPolicy      *string                    `json:"cleanup_policy_bitflags" yaml:"cleanup_policy_bitflags"`
+	CompactionStrategy *string                    `json:"compaction_strategy" yaml:"compaction_strategy"`
+	TimestampType      *string                    `json:"timestamp_type" yaml:"timestamp_type"`
+	SegmentSize        *int64                     `json:"segment_size" yaml:"segment_size"`
+	RetentionBytes     *int64                     `json:"retention_bytes" yaml:"retention_bytes"`
+	RetentionDuration  *int64                     `json:"retention_duration" yaml:"retention_duration"`
+	Extra              map[string]json.RawMessage `json:"-" yaml:"-"`
+}
+
+func (m *TopicManifest) UnmarshalJSON(data []byte) error {
+	type topicManifest TopicManifest
+	extra, err := unmarshalWithExtra(data, (*topicManifest)(m))
+	m.Extra = extra
+	return err
+}
+
+func (m TopicManifest) MarshalJSON() ([]byte, error) {
+	type topicManifest TopicManifest
+	return marshalWithExtra(topicManifest(m), m.Extra)
+}
+
+// TopicManifestObject is a topic manifest along with the key it was read
+// from.
+type TopicManifestObject struct {
+	Key      string
+	Manifest TopicManifest
+}
+
+// isTopicManifestKey reports whether key is a topic manifest.
+func isTopicManifestKey(key string) bool {
+	parts := strings.Split(key, "/")
+	return len(parts) == 5 && parts[1] == "meta" && parts[2] == Namespace && parts[4] == TopicManifestFileName
+}
+
+// readTopicManifests reads the topic manifests found in a bucket listing,
+// keyed by topic.
+func (c *Client) readTopicManifests(ctx context.Context, objects []storage.ObjectInfo, topic string) (map[string]TopicManifestObject, error) {
+	manifests := make(map[string]TopicManifestObject)
+	for _, object := range objects {
+		if !isTopicManifestKey(object.Key) {
+			continue
+		}
+		name := strings.Split(object.Key, "/")[3]
+		if len(topic) > 0 && topic != name {
+			continue
+		}
+		data, err := c.readObject(ctx, object.Key)
+		if err != nil {
+			return nil, err
+		}
+		var manifest TopicManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("%s: %w", object.Key, err)
+		}
+		manifests[name] = TopicManifestObject{Key: object.Key, Manifest: manifest}
+	}
+	return manifests, nil
+}
+
+// PartitionSummary summarizes the manifest of an archived partition. Sizes
+// are in bytes.
+type PartitionSummary struct {
+	Partition    int    `json:"partition" yaml:"partition"`
+	Revision     int    `json:"revision" yaml:"revision"`
+	ManifestKey  string `json:"manifest_key" yaml:"manifest_key"`
+	Format       string `json:"format" yaml:"format"`
+	SegmentCount int    `json:"segment_count" yaml:"segment_count"`
+	Size         uint64 `json:"size" yaml:"size"`
+	StartOffset  uint64 `json:"start_offset" yaml:"start_offset"`
+	LastOffset   uint64 `json:"last_offset" yaml:"last_offset"`
+}
+
+// TopicDescription is the topic manifest of a topic along with the
+// manifests of its archived partitions.
+type TopicDescription struct {
+	Topic string `json:"topic" yaml:"topic"`
+	// Manifest is nil when the bucket has no topic manifest for the topic.
+	Manifest    *TopicManifest     `json:"manifest" yaml:"manifest"`
+	ManifestKey string             `json:"manifest_key,omitempty" yaml:"manifest_key,omitempty"`
+	Partitions  []PartitionSummary `json:"partitions" yaml:"partitions"`
+}
+
+// ArchivedPartitions returns the number of distinct archived partitions, a
+// partition being archived under several revisions when its topic was
+// recreated.
+func (d TopicDescription) ArchivedPartitions() int {
+	partitions := make(map[int]bool)
+	for _, summary := range d.Partitions {
+		partitions[summary.Partition] = true
+	}
+	return len(partitions)
+}
+
+// PartitionMismatch reports whether the number of archived partitions
+// differs from the partition count of the topic manifest.
+func (d TopicDescription) PartitionMismatch() bool {
+	return d.Manifest != nil && d.ArchivedPartitions() != d.Manifest.PartitionCount
+}
+
+// DescribeTopic returns the topic manifest of a topic and a summary of each
+// of its partition manifests, sorted by partition and revision.
+func (c *Client) DescribeTopic(ctx context.Context, topic string) (TopicDescription, error) {
+	description := TopicDescription{Topic: topic}
+	objects, err := c.Store.ListObjects(ctx, "")
+	if err != nil {
+		return description, err
+	}
+	topicManifests, err := c.readTopicManifests(ctx, objects, topic)
+	if err != nil {
+		return description, err
+	}
+	if tmo, ok := topicManifests[topic]; ok {
+		description.Manifest = &tmo.Manifest
+		description.ManifestKey = tmo.Key
+	}
+	manifests, _, err := c.readManifests(ctx, objects, topic)
+	if err != nil {
+		return description, err
+	}
+	for _, mo := range manifests {
+		description.Partitions = append(description.Partitions, summarizePartition(mo))
+	}
+	if description.Manifest == nil && len(description.Partitions) == 0 {
+		return description, fmt.Errorf("%w: %s", ErrTopicNotFound, topic)
+	}
+	sort.Slice(description.Partitions, func(i, j int) bool {
+		a, b := description.Partitions[i], description.Partitions[j]
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		return a.Revision < b.Revision
+	})
+	return description, nil
+}
+
+func summarizePartition(mo ManifestObject) PartitionSummary {
+	summary := PartitionSummary{
+		Partition:    mo.Manifest.Partition,
+		Revision:     mo.Manifest.Revision,
+		ManifestKey:  mo.Key,
+		Format:       "json",
+		SegmentCount: len(mo.Manifest.Segments),
+		LastOffset:   mo.Manifest.LastOffset,
+	}
+	if path.Base(mo.Key) == BinaryManifestFileName {
+		summary.Format = "binary"
+	}
+	first := true
+	for _, segment := range mo.Manifest.Segments {
+		if first || segment.BaseOffset < summary.StartOffset {
+			first = false
+			summary.StartOffset = segment.BaseOffset
+		}
+		summary.Size += segment.SizeBytes
+	}
+	return summary
+}
@@ -0,0 +1,76 @@
+package rpksi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDescribeTopic(t *testing.T) {
+	c, store := newTestClient()
+	data, err := os.ReadFile(filepath.Join("testdata", TopicManifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := testHash + "/meta/kafka/events/" + TopicManifestFileName
+	putObject(t, store, key, string(data))
+	// two of the three partitions are archived
+	putManifest(t, store, testManifest("events", 1, 2))
+	putManifest(t, store, testManifest("events", 0, 3))
+
+	description, err := c.DescribeTopic(context.Background(), "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if description.Manifest == nil || description.ManifestKey != key {
+		t.Fatalf("topic manifest %v at %q, want %s", description.Manifest, description.ManifestKey, key)
+	}
+	m := description.Manifest
+	if m.Topic != "events" || m.PartitionCount != 3 || m.ReplicationFactor != 3 || m.RevisionID != 7 {
+		t.Errorf("decoded %+v", *m)
+	}
+	if m.CleanupPolicy == nil || *m.CleanupPolicy != "delete" || m.Compression != nil || m.SegmentSize != nil {
+		t.Errorf("decoded the policy %v, compression %v and segment size %v", m.CleanupPolicy, m.Compression, m.SegmentSize)
+	}
+	if m.RetentionDuration == nil || *m.RetentionDuration != 604800000 {
+		t.Errorf("decoded the retention duration %v, want 604800000", m.RetentionDuration)
+	}
+	if _, ok := m.Extra["virtual_cluster_id"]; !ok || len(m.Extra) != 1 {
+		t.Errorf("kept %v, want virtual_cluster_id", m.Extra)
+	}
+
+	// the manifest is written back as it was read
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]interface{}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("encoded %s, want %s", encoded, data)
+	}
+
+	summaries := []PartitionSummary{
+		{Partition: 0, Revision: 7, ManifestKey: manifestKey(testManifest("events", 0, 0)), Format: "json", SegmentCount: 3, Size: 3072, StartOffset: 0, LastOffset: 29},
+		{Partition: 1, Revision: 7, ManifestKey: manifestKey(testManifest("events", 1, 0)), Format: "json", SegmentCount: 2, Size: 2048, StartOffset: 0, LastOffset: 19},
+	}
+	if !reflect.DeepEqual(description.Partitions, summaries) {
+		t.Errorf("partitions %+v, want %+v", description.Partitions, summaries)
+	}
+	if !description.PartitionMismatch() {
+		t.Error("2 archived partitions of 3 are not reported")
+	}
+
+	if _, err := c.DescribeTopic(context.Background(), "other"); !errors.Is(err, ErrTopicNotFound) {
+		t.Errorf("unknown topic: %v, want %v", err, ErrTopicNotFound)
+	}
+}
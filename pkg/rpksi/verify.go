@@ -0,0 +1,170 @@
+package rpksi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"rpksi/pkg/segment"
+	"rpksi/pkg/storage"
+)
+
+// SegmentStatus is the outcome of verifying a segment object.
+type SegmentStatus string
+
+const (
+	SegmentOK SegmentStatus = "ok"
+	// SegmentMissing means the manifest lists a segment without an object.
+	SegmentMissing SegmentStatus = "missing"
+	// SegmentTruncated means the object ends before the last batch does, or
+	// is shorter than the manifest says.
+	SegmentTruncated SegmentStatus = "truncated"
+	// SegmentCorrupted means a batch failed a CRC check or could not be
+	// decoded.
+	SegmentCorrupted SegmentStatus = "corrupted"
+	// SegmentMismatch means the object is readable but does not hold the
+	// offsets or size recorded in the manifest.
+	SegmentMismatch SegmentStatus = "mismatch"
+)
+
+// maxSegmentProblems bounds the problems reported for a single segment.
+const maxSegmentProblems = 20
+
+// SegmentCheck is the result of verifying a segment object against its
+// manifest entry.
+type SegmentCheck struct {
+	TopicName   string        `json:"topic_name" yaml:"topic_name"`
+	Partition   int           `json:"partition" yaml:"partition"`
+	SegmentName string        `json:"segment_name" yaml:"segment_name"`
+	ObjectPath  string        `json:"object_path" yaml:"object_path"`
+	Status      SegmentStatus `json:"status" yaml:"status"`
+	Batches     int           `json:"batches" yaml:"batches"`
+	BytesRead   int64         `json:"bytes_read" yaml:"bytes_read"`
+	Problems    []string      `json:"problems" yaml:"problems"`
+}
+
+// problem records a problem, keeping the most severe status.
+func (c *SegmentCheck) problem(status SegmentStatus, format string, a ...interface{}) {
+	if severity(status) > severity(c.Status) {
+		c.Status = status
+	}
+	if len(c.Problems) < maxSegmentProblems {
+		c.Problems = append(c.Problems, fmt.Sprintf(format, a...))
+	} else if len(c.Problems) == maxSegmentProblems {
+		c.Problems = append(c.Problems, "further problems omitted")
+	}
+}
+
+func severity(s SegmentStatus) int {
+	switch s {
+	case SegmentMissing:
+		return 4
+	case SegmentTruncated:
+		return 3
+	case SegmentCorrupted:
+		return 2
+	case SegmentMismatch:
+		return 1
+	}
+	return 0
+}
+
+// VerifySegments downloads the segments matching filter and checks every
+// batch against its header and record CRCs, and each segment against the
+// offsets and size recorded in the manifest. Problems are reported in the
+// returned checks, an error is only returned if the bucket can not be read.
+func (c *Client) VerifySegments(ctx context.Context, filter Filter) ([]SegmentCheck, error) {
+	rows, err := c.ListSegments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	checks := make([]SegmentCheck, 0, len(rows))
+	for _, row := range rows {
+		check, err := c.verifySegment(ctx, row)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", row.ObjectPath, err)
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func (c *Client) verifySegment(ctx context.Context, row RowSegment) (SegmentCheck, error) {
+	check := SegmentCheck{
+		TopicName:   row.TopicName,
+		Partition:   row.Partition,
+		SegmentName: row.SegmentName,
+		ObjectPath:  row.ObjectPath,
+		Status:      SegmentOK,
+	}
+	if len(row.ObjectPath) == 0 {
+		check.problem(SegmentMissing, "no object found for the segment")
+		return check, nil
+	}
+	object, err := c.Store.GetObject(ctx, row.ObjectPath)
+	if errors.Is(err, storage.ErrNotFound) {
+		check.problem(SegmentMissing, "object not found")
+		return check, nil
+	}
+	if err != nil {
+		return check, err
+	}
+	defer object.Close()
+
+	reader := segment.NewReader(object)
+	var first, last *segment.Header
+	for {
+		batch, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, segment.ErrTruncated) {
+			check.problem(SegmentTruncated, "%s", err)
+			break
+		}
+		if errors.Is(err, segment.ErrCorrupted) {
+			check.problem(SegmentCorrupted, "%s", err)
+			break
+		}
+		if err != nil {
+			return check, err
+		}
+		check.Batches++
+		if !batch.HeaderCRCValid {
+			check.problem(SegmentCorrupted, "batch at byte %d (offset %d): header CRC mismatch", batch.Position, batch.BaseOffset)
+		} else if !batch.CRCValid() {
+			check.problem(SegmentCorrupted, "batch at byte %d (offset %d): record CRC mismatch", batch.Position, batch.BaseOffset)
+		}
+		if last != nil && batch.BaseOffset <= last.LastOffset() {
+			check.problem(SegmentCorrupted, "batch at byte %d: offset %d does not follow offset %d", batch.Position, batch.BaseOffset, last.LastOffset())
+		}
+		header := batch.Header
+		if first == nil {
+			first = &header
+		}
+		last = &header
+	}
+	check.BytesRead = reader.Position()
+
+	// the offsets and size of a segment cut short or read up to a corrupted
+	// batch say nothing more
+	if check.Status == SegmentTruncated || check.Status == SegmentCorrupted {
+		return check, nil
+	}
+	if first == nil {
+		check.problem(SegmentMismatch, "no batches found")
+		return check, nil
+	}
+	if uint64(first.BaseOffset) != row.SegmentOldOffsetId {
+		check.problem(SegmentMismatch, "first offset %d, manifest base_offset is %d", first.BaseOffset, row.SegmentOldOffsetId)
+	}
+	if uint64(last.LastOffset()) != row.SegmentNewOffsetId {
+		check.problem(SegmentMismatch, "last offset %d, manifest committed_offset is %d", last.LastOffset(), row.SegmentNewOffsetId)
+	}
+	if uint64(check.BytesRead) < row.SegmentSize {
+		check.problem(SegmentTruncated, "%d bytes read, manifest size_bytes is %d", check.BytesRead, row.SegmentSize)
+	} else if uint64(check.BytesRead) > row.SegmentSize {
+		check.problem(SegmentMismatch, "%d bytes read, manifest size_bytes is %d", check.BytesRead, row.SegmentSize)
+	}
+	return check, nil
+}
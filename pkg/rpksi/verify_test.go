@@ -0,0 +1,64 @@
+package rpksi
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestVerifySegments(t *testing.T) {
+	good := string(testBatch(0, 5)) + string(testBatch(5, 5))
+	flip := func(data string, i int) string {
+		b := []byte(data)
+		b[i] ^= 0xff
+		return string(b)
+	}
+	for _, tt := range []struct {
+		name    string
+		object  string
+		status  SegmentStatus
+		problem string
+	}{
+		{"ok", good, SegmentOK, ""},
+		{"record crc", flip(good, len(good)-1), SegmentCorrupted, "record CRC mismatch"},
+		{"header crc", flip(good, 0), SegmentCorrupted, "header CRC mismatch"},
+		// a batch smaller than its header stops the read
+		{"invalid header", flip(good, 7), SegmentCorrupted, "invalid batch header"},
+		{"cut in a batch", good[:len(good)-3], SegmentTruncated, "segment truncated"},
+		{"cut between batches", good[:len(good)/2], SegmentTruncated, "bytes read, manifest size_bytes is"},
+		{"missing", "", SegmentMissing, "no object found"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, store := newTestClient()
+			m := testManifest("events", 0, 0)
+			name := addSegment(t, store, &m, testBatch(0, 5), testBatch(5, 5))
+			putManifest(t, store, m)
+			key := segmentObjectKey(m, name, 1)
+			if tt.status == SegmentMissing {
+				if err := store.RemoveObject(context.Background(), key); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				putObject(t, store, key, tt.object)
+			}
+
+			checks, err := c.VerifySegments(context.Background(), Filter{Topic: "events"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(checks) != 1 {
+				t.Fatalf("%d checks, want 1", len(checks))
+			}
+			check := checks[0]
+			if check.Status != tt.status {
+				t.Errorf("status %s, want %s (problems %q)", check.Status, tt.status, check.Problems)
+			}
+			if len(tt.problem) > 0 && !strings.Contains(strings.Join(check.Problems, "\n"), tt.problem) {
+				t.Errorf("problems %q, want %q", check.Problems, tt.problem)
+			}
+			if tt.status == SegmentOK && (check.Batches != 2 || check.BytesRead != int64(len(good)) || len(check.Problems) > 0) {
+				t.Errorf("check %+v", check)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package schemaregistry
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/linkedin/goavro/v2"
+	"rpksi/pkg/segment"
+	"sync"
+)
+
+// wireHeaderSize is the size of the magic byte and schema id preceding
+// payloads in the wire format.
+const wireHeaderSize = 5
+
+// SchemaID returns the schema id of a payload in the wire format.
+func SchemaID(payload []byte) (int, bool) {
+	if len(payload) < wireHeaderSize || payload[0] != 0 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint32(payload[1:wireHeaderSize])), true
+}
+
+// payloadDecoder decodes the payload following the wire format header to
+// JSON.
+type payloadDecoder func(payload []byte) ([]byte, error)
+
+// Decoder decodes wire format payloads to JSON. Avro payloads are written in
+// the Avro JSON encoding, Protobuf payloads as objects keyed by field name
+// and JSON payloads as they are.
+type Decoder struct {
+	Registry *Registry
+
+	mu       sync.Mutex
+	decoders map[int]payloadDecoder
+}
+
+// NewDecoder returns a decoder using the schemas of registry.
+func NewDecoder(registry *Registry) *Decoder {
+	return &Decoder{Registry: registry, decoders: make(map[int]payloadDecoder)}
+}
+
+// Decode returns the JSON of a payload in the wire format. It returns false
+// for payloads that are not in the wire format, including those with a
+// schema id the registry does not know.
+func (d *Decoder) Decode(ctx context.Context, payload []byte) ([]byte, bool, error) {
+	id, ok := SchemaID(payload)
+	if !ok {
+		return nil, false, nil
+	}
+	decode, err := d.decoder(ctx, id)
+	if err != nil || decode == nil {
+		return nil, false, err
+	}
+	decoded, err := decode(payload[wireHeaderSize:])
+	if err != nil {
+		return nil, false, fmt.Errorf("schema %d: %w", id, err)
+	}
+	return decoded, true, nil
+}
+
+// DecodeRecord returns the record with its key and value replaced by their
+// JSON when they are in the wire format. The key and value are decoded
+// independently: when either fails to decode, the record is returned along
+// with the error, holding the raw bytes of the one that failed.
+func (d *Decoder) DecodeRecord(ctx context.Context, r segment.Record) (segment.Record, error) {
+	var keyErr error
+	key, ok, err := d.Decode(ctx, r.Key)
+	if err != nil {
+		keyErr = fmt.Errorf("offset %d key: %w", r.Offset, err)
+	} else if ok {
+		r.Key = key
+	}
+	value, ok, err := d.Decode(ctx, r.Value)
+	if err != nil {
+		if keyErr != nil {
+			return r, fmt.Errorf("%v, value: %w", keyErr, err)
+		}
+		return r, fmt.Errorf("offset %d value: %w", r.Offset, err)
+	}
+	if ok {
+		r.Value = value
+	}
+	return r, keyErr
+}
+
+// decoder returns the payload decoder of a schema, nil when the registry
+// does not know the schema id.
+func (d *Decoder) decoder(ctx context.Context, id int) (payloadDecoder, error) {
+	d.mu.Lock()
+	decode, ok := d.decoders[id]
+	d.mu.Unlock()
+	if ok {
+		return decode, nil
+	}
+
+	schema, err := d.Registry.SchemaByID(ctx, id)
+	if errors.Is(err, ErrSchemaNotFound) {
+		err = nil
+	} else if err == nil {
+		switch schema.SchemaType() {
+		case TypeAvro:
+			decode, err = avroDecoder(schema)
+		case TypeProtobuf:
+			decode, err = d.protobufDecoder(ctx, schema)
+		case TypeJSON:
+			decode = jsonDecoder
+		default:
+			err = fmt.Errorf("unsupported schema type %s", schema.Type)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("schema %d: %w", id, err)
+	}
+
+	// unknown ids are remembered as nil, so they are looked up once
+	d.mu.Lock()
+	d.decoders[id] = decode
+	d.mu.Unlock()
+	return decode, nil
+}
+
+func avroDecoder(schema Schema) (payloadDecoder, error) {
+	if len(schema.References) > 0 {
+		return nil, errors.New("avro schema references are not supported")
+	}
+	codec, err := goavro.NewCodec(schema.Schema)
+	if err != nil {
+		return nil, err
+	}
+	return func(payload []byte) ([]byte, error) {
+		native, _, err := codec.NativeFromBinary(payload)
+		if err != nil {
+			return nil, err
+		}
+		return codec.TextualFromNative(nil, native)
+	}, nil
+}
+
+func jsonDecoder(payload []byte) ([]byte, error) {
+	if !json.Valid(payload) {
+		return nil, errors.New("invalid JSON payload")
+	}
+	return payload, nil
+}
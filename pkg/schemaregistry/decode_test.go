@@ -0,0 +1,39 @@
+package schemaregistry
+
+import (
+	"context"
+	"rpksi/pkg/segment"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRecordKeepsRawBytes(t *testing.T) {
+	dir := t.TempDir()
+	writeSchema(t, dir, "schemas/ids/1", Schema{Type: TypeJSON, Schema: `{"type":"object"}`})
+	d := NewDecoder(NewRegistry("", dir, ""))
+
+	valid, invalid := wirePayload(1, []byte(`{"a":1}`)), wirePayload(1, []byte(`{"a":`))
+	for _, tt := range []struct {
+		name       string
+		key, value []byte
+		wantKey    string
+		wantValue  string
+		err        string
+	}{
+		{"valid", valid, valid, `{"a":1}`, `{"a":1}`, ""},
+		{"key", invalid, valid, string(invalid), `{"a":1}`, "offset 7 key: schema 1: invalid JSON payload"},
+		{"value", valid, invalid, `{"a":1}`, string(invalid), "offset 7 value: schema 1: invalid JSON payload"},
+		{"both", invalid, invalid, string(invalid), string(invalid), "offset 7 key: schema 1: invalid JSON payload, value: "},
+		{"not wire format", []byte("k"), []byte("v"), "k", "v", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := d.DecodeRecord(context.Background(), segment.Record{Offset: 7, Key: tt.key, Value: tt.value})
+			if string(r.Key) != tt.wantKey || string(r.Value) != tt.wantValue {
+				t.Errorf("decoded as %q, %q, want %q, %q", r.Key, r.Value, tt.wantKey, tt.wantValue)
+			}
+			if (err == nil) != (tt.err == "") || (err != nil && !strings.HasPrefix(err.Error(), tt.err)) {
+				t.Errorf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,712 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// protoMessage is a message type, with what is needed to decode it.
+type protoMessage struct {
+	name   string
+	fields map[int]*protoField
+	// nested messages in declaration order, selected by message indexes
+	messages []*protoMessage
+}
+
+// protoField is a message field. Map fields have a key and a value field.
+type protoField struct {
+	name     string
+	number   int
+	typ      string
+	repeated bool
+	key      *protoField
+	value    *protoField
+	// scope is the full name of the enclosing message, typ is resolved
+	// from it into message or enum
+	scope   string
+	message *protoMessage
+	enum    *protoEnum
+}
+
+type protoEnum struct {
+	name   string
+	values map[int32]string
+}
+
+// protoTypes holds the types of a schema and its references by full name.
+type protoTypes struct {
+	messages map[string]*protoMessage
+	enums    map[string]*protoEnum
+}
+
+func newProtoTypes() *protoTypes {
+	return &protoTypes{messages: make(map[string]*protoMessage), enums: make(map[string]*protoEnum)}
+}
+
+// link resolves the message and enum types of every field. Types that are
+// not found, such as well-known types, are decoded as unknown messages.
+func (t *protoTypes) link() {
+	for _, m := range t.messages {
+		for _, f := range m.fields {
+			for _, f := range []*protoField{f, f.value} {
+				if f != nil && !protoScalar(f.typ) {
+					f.message, f.enum = t.resolve(f.typ, f.scope)
+				}
+			}
+		}
+	}
+}
+
+// resolve looks name up from scope outwards, as protoc does.
+func (t *protoTypes) resolve(name, scope string) (*protoMessage, *protoEnum) {
+	if strings.HasPrefix(name, ".") {
+		return t.messages[name[1:]], t.enums[name[1:]]
+	}
+	for {
+		full := name
+		if len(scope) > 0 {
+			full = scope + "." + name
+		}
+		if m, ok := t.messages[full]; ok {
+			return m, nil
+		}
+		if e, ok := t.enums[full]; ok {
+			return nil, e
+		}
+		if len(scope) == 0 {
+			return nil, nil
+		}
+		if i := strings.LastIndexByte(scope, '.'); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+func protoScalar(typ string) bool {
+	switch typ {
+	case "double", "float", "int32", "int64", "uint32", "uint64", "sint32", "sint64",
+		"fixed32", "fixed64", "sfixed32", "sfixed64", "bool", "string", "bytes":
+		return true
+	}
+	return false
+}
+
+// protoSyntaxError aborts parsing, it is recovered by parseProto.
+type protoSyntaxError struct {
+	err error
+}
+
+// protoParser reads the declarations of a .proto file needed to decode
+// messages: the package, messages, fields and enums. Options, services and
+// extensions are skipped.
+type protoParser struct {
+	src   string
+	pos   int
+	tok   string
+	types *protoTypes
+}
+
+// parseProto parses a .proto file into types and returns its top-level
+// messages.
+func parseProto(src string, types *protoTypes) (messages []*protoMessage, err error) {
+	p := &protoParser{src: src, types: types}
+	defer func() {
+		if r := recover(); r != nil {
+			syntaxErr, ok := r.(protoSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = syntaxErr.err
+		}
+	}()
+	var pkg string
+	p.next()
+	for len(p.tok) > 0 {
+		switch p.tok {
+		case "syntax", "edition", "import", "option":
+			p.skipStatement()
+		case "package":
+			pkg = p.next()
+			p.next()
+			p.expect(";")
+		case "message":
+			messages = append(messages, p.message(pkg))
+		case "enum":
+			p.enum(pkg)
+		case "service", "extend":
+			p.skipBlock()
+		case ";":
+			p.next()
+		default:
+			p.fail("unexpected %q", p.tok)
+		}
+	}
+	return messages, nil
+}
+
+func (p *protoParser) fail(format string, args ...interface{}) {
+	line := 1 + strings.Count(p.src[:p.pos], "\n")
+	panic(protoSyntaxError{fmt.Errorf("proto line %d: %s", line, fmt.Sprintf(format, args...))})
+}
+
+// next moves to the next token and returns it, an empty token is the end of
+// the file.
+func (p *protoParser) next() string {
+	for p.pos < len(p.src) {
+		switch {
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			if i := strings.IndexByte(p.src[p.pos:], '\n'); i >= 0 {
+				p.pos += i
+			} else {
+				p.pos = len(p.src)
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			i := strings.Index(p.src[p.pos+2:], "*/")
+			if i < 0 {
+				p.fail("unterminated comment")
+			}
+			p.pos += i + 4
+		case strings.IndexByte(" \t\r\n\f\v", p.src[p.pos]) >= 0:
+			p.pos++
+		default:
+			p.tok = p.token()
+			return p.tok
+		}
+	}
+	p.tok = ""
+	return p.tok
+}
+
+func (p *protoParser) token() string {
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case c == '"' || c == '\'':
+		for p.pos++; p.pos < len(p.src) && p.src[p.pos] != c; p.pos++ {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+		if p.pos >= len(p.src) {
+			p.fail("unterminated string")
+		}
+		p.pos++
+	case protoWordChar(c) || (c == '-' && p.pos+1 < len(p.src) && protoWordChar(p.src[p.pos+1])):
+		for p.pos++; p.pos < len(p.src) && protoWordChar(p.src[p.pos]); p.pos++ {
+		}
+	default:
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func protoWordChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (p *protoParser) expect(tok string) {
+	if p.tok != tok {
+		p.fail("expected %q, got %q", tok, p.tok)
+	}
+	p.next()
+}
+
+func (p *protoParser) number() int {
+	n, err := strconv.ParseInt(p.tok, 0, 32)
+	if err != nil {
+		p.fail("invalid number %q", p.tok)
+	}
+	p.next()
+	return int(n)
+}
+
+// skipStatement skips to the token following the next semicolon outside of
+// braces.
+func (p *protoParser) skipStatement() {
+	depth := 0
+	for {
+		switch p.tok {
+		case "":
+			p.fail("unexpected end of file")
+		case "{":
+			depth++
+		case "}":
+			depth--
+		case ";":
+			if depth == 0 {
+				p.next()
+				return
+			}
+		}
+		p.next()
+	}
+}
+
+// skipBlock skips a declaration followed by a block.
+func (p *protoParser) skipBlock() {
+	for p.tok != "{" {
+		if len(p.tok) == 0 {
+			p.fail("unexpected end of file")
+		}
+		p.next()
+	}
+	depth := 0
+	for {
+		switch p.tok {
+		case "":
+			p.fail("unexpected end of file")
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				p.next()
+				return
+			}
+		}
+		p.next()
+	}
+}
+
+func (p *protoParser) message(scope string) *protoMessage {
+	m := &protoMessage{name: qualify(scope, p.next()), fields: make(map[int]*protoField)}
+	p.types.messages[m.name] = m
+	p.next()
+	p.expect("{")
+	for p.tok != "}" {
+		switch p.tok {
+		case "":
+			p.fail("unexpected end of file")
+		case "message":
+			m.messages = append(m.messages, p.message(m.name))
+		case "enum":
+			p.enum(m.name)
+		case "oneof":
+			p.next()
+			p.next()
+			p.expect("{")
+			for p.tok != "}" {
+				if p.tok == "option" {
+					p.skipStatement()
+					continue
+				}
+				p.field(m)
+			}
+			p.next()
+		case "option", "reserved", "extensions":
+			p.skipStatement()
+		case "extend":
+			p.skipBlock()
+		case ";":
+			p.next()
+		default:
+			p.field(m)
+		}
+	}
+	p.next()
+	return m
+}
+
+func (p *protoParser) field(m *protoMessage) {
+	f := &protoField{scope: m.name}
+	switch p.tok {
+	case "repeated":
+		f.repeated = true
+		p.next()
+	case "optional", "required":
+		p.next()
+	}
+	if p.tok == "group" {
+		p.fail("groups are not supported")
+	}
+	f.typ = p.tok
+	if p.next() == "<" && f.typ == "map" {
+		f.key = &protoField{name: "key", number: 1, typ: p.next(), scope: m.name}
+		p.next()
+		p.expect(",")
+		f.value = &protoField{name: "value", number: 2, typ: p.tok, scope: m.name}
+		p.next()
+		p.expect(">")
+		f.repeated = true
+	}
+	f.name = p.tok
+	p.next()
+	p.expect("=")
+	f.number = p.number()
+	if p.tok == "[" {
+		p.skipStatement()
+	} else {
+		p.expect(";")
+	}
+	m.fields[f.number] = f
+}
+
+func (p *protoParser) enum(scope string) {
+	e := &protoEnum{name: qualify(scope, p.next()), values: make(map[int32]string)}
+	p.types.enums[e.name] = e
+	p.next()
+	p.expect("{")
+	for p.tok != "}" {
+		switch p.tok {
+		case "":
+			p.fail("unexpected end of file")
+		case "option", "reserved":
+			p.skipStatement()
+		case ";":
+			p.next()
+		default:
+			name := p.tok
+			p.next()
+			p.expect("=")
+			number := int32(p.number())
+			if _, ok := e.values[number]; !ok {
+				e.values[number] = name
+			}
+			if p.tok == "[" {
+				p.skipStatement()
+			} else {
+				p.expect(";")
+			}
+		}
+	}
+	p.next()
+}
+
+func qualify(scope, name string) string {
+	if len(scope) == 0 {
+		return name
+	}
+	return scope + "." + name
+}
+
+// protobufDecoder parses a Protobuf schema and the schemas it references.
+// Payloads start with the indexes of the message type in the schema.
+func (d *Decoder) protobufDecoder(ctx context.Context, schema Schema) (payloadDecoder, error) {
+	types := newProtoTypes()
+	if err := d.loadProtoReferences(ctx, schema.References, types, make(map[Reference]bool)); err != nil {
+		return nil, err
+	}
+	messages, err := parseProto(schema.Schema, types)
+	if err != nil {
+		return nil, err
+	}
+	types.link()
+	return func(payload []byte) ([]byte, error) {
+		m, payload, err := protoMessageIndexes(messages, payload)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeProto(m, payload)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(value)
+	}, nil
+}
+
+func (d *Decoder) loadProtoReferences(ctx context.Context, refs []Reference, types *protoTypes, loaded map[Reference]bool) error {
+	for _, ref := range refs {
+		if loaded[ref] {
+			continue
+		}
+		loaded[ref] = true
+		schema, err := d.Registry.SchemaBySubject(ctx, ref.Subject, ref.Version)
+		if err != nil {
+			return fmt.Errorf("reference %s: %w", ref.Name, err)
+		}
+		if err := d.loadProtoReferences(ctx, schema.References, types, loaded); err != nil {
+			return err
+		}
+		if _, err := parseProto(schema.Schema, types); err != nil {
+			return fmt.Errorf("reference %s: %w", ref.Name, err)
+		}
+	}
+	return nil
+}
+
+// protoMessageIndexes reads the message indexes preceding a Protobuf
+// payload, a count followed by the index of the message at each nesting
+// level, and returns the message they select. A single zero stands for the
+// first message of the schema.
+func protoMessageIndexes(messages []*protoMessage, payload []byte) (*protoMessage, []byte, error) {
+	count, n := binary.Varint(payload)
+	if n <= 0 || count < 0 {
+		return nil, nil, errors.New("invalid message indexes")
+	}
+	payload = payload[n:]
+	indexes := []int64{0}
+	if count > 0 {
+		indexes = indexes[:0]
+		for i := int64(0); i < count; i++ {
+			index, n := binary.Varint(payload)
+			if n <= 0 {
+				return nil, nil, errors.New("invalid message indexes")
+			}
+			indexes = append(indexes, index)
+			payload = payload[n:]
+		}
+	}
+	var m *protoMessage
+	for _, index := range indexes {
+		if index < 0 || index >= int64(len(messages)) {
+			return nil, nil, fmt.Errorf("message index %d out of range", index)
+		}
+		m = messages[index]
+		messages = m.messages
+	}
+	return m, payload, nil
+}
+
+// jsonObject is a JSON object keeping the order of its members.
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *jsonObject) set(key string, value interface{}) {
+	if o.values == nil {
+		o.values = make(map[string]interface{})
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// unknownMessage decodes messages whose type is not known, keying their
+// fields by number.
+var unknownMessage = &protoMessage{}
+
+// decodeProto decodes a message to an object keyed by field name. Fields
+// missing from the message are left out, unknown fields are keyed by their
+// number.
+func decodeProto(m *protoMessage, b []byte) (*jsonObject, error) {
+	if m == nil {
+		m = unknownMessage
+	}
+	obj := &jsonObject{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("invalid field tag")
+		}
+		b = b[n:]
+		number, wireType := int(tag>>3), int(tag&7)
+		raw, varint, rest, err := protoWireValue(wireType, b)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", number, err)
+		}
+		b = rest
+
+		f, ok := m.fields[number]
+		if !ok {
+			var value interface{} = varint
+			if wireType == 2 {
+				value = raw
+			}
+			obj.set(strconv.Itoa(number), value)
+			continue
+		}
+		var values []interface{}
+		switch {
+		case f.key != nil && wireType == 2:
+			entry, err := decodeProtoMapEntry(f, raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.name, err)
+			}
+			current, _ := obj.values[f.name].(*jsonObject)
+			if current == nil {
+				current = &jsonObject{}
+			}
+			current.set(entry.key, entry.value)
+			obj.set(f.name, current)
+			continue
+		case wireType == 2 && f.packable():
+			for len(raw) > 0 {
+				elemRaw, elemVarint, rest, err := protoWireValue(f.wireType(), raw)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", f.name, err)
+				}
+				raw = rest
+				value, err := protoValue(f, elemRaw, elemVarint)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", f.name, err)
+				}
+				values = append(values, value)
+			}
+		default:
+			if wireType != f.wireType() {
+				return nil, fmt.Errorf("%s: wire type %d for %s", f.name, wireType, f.typ)
+			}
+			value, err := protoValue(f, raw, varint)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.name, err)
+			}
+			values = append(values, value)
+		}
+		if !f.repeated {
+			obj.set(f.name, values[len(values)-1])
+			continue
+		}
+		current, _ := obj.values[f.name].([]interface{})
+		obj.set(f.name, append(current, values...))
+	}
+	return obj, nil
+}
+
+type protoMapEntry struct {
+	key   string
+	value interface{}
+}
+
+func decodeProtoMapEntry(f *protoField, b []byte) (protoMapEntry, error) {
+	entry := &protoMessage{fields: map[int]*protoField{1: f.key, 2: f.value}}
+	obj, err := decodeProto(entry, b)
+	if err != nil {
+		return protoMapEntry{}, err
+	}
+	var e protoMapEntry
+	if key, ok := obj.values["key"]; ok {
+		e.key = fmt.Sprint(key)
+	} else if f.key.typ == "bool" {
+		e.key = "false"
+	} else if f.key.typ != "string" {
+		e.key = "0"
+	}
+	e.value = obj.values["value"]
+	return e, nil
+}
+
+// protoWireValue reads a value of the given wire type, returning the bytes
+// of length delimited values and the number of the others.
+func protoWireValue(wireType int, b []byte) ([]byte, uint64, []byte, error) {
+	switch wireType {
+	case 0:
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, 0, nil, errors.New("invalid varint")
+		}
+		return nil, v, b[n:], nil
+	case 1:
+		if len(b) < 8 {
+			return nil, 0, nil, errors.New("truncated fixed64")
+		}
+		return nil, binary.LittleEndian.Uint64(b), b[8:], nil
+	case 2:
+		size, n := binary.Uvarint(b)
+		if n <= 0 || size > uint64(len(b)-n) {
+			return nil, 0, nil, errors.New("invalid length")
+		}
+		return b[n : n+int(size)], 0, b[n+int(size):], nil
+	case 5:
+		if len(b) < 4 {
+			return nil, 0, nil, errors.New("truncated fixed32")
+		}
+		return nil, uint64(binary.LittleEndian.Uint32(b)), b[4:], nil
+	}
+	return nil, 0, nil, fmt.Errorf("unsupported wire type %d", wireType)
+}
+
+// wireType returns the wire type of the values of the field.
+func (f *protoField) wireType() int {
+	if f.enum != nil {
+		return 0
+	}
+	switch f.typ {
+	case "double", "fixed64", "sfixed64":
+		return 1
+	case "float", "fixed32", "sfixed32":
+		return 5
+	case "int32", "int64", "uint32", "uint64", "sint32", "sint64", "bool":
+		return 0
+	}
+	return 2
+}
+
+// packable reports whether repeated values of the field can be packed in a
+// single length delimited value.
+func (f *protoField) packable() bool {
+	return f.enum != nil || (protoScalar(f.typ) && f.wireType() != 2)
+}
+
+func protoValue(f *protoField, raw []byte, v uint64) (interface{}, error) {
+	if f.enum != nil {
+		if name, ok := f.enum.values[int32(v)]; ok {
+			return name, nil
+		}
+		return int32(v), nil
+	}
+	switch f.typ {
+	case "int32":
+		return int32(v), nil
+	case "int64":
+		return int64(v), nil
+	case "uint32":
+		return uint32(v), nil
+	case "uint64", "fixed64":
+		return v, nil
+	case "sint32":
+		return int32(uint32(v)>>1) ^ -int32(v&1), nil
+	case "sint64":
+		return int64(v>>1) ^ -int64(v&1), nil
+	case "bool":
+		return v != 0, nil
+	case "fixed32":
+		return uint32(v), nil
+	case "sfixed32":
+		return int32(v), nil
+	case "sfixed64":
+		return int64(v), nil
+	case "float":
+		return protoFloat(float64(math.Float32frombits(uint32(v)))), nil
+	case "double":
+		return protoFloat(math.Float64frombits(v)), nil
+	case "string":
+		return string(raw), nil
+	case "bytes":
+		return raw, nil
+	}
+	return decodeProto(f.message, raw)
+}
+
+// protoFloat returns non-finite numbers as strings, which JSON lacks.
+func protoFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
@@ -0,0 +1,301 @@
+package schemaregistry
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const ordersProto = `
+// orders.proto
+syntax = "proto3";
+package shop.v1;
+
+import "google/protobuf/timestamp.proto";
+import public "common.proto";
+option go_package = "example.com/shop;shop";
+option (custom) = { a: 1; b: "}" };
+
+/* Status of an order,
+   aliases keep the first name. */
+enum Status {
+  option allow_alias = true;
+  UNKNOWN = 0;
+  PLACED = 1;
+  ORDERED = 1 [deprecated = true];
+  reserved 5 to 9;
+}
+
+message Order {
+  message Line {
+    string sku = 1;
+    uint32 quantity = 2;
+    Price price = 3;
+  }
+  enum Channel { WEB = 0; STORE = -1; }
+  reserved 4, 20 to 30;
+  reserved "old";
+  option deprecated = false;
+
+  string id = 1;
+  repeated Line lines = 2;
+  Status status = 3;
+  map<string, Line> by_sku = 5;
+  oneof payment {
+    option (required) = true;
+    string card = 6;
+    bytes voucher = 7 [json_name = "v"];
+  }
+  optional sint64 delta = 8;
+  repeated int32 codes = 9 [packed = true];
+  .shop.v1.Order.Channel channel = 10;
+  google.protobuf.Timestamp created = 11;
+  double amount = 12;
+  float ratio = 13;
+  fixed64 big = 0x0e;
+  extend Other { string note = 100; }
+  ;
+}
+
+message Price {
+  int64 cents = 1;
+  string currency = 2;
+}
+
+service Orders {
+  rpc Get (Order) returns (Order) { option idempotency_level = NO_SIDE_EFFECTS; }
+}
+
+extend Price {
+  string memo = 50;
+}
+`
+
+func TestParseProto(t *testing.T) {
+	types := newProtoTypes()
+	messages, err := parseProto(ordersProto, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types.link()
+
+	var names []string
+	for _, m := range messages {
+		names = append(names, m.name)
+	}
+	if want := []string{"shop.v1.Order", "shop.v1.Price"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("top-level messages %v, want %v", names, want)
+	}
+	var all []string
+	for name := range types.messages {
+		all = append(all, name)
+	}
+	sort.Strings(all)
+	if want := []string{"shop.v1.Order", "shop.v1.Order.Line", "shop.v1.Price"}; !reflect.DeepEqual(all, want) {
+		t.Errorf("messages %v, want %v", all, want)
+	}
+
+	status := types.enums["shop.v1.Status"]
+	if status == nil || !reflect.DeepEqual(status.values, map[int32]string{0: "UNKNOWN", 1: "PLACED"}) {
+		t.Errorf("enum Status parsed as %+v", status)
+	}
+	channel := types.enums["shop.v1.Order.Channel"]
+	if channel == nil || !reflect.DeepEqual(channel.values, map[int32]string{0: "WEB", -1: "STORE"}) {
+		t.Errorf("enum Order.Channel parsed as %+v", channel)
+	}
+
+	order := messages[0]
+	if len(order.messages) != 1 || order.messages[0].name != "shop.v1.Order.Line" {
+		t.Errorf("nested messages of Order %v", order.messages)
+	}
+	var numbers []int
+	for number := range order.fields {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	if want := []int{1, 2, 3, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}; !reflect.DeepEqual(numbers, want) {
+		t.Errorf("fields of Order %v, want %v", numbers, want)
+	}
+	for _, tt := range []struct {
+		number   int
+		name     string
+		repeated bool
+		message  *protoMessage
+		enum     *protoEnum
+	}{
+		{1, "id", false, nil, nil},
+		{2, "lines", true, order.messages[0], nil},
+		{3, "status", false, nil, status},
+		{6, "card", false, nil, nil},
+		{7, "voucher", false, nil, nil},
+		{8, "delta", false, nil, nil},
+		{9, "codes", true, nil, nil},
+		{10, "channel", false, nil, channel},
+		// well-known types not in the schema are decoded as unknown messages
+		{11, "created", false, nil, nil},
+		{14, "big", false, nil, nil},
+	} {
+		f := order.fields[tt.number]
+		if f.name != tt.name || f.repeated != tt.repeated || f.message != tt.message || f.enum != tt.enum {
+			t.Errorf("field %d parsed as %+v", tt.number, f)
+		}
+	}
+	bySku := order.fields[5]
+	if bySku.name != "by_sku" || !bySku.repeated || bySku.key.typ != "string" || bySku.value.message != order.messages[0] {
+		t.Errorf("map field parsed as %+v, key %+v, value %+v", bySku, bySku.key, bySku.value)
+	}
+	// Price is resolved from the scope of Line up to the package
+	if price := order.messages[0].fields[3]; price.message != messages[1] {
+		t.Errorf("Line.price resolved to %+v", price.message)
+	}
+}
+
+func TestParseProtoErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src, err string
+	}{
+		{"message A {\n  /* open", "line 2: unterminated comment"},
+		{"option a = \"open;", "unterminated string"},
+		{"message A {\n  repeated group B = 1 {}\n}", "line 2: groups are not supported"},
+		{"message A { string a 1; }", `expected "="`},
+		{"message A { string a = b; }", `invalid number "b"`},
+		{"message A { string a = 1; ", "unexpected end of file"},
+		{"enum E { A = 0;", "unexpected end of file"},
+		{"package a", `expected ";"`},
+		{"rpc A;", `unexpected "rpc"`},
+		{"service S", "unexpected end of file"},
+	} {
+		_, err := parseProto(tt.src, newProtoTypes())
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parsing %q: %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+// protoTag and the helpers below encode fields of a Protobuf message.
+func protoTag(number, wireType int) []byte {
+	return protoVarint(uint64(number)<<3 | uint64(wireType))
+}
+
+func protoVarint(v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutUvarint(b, v)]
+}
+
+func protoBytes(number int, b []byte) []byte {
+	return append(append(protoTag(number, 2), protoVarint(uint64(len(b)))...), b...)
+}
+
+func protoUint(number int, v uint64) []byte {
+	return append(protoTag(number, 0), protoVarint(v)...)
+}
+
+func protoConcat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+// writeSchema writes a schema to the registry directory dir at path.
+func writeSchema(t *testing.T, dir, path string, schema Schema) {
+	t.Helper()
+	data, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, filepath.FromSlash(path)+".json")
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wirePayload prefixes a payload with the wire format header of schema id.
+func wirePayload(id int, payload []byte) []byte {
+	header := make([]byte, wireHeaderSize)
+	binary.BigEndian.PutUint32(header[1:], uint32(id))
+	return append(header, payload...)
+}
+
+func TestProtobufDecoder(t *testing.T) {
+	dir := t.TempDir()
+	writeSchema(t, dir, "subjects/price/versions/1", Schema{Type: TypeProtobuf, Schema: `
+syntax = "proto3";
+package shop.v1;
+message Price { int64 cents = 1; string currency = 2; }
+`})
+	writeSchema(t, dir, "schemas/ids/1", Schema{
+		Type:       TypeProtobuf,
+		Schema:     strings.Replace(ordersProto, "message Price {", "message Unused {", 1),
+		References: []Reference{{Name: "price.proto", Subject: "price", Version: 1}},
+	})
+	d := NewDecoder(NewRegistry("", dir, ""))
+
+	price := protoConcat(protoUint(1, 1250), protoBytes(2, []byte("EUR")))
+	line := protoConcat(protoBytes(1, []byte("A-1")), protoUint(2, 3), protoBytes(3, price))
+	amount := make([]byte, 8)
+	binary.LittleEndian.PutUint64(amount, math.Float64bits(12.5))
+	ratio := make([]byte, 4)
+	binary.LittleEndian.PutUint32(ratio, math.Float32bits(float32(math.Inf(1))))
+	order := protoConcat(
+		protoBytes(1, []byte("o-1")),
+		protoBytes(2, line),
+		protoBytes(2, line),
+		protoUint(3, 1),
+		protoBytes(5, protoConcat(protoBytes(1, []byte("A-1")), protoBytes(2, line))),
+		protoBytes(5, protoBytes(2, line)),
+		protoUint(8, 5), // -3 zigzag encoded
+		protoBytes(9, protoConcat(protoVarint(1), protoVarint(2))),
+		protoUint(9, 3),
+		protoUint(10, math.MaxUint64), // STORE, -1
+		protoBytes(11, protoUint(1, 1653609600)),
+		append(protoTag(12, 1), amount...),
+		append(protoTag(13, 5), ratio...),
+		protoUint(99, 42),
+	)
+	// a single zero selects the first message, Order
+	decoded, ok, err := d.Decode(context.Background(), wirePayload(1, append([]byte{0}, order...)))
+	if err != nil || !ok {
+		t.Fatalf("decoding: %v, %v", ok, err)
+	}
+	lineJSON := `{"sku":"A-1","quantity":3,"price":{"cents":1250,"currency":"EUR"}}`
+	want := `{"id":"o-1","lines":[` + lineJSON + `,` + lineJSON + `],"status":"PLACED",` +
+		`"by_sku":{"A-1":` + lineJSON + `,"":` + lineJSON + `},"delta":-3,"codes":[1,2,3],` +
+		`"channel":"STORE","created":{"1":1653609600},"amount":12.5,"ratio":"Infinity","99":42}`
+	if string(decoded) != want {
+		t.Errorf("decoded\n%s\nwant\n%s", decoded, want)
+	}
+
+	// indexes 0, 0 select the first message nested in the first, Order.Line
+	indexes := protoConcat(binaryVarint(0), binaryVarint(0))
+	decoded, ok, err = d.Decode(context.Background(), wirePayload(1, protoConcat(binaryVarint(2), indexes, line)))
+	if err != nil || !ok || string(decoded) != lineJSON {
+		t.Errorf("decoding a nested message: %s, %v, %v", decoded, ok, err)
+	}
+
+	for _, payload := range [][]byte{
+		protoConcat(binaryVarint(1), binaryVarint(5), line),    // message index out of range
+		append([]byte{0}, protoBytes(1, []byte("o-1"))[:3]...), // truncated string
+		append([]byte{0}, protoUint(1, 7)...),                  // wire type of an int for a string
+	} {
+		if _, _, err := d.Decode(context.Background(), wirePayload(1, payload)); err == nil {
+			t.Errorf("payload %x decoded", payload)
+		}
+	}
+}
+
+func binaryVarint(v int64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutVarint(b, v)]
+}
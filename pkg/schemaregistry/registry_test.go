@@ -0,0 +1,63 @@
+package schemaregistry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestRegistry serves schema id 1 as schema, counting the requests made.
+func newTestRegistry(t *testing.T, schema string) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/schemas/ids/1" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(Schema{Type: TypeJSON, Schema: schema})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRegistryCachePerURL(t *testing.T) {
+	ctx := context.Background()
+	cache := t.TempDir()
+	a, aRequests := newTestRegistry(t, `{"title":"a"}`)
+	b, bRequests := newTestRegistry(t, `{"title":"b"}`)
+
+	for _, tt := range []struct {
+		url, want string
+	}{
+		{a.URL, `{"title":"a"}`},
+		{b.URL, `{"title":"b"}`},
+		// read from the cache of each registry by new registries
+		{a.URL, `{"title":"a"}`},
+		{b.URL + "/", `{"title":"b"}`},
+		// credentials do not change the registry
+		{strings.Replace(a.URL, "http://", "http://user:secret@", 1), `{"title":"a"}`},
+	} {
+		schema, err := NewRegistry(tt.url, "", cache).SchemaByID(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if schema.Schema != tt.want {
+			t.Errorf("schema 1 of %s is %s, want %s", tt.url, schema.Schema, tt.want)
+		}
+	}
+	if *aRequests != 1 || *bRequests != 1 {
+		t.Errorf("%d and %d requests, want one per registry", *aRequests, *bRequests)
+	}
+
+	// offline, the cache of no registry applies
+	if _, err := NewRegistry("", "", cache).SchemaByID(ctx, 1); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("offline lookup: %v, want %v", err, ErrSchemaNotFound)
+	}
+}
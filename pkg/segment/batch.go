@@ -0,0 +1,199 @@
+// Package segment decodes Redpanda log segments, as uploaded to the bucket by
+// shadow indexing, into record batches and records.
+package segment
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// HeaderSize is the size of a record batch header as stored in a segment.
+const HeaderSize = 61
+
+var (
+	// ErrTruncated is returned when a segment ends in the middle of a batch.
+	ErrTruncated = errors.New("segment truncated")
+	// ErrCorrupted is returned when a batch header is invalid.
+	ErrCorrupted = errors.New("segment corrupted")
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// BatchType is the type of a record batch. Only raft_data batches hold
+// records produced by Kafka clients, the others are internal to Redpanda.
+type BatchType int8
+
+var batchTypeNames = map[BatchType]string{
+	1:  "raft_data",
+	2:  "raft_configuration",
+	3:  "controller",
+	4:  "kvstore",
+	5:  "checkpoint",
+	6:  "topic_management_cmd",
+	7:  "ghost_batch",
+	8:  "id_allocator",
+	9:  "tx_prepare",
+	10: "tx_fence",
+	11: "tm_update",
+	12: "user_management_cmd",
+	13: "acl_management_cmd",
+	14: "group_prepare_tx",
+	15: "group_commit_tx",
+	16: "group_abort_tx",
+	17: "node_management_cmd",
+	18: "data_policy_management_cmd",
+	19: "archival_metadata",
+	20: "cluster_config_cmd",
+	21: "feature_update",
+}
+
+// BatchTypeRaftData is the type of batches holding Kafka records.
+const BatchTypeRaftData BatchType = 1
+
+func (t BatchType) String() string {
+	if name, ok := batchTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int8(t))
+}
+
+// Compression is the codec used for the records of a batch.
+type Compression int8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionSnappy
+	CompressionLZ4
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionLZ4:
+		return "lz4"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("unknown(%d)", int8(c))
+}
+
+// Attributes are the Kafka batch attributes.
+type Attributes int16
+
+// Compression returns the codec of the batch records.
+func (a Attributes) Compression() Compression {
+	return Compression(a & 0x7)
+}
+
+// LogAppendTime reports whether timestamps were set by the broker rather
+// than the producer.
+func (a Attributes) LogAppendTime() bool {
+	return a&0x8 != 0
+}
+
+// Transactional reports whether the batch is part of a transaction.
+func (a Attributes) Transactional() bool {
+	return a&0x10 != 0
+}
+
+// Control reports whether the batch holds a transaction control record.
+func (a Attributes) Control() bool {
+	return a&0x20 != 0
+}
+
+// Header is a record batch header. Redpanda stores it little endian, with a
+// CRC of its own, in front of the Kafka encoded records.
+type Header struct {
+	HeaderCRC       uint32
+	SizeBytes       int32
+	BaseOffset      int64
+	Type            BatchType
+	CRC             uint32
+	Attributes      Attributes
+	LastOffsetDelta int32
+	FirstTimestamp  int64
+	MaxTimestamp    int64
+	ProducerID      int64
+	ProducerEpoch   int16
+	BaseSequence    int32
+	RecordCount     int32
+}
+
+// ParseHeader decodes a batch header from the first HeaderSize bytes of b.
+func ParseHeader(b []byte) (Header, error) {
+	if len(b) < HeaderSize {
+		return Header{}, ErrTruncated
+	}
+	h := Header{
+		HeaderCRC:       binary.LittleEndian.Uint32(b[0:]),
+		SizeBytes:       int32(binary.LittleEndian.Uint32(b[4:])),
+		BaseOffset:      int64(binary.LittleEndian.Uint64(b[8:])),
+		Type:            BatchType(b[16]),
+		CRC:             binary.LittleEndian.Uint32(b[17:]),
+		Attributes:      Attributes(binary.LittleEndian.Uint16(b[21:])),
+		LastOffsetDelta: int32(binary.LittleEndian.Uint32(b[23:])),
+		FirstTimestamp:  int64(binary.LittleEndian.Uint64(b[27:])),
+		MaxTimestamp:    int64(binary.LittleEndian.Uint64(b[35:])),
+		ProducerID:      int64(binary.LittleEndian.Uint64(b[43:])),
+		ProducerEpoch:   int16(binary.LittleEndian.Uint16(b[51:])),
+		BaseSequence:    int32(binary.LittleEndian.Uint32(b[53:])),
+		RecordCount:     int32(binary.LittleEndian.Uint32(b[57:])),
+	}
+	if h.SizeBytes < HeaderSize || h.LastOffsetDelta < 0 || h.RecordCount < 0 {
+		return h, fmt.Errorf("%w: invalid batch header at offset %d (size %d, last offset delta %d, records %d)",
+			ErrCorrupted, h.BaseOffset, h.SizeBytes, h.LastOffsetDelta, h.RecordCount)
+	}
+	return h, nil
+}
+
+// LastOffset returns the offset of the last record of the batch.
+func (h Header) LastOffset() int64 {
+	return h.BaseOffset + int64(h.LastOffsetDelta)
+}
+
+// HeaderCRCValid reports whether the header CRC matches the raw header it was
+// parsed from.
+func HeaderCRCValid(h Header, raw []byte) bool {
+	return len(raw) >= HeaderSize && crc32.Checksum(raw[4:HeaderSize], crc32c) == h.HeaderCRC
+}
+
+// Batch is a record batch with its still encoded (and possibly compressed)
+// records.
+type Batch struct {
+	Header
+	// Position is the byte position of the batch in the segment.
+	Position int64
+	// HeaderCRCValid reports whether the header matched its CRC when read.
+	HeaderCRCValid bool
+	Payload        []byte
+}
+
+// PayloadCRC computes the Kafka batch CRC, a CRC32C over the big endian
+// header fields following the CRC and the records.
+func (b *Batch) PayloadCRC() uint32 {
+	var fields [40]byte
+	binary.BigEndian.PutUint16(fields[0:], uint16(b.Attributes))
+	binary.BigEndian.PutUint32(fields[2:], uint32(b.LastOffsetDelta))
+	binary.BigEndian.PutUint64(fields[6:], uint64(b.FirstTimestamp))
+	binary.BigEndian.PutUint64(fields[14:], uint64(b.MaxTimestamp))
+	binary.BigEndian.PutUint64(fields[22:], uint64(b.ProducerID))
+	binary.BigEndian.PutUint16(fields[30:], uint16(b.ProducerEpoch))
+	binary.BigEndian.PutUint32(fields[32:], uint32(b.BaseSequence))
+	binary.BigEndian.PutUint32(fields[36:], uint32(b.RecordCount))
+	crc := crc32.Update(0, crc32c, fields[:])
+	return crc32.Update(crc, crc32c, b.Payload)
+}
+
+// CRCValid reports whether the records match the batch CRC.
+func (b *Batch) CRCValid() bool {
+	return b.PayloadCRC() == b.CRC
+}
@@ -0,0 +1,170 @@
+package segment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+// encodeBatch encodes b as stored in a segment, setting its size and both
+// CRCs.
+func encodeBatch(b *Batch) []byte {
+	b.SizeBytes = int32(HeaderSize + len(b.Payload))
+	b.CRC = b.PayloadCRC()
+	raw := make([]byte, HeaderSize)
+	binary.LittleEndian.PutUint32(raw[4:], uint32(b.SizeBytes))
+	binary.LittleEndian.PutUint64(raw[8:], uint64(b.BaseOffset))
+	raw[16] = byte(b.Type)
+	binary.LittleEndian.PutUint32(raw[17:], b.CRC)
+	binary.LittleEndian.PutUint16(raw[21:], uint16(b.Attributes))
+	binary.LittleEndian.PutUint32(raw[23:], uint32(b.LastOffsetDelta))
+	binary.LittleEndian.PutUint64(raw[27:], uint64(b.FirstTimestamp))
+	binary.LittleEndian.PutUint64(raw[35:], uint64(b.MaxTimestamp))
+	binary.LittleEndian.PutUint64(raw[43:], uint64(b.ProducerID))
+	binary.LittleEndian.PutUint16(raw[51:], uint16(b.ProducerEpoch))
+	binary.LittleEndian.PutUint32(raw[53:], uint32(b.BaseSequence))
+	binary.LittleEndian.PutUint32(raw[57:], uint32(b.RecordCount))
+	b.HeaderCRC = crc32.Checksum(raw[4:], crc32c)
+	binary.LittleEndian.PutUint32(raw[0:], b.HeaderCRC)
+	return append(raw, b.Payload...)
+}
+
+func TestReader(t *testing.T) {
+	want := fixtureBatch(t, "records.gzip", CompressionGzip)
+	good := encodeBatch(want)
+	// a batch of another type follows the first
+	second := fixtureBatch(t, "records.none", CompressionNone)
+	second.BaseOffset = 1300
+	second.Type = 19
+	segment := append(append([]byte(nil), good...), encodeBatch(second)...)
+
+	r := NewReader(bytes.NewReader(segment))
+	batch, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !batch.HeaderCRCValid || !batch.CRCValid() {
+		t.Errorf("CRCs of a good batch: header %v, records %v", batch.HeaderCRCValid, batch.CRCValid())
+	}
+	if batch.Header != want.Header || batch.Position != 0 || !bytes.Equal(batch.Payload, want.Payload) {
+		t.Errorf("batch read as %+v at %d", batch.Header, batch.Position)
+	}
+	if batch.LastOffset() != 1299 || batch.Attributes.Compression() != CompressionGzip {
+		t.Errorf("last offset %d, compression %s", batch.LastOffset(), batch.Attributes.Compression())
+	}
+	batch, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Position != int64(len(good)) || batch.BaseOffset != 1300 || batch.Type.String() != "archival_metadata" {
+		t.Errorf("second batch read as %+v at %d", batch.Header, batch.Position)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("after the last batch: %v, want EOF", err)
+	}
+	if r.Position() != int64(len(segment)) {
+		t.Errorf("position %d, want %d", r.Position(), len(segment))
+	}
+}
+
+func TestReaderDamagedBatches(t *testing.T) {
+	good := encodeBatch(fixtureBatch(t, "records.none", CompressionNone))
+	damage := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+	setSize := func(size uint32) []byte {
+		return damage(func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[4:], size)
+			return b
+		})
+	}
+	for _, tt := range []struct {
+		name       string
+		data       []byte
+		err        error
+		headerCRC  bool
+		recordsCRC bool
+	}{
+		{"good", good, nil, true, true},
+		// the max timestamp is covered by both CRCs
+		{"header field", damage(func(b []byte) []byte { b[40] ^= 1; return b }), nil, false, false},
+		{"header crc", damage(func(b []byte) []byte { b[0] ^= 1; return b }), nil, false, true},
+		{"payload crc", damage(func(b []byte) []byte { b[len(b)-1] ^= 1; return b }), nil, true, false},
+		{"record crc field", damage(func(b []byte) []byte { b[17] ^= 1; return b }), nil, false, false},
+		{"truncated header", good[:HeaderSize-1], ErrTruncated, false, false},
+		{"truncated payload", good[:len(good)-1], ErrTruncated, false, false},
+		{"size below the header", setSize(HeaderSize - 1), ErrCorrupted, false, false},
+		{"size above the maximum", setSize(maxBatchSize + 1), ErrCorrupted, false, false},
+		{"negative size", setSize(0xffffffff), ErrCorrupted, false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, err := NewReader(bytes.NewReader(tt.data)).Next()
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("reading: %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if batch.HeaderCRCValid != tt.headerCRC || batch.CRCValid() != tt.recordsCRC {
+				t.Errorf("CRCs valid: header %v, records %v, want %v, %v", batch.HeaderCRCValid, batch.CRCValid(), tt.headerCRC, tt.recordsCRC)
+			}
+		})
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	good := encodeBatch(fixtureBatch(t, "records.none", CompressionNone))
+	h, err := ParseHeader(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HeaderCRCValid(h, good) || HeaderCRCValid(h, good[:HeaderSize-1]) {
+		t.Error("HeaderCRCValid does not check the raw header")
+	}
+	if _, err := ParseHeader(good[:HeaderSize-1]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("short header: %v, want %v", err, ErrTruncated)
+	}
+	for _, tt := range []struct {
+		name   string
+		offset int
+		value  uint32
+	}{
+		{"last offset delta", 23, 0xffffffff},
+		{"record count", 57, 0xffffffff},
+	} {
+		b := append([]byte(nil), good...)
+		binary.LittleEndian.PutUint32(b[tt.offset:], tt.value)
+		if _, err := ParseHeader(b); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("negative %s: %v, want %v", tt.name, err, ErrCorrupted)
+		}
+	}
+}
+
+func TestControlType(t *testing.T) {
+	for _, want := range []ControlType{ControlAbort, ControlCommit} {
+		// a record with the key version 0 and type want, and a value holding
+		// a version and a coordinator epoch
+		record := []byte{0, 0, 0, 8, 0, 0, 0, byte(want), 12, 0, 0, 0, 0, 0, 0, 0}
+		b := &Batch{Header: Header{BaseOffset: 42, Type: BatchTypeRaftData, Attributes: 0x30, RecordCount: 1}}
+		b.Payload = append([]byte{byte(len(record) * 2)}, record...)
+		got, err := b.ControlType()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("control type %d, want %d", got, want)
+		}
+	}
+
+	// a record with a null key and value
+	null := &Batch{Header: Header{BaseOffset: 42, Attributes: 0x30, RecordCount: 1}, Payload: []byte{12, 0, 0, 0, 1, 1, 0}}
+	if _, err := null.ControlType(); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("control record without a key: %v, want %v", err, ErrCorrupted)
+	}
+}
@@ -0,0 +1,87 @@
+package segment
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"sync"
+)
+
+// decompress returns the records of a batch compressed with codec c.
+func decompress(c Compression, payload []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return payload, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case CompressionSnappy:
+		return decodeSnappy(payload)
+	case CompressionLZ4:
+		return decodeLZ4Frames(payload)
+	case CompressionZstd:
+		decoder, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return decoder.DecodeAll(payload, nil)
+	}
+	return nil, fmt.Errorf("unsupported compression %s", c)
+}
+
+var (
+	zstdOnce sync.Once
+	zstdDec  *zstd.Decoder
+	zstdErr  error
+)
+
+// zstdDecoder returns a decoder shared by all batches, DecodeAll can be used
+// concurrently.
+func zstdDecoder() (*zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdDec, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdDec, zstdErr
+}
+
+// xerialHeader starts snappy payloads framed by the Java xerial library,
+// which the Java client uses.
+var xerialHeader = []byte{0x82, 'S', 'N', 'A', 'P', 'P', 'Y', 0}
+
+// decodeSnappy decodes a raw snappy block or xerial framed snappy blocks.
+func decodeSnappy(payload []byte) ([]byte, error) {
+	if !bytes.HasPrefix(payload, xerialHeader) {
+		return snappy.Decode(nil, payload)
+	}
+	// magic, version and compatible version
+	if len(payload) < len(xerialHeader)+8 {
+		return nil, fmt.Errorf("%w: truncated xerial snappy header", ErrCorrupted)
+	}
+	payload = payload[len(xerialHeader)+8:]
+	var out []byte
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return nil, fmt.Errorf("%w: truncated xerial snappy chunk", ErrCorrupted)
+		}
+		size := binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+		if uint64(size) > uint64(len(payload)) {
+			return nil, fmt.Errorf("%w: truncated xerial snappy chunk", ErrCorrupted)
+		}
+		chunk, err := snappy.Decode(nil, payload[:size])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, chunk...)
+		payload = payload[size:]
+	}
+	return out, nil
+}
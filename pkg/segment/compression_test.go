@@ -0,0 +1,153 @@
+package segment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The fixtures in testdata hold the same 300 records, encoded by a script
+// independent of this package, then compressed with:
+//
+//	records.none         nothing
+//	records.gzip         gzip -9 -n
+//	records.snappy       a raw snappy block (github.com/golang/snappy)
+//	records.xerial       snappy blocks of 16KiB framed as by snappy-java
+//	records.lz4          lz4 (frame of independent blocks, content checksum)
+//	records.linked.lz4   lz4 -B4096 -BD -BX --content-size (linked 4KiB
+//	                     blocks with block checksums and the content size)
+//	records.zstd         zstd -19
+const fixtureRecords = 300
+
+// fixtureRecord returns the i-th record of the fixtures, in a batch starting
+// at offset 1000 and timestamp 1653609600000.
+func fixtureRecord(i int) Record {
+	record := Record{
+		Offset:    1000 + int64(i),
+		Timestamp: 1653609600000 + int64(i),
+		Key:       []byte(fmt.Sprintf("key-%d", i)),
+		Value:     append([]byte(fmt.Sprintf("value-%d-", i)), bytes.Repeat([]byte("abcdefgh"), i%50)...),
+	}
+	if i%10 == 9 {
+		record.Key = nil
+	}
+	if i%7 == 0 {
+		record.Headers = append(record.Headers, RecordHeader{Key: "trace", Value: []byte(fmt.Sprintf("t%d", i))})
+	}
+	if i%11 == 0 {
+		record.Headers = append(record.Headers, RecordHeader{Key: "null"})
+	}
+	return record
+}
+
+func fixtureBatch(t *testing.T, name string, c Compression) *Batch {
+	t.Helper()
+	payload, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Batch{
+		Header: Header{
+			BaseOffset:      1000,
+			Type:            BatchTypeRaftData,
+			Attributes:      Attributes(c),
+			LastOffsetDelta: fixtureRecords - 1,
+			FirstTimestamp:  1653609600000,
+			MaxTimestamp:    1653609600000 + fixtureRecords - 1,
+			RecordCount:     fixtureRecords,
+		},
+		Payload: payload,
+	}
+}
+
+func TestRecordsCompression(t *testing.T) {
+	want := make([]Record, fixtureRecords)
+	for i := range want {
+		want[i] = fixtureRecord(i)
+	}
+	for _, tt := range []struct {
+		name string
+		c    Compression
+	}{
+		{"records.none", CompressionNone},
+		{"records.gzip", CompressionGzip},
+		{"records.snappy", CompressionSnappy},
+		{"records.xerial", CompressionSnappy},
+		{"records.lz4", CompressionLZ4},
+		{"records.linked.lz4", CompressionLZ4},
+		{"records.zstd", CompressionZstd},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := fixtureBatch(t, tt.name, tt.c).Records()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(want) {
+				t.Fatalf("%d records, want %d", len(records), len(want))
+			}
+			for i := range records {
+				if !reflect.DeepEqual(records[i], want[i]) {
+					t.Fatalf("record %d decoded as %+v, want %+v", i, records[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecompressCorrupted(t *testing.T) {
+	lz4, err := os.ReadFile(filepath.Join("testdata", "records.linked.lz4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xerial, err := os.ReadFile(filepath.Join("testdata", "records.xerial"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		c       Compression
+		payload []byte
+	}{
+		{"xerial header only", CompressionSnappy, xerialHeader},
+		{"xerial without versions", CompressionSnappy, append(append([]byte(nil), xerialHeader...), 0, 0, 0, 1)},
+		{"xerial truncated chunk", CompressionSnappy, xerial[:len(xerial)-10]},
+		{"lz4 magic only", CompressionLZ4, lz4[:4]},
+		{"lz4 truncated frame", CompressionLZ4, lz4[:len(lz4)/2]},
+		{"lz4 without end mark", CompressionLZ4, lz4[:len(lz4)-8]},
+		{"lz4 bad magic", CompressionLZ4, append([]byte{0, 0, 0, 0}, lz4[4:]...)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decompress(tt.c, tt.payload); !errors.Is(err, ErrCorrupted) {
+				t.Errorf("decompress: %v, want %v", err, ErrCorrupted)
+			}
+		})
+	}
+}
+
+func TestDecodeLZ4Block(t *testing.T) {
+	// "abc" as literals followed by a match of 9 bytes at offset 3
+	block := []byte{0x35, 'a', 'b', 'c', 3, 0, 0x10, 'z'}
+	got, err := decodeLZ4Block(nil, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "abcabcabcabcz"; string(got) != want {
+		t.Errorf("decoded %q, want %q", got, want)
+	}
+	for _, block := range [][]byte{
+		{0x30, 'a', 'b', 'c', 4, 0}, // offset before the start
+		{0x30, 'a', 'b', 'c', 0, 0}, // zero offset
+		{0xF0, 255},                 // literal length past the end
+		{0x30, 'a', 'b'},            // literals past the end
+		{0x3F, 'a', 'b', 'c', 1, 0}, // match length past the end
+		{0x30, 'a', 'b', 'c', 1},    // truncated offset
+	} {
+		if _, err := decodeLZ4Block(nil, block); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("decodeLZ4Block(%x): %v, want %v", block, err, ErrCorrupted)
+		}
+	}
+}
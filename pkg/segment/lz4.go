@@ -0,0 +1,174 @@
+package segment
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	lz4FrameMagic     = 0x184D2204
+	lz4SkippableMagic = 0x184D2A50
+)
+
+// decodeLZ4Frames decodes the LZ4 frames (as opposed to raw LZ4 blocks) that
+// Kafka clients use for lz4 compressed batches. Checksums are not verified,
+// the batch CRC already covers the compressed data.
+func decodeLZ4Frames(data []byte) ([]byte, error) {
+	var out []byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("%w: truncated lz4 frame", ErrCorrupted)
+		}
+		magic := binary.LittleEndian.Uint32(data)
+		if magic&0xFFFFFFF0 == lz4SkippableMagic {
+			if len(data) < 8 {
+				return nil, fmt.Errorf("%w: truncated lz4 frame", ErrCorrupted)
+			}
+			size := uint64(binary.LittleEndian.Uint32(data[4:]))
+			if size > uint64(len(data)-8) {
+				return nil, fmt.Errorf("%w: truncated lz4 frame", ErrCorrupted)
+			}
+			data = data[8+size:]
+			continue
+		}
+		if magic != lz4FrameMagic {
+			return nil, fmt.Errorf("%w: invalid lz4 frame magic %#x", ErrCorrupted, magic)
+		}
+		var err error
+		out, data, err = decodeLZ4Frame(out, data[4:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// decodeLZ4Frame appends the contents of the frame at the start of data,
+// after its magic, to out and returns the data following the frame.
+func decodeLZ4Frame(out, data []byte) ([]byte, []byte, error) {
+	truncated := fmt.Errorf("%w: truncated lz4 frame", ErrCorrupted)
+	if len(data) < 3 {
+		return nil, nil, truncated
+	}
+	flags := data[0]
+	if flags>>6 != 1 {
+		return nil, nil, fmt.Errorf("%w: unsupported lz4 frame version %d", ErrCorrupted, flags>>6)
+	}
+	blockChecksum := flags&0x10 != 0
+	contentChecksum := flags&0x04 != 0
+	// flags, block descriptor and header checksum, plus the optional content
+	// size and dictionary id
+	headerSize := 3
+	if flags&0x08 != 0 {
+		headerSize += 8
+	}
+	if flags&0x01 != 0 {
+		headerSize += 4
+	}
+	if len(data) < headerSize {
+		return nil, nil, truncated
+	}
+	data = data[headerSize:]
+	for {
+		if len(data) < 4 {
+			return nil, nil, truncated
+		}
+		size := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if size == 0 {
+			break
+		}
+		uncompressed := size&0x80000000 != 0
+		size &= 0x7FFFFFFF
+		if uint64(size) > uint64(len(data)) {
+			return nil, nil, truncated
+		}
+		block := data[:size]
+		data = data[size:]
+		if uncompressed {
+			out = append(out, block...)
+		} else {
+			var err error
+			out, err = decodeLZ4Block(out, block)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if blockChecksum {
+			if len(data) < 4 {
+				return nil, nil, truncated
+			}
+			data = data[4:]
+		}
+	}
+	if contentChecksum {
+		if len(data) < 4 {
+			return nil, nil, truncated
+		}
+		data = data[4:]
+	}
+	return out, data, nil
+}
+
+// decodeLZ4Block appends a decoded LZ4 block to out. Matches may reach back
+// into previously decoded blocks of the frame.
+func decodeLZ4Block(out, block []byte) ([]byte, error) {
+	invalid := fmt.Errorf("%w: invalid lz4 block", ErrCorrupted)
+	i := 0
+	for i < len(block) {
+		token := block[i]
+		i++
+		literals := int(token >> 4)
+		if literals == 15 {
+			for {
+				if i >= len(block) {
+					return nil, invalid
+				}
+				b := block[i]
+				i++
+				literals += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if literals > len(block)-i {
+			return nil, invalid
+		}
+		out = append(out, block[i:i+literals]...)
+		i += literals
+		// the last sequence of a block only has literals
+		if i == len(block) {
+			break
+		}
+		if i+2 > len(block) {
+			return nil, invalid
+		}
+		offset := int(binary.LittleEndian.Uint16(block[i:]))
+		i += 2
+		if offset == 0 || offset > len(out) {
+			return nil, invalid
+		}
+		length := int(token & 0x0F)
+		if length == 15 {
+			for {
+				if i >= len(block) {
+					return nil, invalid
+				}
+				b := block[i]
+				i++
+				length += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		length += 4
+		// matches may overlap the bytes they produce, so copy one at a time
+		start := len(out) - offset
+		for j := 0; j < length; j++ {
+			out = append(out, out[start+j])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,67 @@
+package segment
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxBatchSize bounds the size read for a single batch, so a corrupted size
+// field can not make the reader allocate unbounded memory.
+const maxBatchSize = 1 << 30
+
+// Reader reads the record batches of a segment one at a time.
+type Reader struct {
+	r        *bufio.Reader
+	position int64
+}
+
+// NewReader returns a reader decoding the segment read from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Position returns the byte position of the next batch.
+func (r *Reader) Position() int64 {
+	return r.position
+}
+
+// Next reads the next batch. It returns io.EOF at the end of the segment and
+// an error wrapping ErrTruncated if the segment ends inside a batch.
+func (r *Reader) Next() (*Batch, error) {
+	raw := make([]byte, HeaderSize)
+	n, err := io.ReadFull(r.r, raw)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, r.readError(err, n, HeaderSize)
+	}
+	header, err := ParseHeader(raw)
+	if err != nil {
+		return nil, fmt.Errorf("at byte %d: %w", r.position, err)
+	}
+	if header.SizeBytes > maxBatchSize {
+		return nil, fmt.Errorf("at byte %d: %w: batch size %d", r.position, ErrCorrupted, header.SizeBytes)
+	}
+	batch := &Batch{
+		Header:         header,
+		Position:       r.position,
+		HeaderCRCValid: HeaderCRCValid(header, raw),
+		Payload:        make([]byte, int(header.SizeBytes)-HeaderSize),
+	}
+	n, err = io.ReadFull(r.r, batch.Payload)
+	if err != nil {
+		return nil, r.readError(err, HeaderSize+n, int(header.SizeBytes))
+	}
+	r.position += int64(header.SizeBytes)
+	return batch, nil
+}
+
+func (r *Reader) readError(err error, read, want int) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return fmt.Errorf("at byte %d: %w: read %d of %d bytes", r.position, ErrTruncated, read, want)
+	}
+	return err
+}
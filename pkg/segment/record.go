@@ -0,0 +1,128 @@
+package segment
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Record is a single Kafka record of a batch, with its offset and timestamp
+// resolved against the batch header.
+type Record struct {
+	Attributes int8
+	Offset     int64
+	Timestamp  int64
+	// Key and Value are nil for null keys and values.
+	Key     []byte
+	Value   []byte
+	Headers []RecordHeader
+}
+
+// RecordHeader is a record header. Value is nil for null values.
+type RecordHeader struct {
+	Key   string
+	Value []byte
+}
+
+// Records decompresses and decodes the records of the batch.
+func (b *Batch) Records() ([]Record, error) {
+	payload, err := decompress(b.Attributes.Compression(), b.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("batch at offset %d: %s: %w", b.BaseOffset, b.Attributes.Compression(), err)
+	}
+	d := decoder{buf: payload}
+	records := make([]Record, 0, b.RecordCount)
+	for i := int32(0); i < b.RecordCount; i++ {
+		record, err := d.record(b.Header)
+		if err != nil {
+			return nil, fmt.Errorf("batch at offset %d, record %d: %w", b.BaseOffset, i, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ControlType is the type of a transaction marker, held in the key of the
+// record of a control batch.
+type ControlType int16
+
+const (
+	ControlAbort  ControlType = 0
+	ControlCommit ControlType = 1
+)
+
+// ControlType decodes the type of the marker held by a control batch.
+func (b *Batch) ControlType() (ControlType, error) {
+	records, err := b.Records()
+	if err != nil {
+		return 0, err
+	}
+	// the key is a version and a type, both int16 big endian
+	if len(records) == 0 || len(records[0].Key) < 4 {
+		return 0, fmt.Errorf("batch at offset %d: %w: no control record key", b.BaseOffset, ErrCorrupted)
+	}
+	return ControlType(binary.BigEndian.Uint16(records[0].Key[2:])), nil
+}
+
+// decoder reads the Kafka record encoding, which uses zigzag varints.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = fmt.Errorf("%w: invalid varint", ErrCorrupted)
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) bytes(n int64) []byte {
+	if d.err != nil || n < 0 {
+		return nil
+	}
+	if n > int64(len(d.buf)) {
+		d.err = fmt.Errorf("%w: field of %d bytes exceeds the record", ErrCorrupted, n)
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) record(h Header) (Record, error) {
+	length := d.varint()
+	body := d.bytes(length)
+	if d.err != nil {
+		return Record{}, d.err
+	}
+	rd := decoder{buf: body}
+	attributes := rd.bytes(1)
+	record := Record{
+		Timestamp: h.FirstTimestamp + rd.varint(),
+		Offset:    h.BaseOffset + rd.varint(),
+		Key:       rd.bytes(rd.varint()),
+		Value:     rd.bytes(rd.varint()),
+	}
+	if len(attributes) == 1 {
+		record.Attributes = int8(attributes[0])
+	}
+	count := rd.varint()
+	for i := int64(0); i < count && rd.err == nil; i++ {
+		key := rd.bytes(rd.varint())
+		value := rd.bytes(rd.varint())
+		record.Headers = append(record.Headers, RecordHeader{Key: string(key), Value: value})
+	}
+	if rd.err != nil {
+		return Record{}, rd.err
+	}
+	if h.Attributes.LogAppendTime() {
+		record.Timestamp = h.MaxTimestamp
+	}
+	return record, nil
+}
@@ -0,0 +1,107 @@
+package segment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"rpksi/pkg/storage"
+)
+
+// DefaultReadAhead is the number of bytes a HeaderWalker requests at a time.
+const DefaultReadAhead = 4 * 1024
+
+// HeaderWalker reads the batch headers of a segment object with ranged
+// reads, hopping from header to header using the batch sizes, so batch
+// metadata can be read without downloading the records. Each request reads
+// ReadAhead bytes, so small batches following each other are read together.
+type HeaderWalker struct {
+	// ReadAhead is the size of each ranged read, at least HeaderSize.
+	ReadAhead int64
+	// Requests and BytesRead count the ranged reads made and the bytes they
+	// transferred.
+	Requests  int
+	BytesRead int64
+
+	ctx      context.Context
+	store    storage.ObjectStore
+	key      string
+	size     int64
+	position int64
+	window   []byte
+	start    int64
+}
+
+// NewHeaderWalker returns a walker over the object key of the given size.
+func NewHeaderWalker(ctx context.Context, store storage.ObjectStore, key string, size int64) *HeaderWalker {
+	return &HeaderWalker{ReadAhead: DefaultReadAhead, ctx: ctx, store: store, key: key, size: size}
+}
+
+// Next returns the next batch without its records (Payload is nil). It
+// returns io.EOF at the end of the object and an error wrapping ErrTruncated
+// if the object ends inside a batch.
+func (w *HeaderWalker) Next() (*Batch, error) {
+	if w.position >= w.size {
+		return nil, io.EOF
+	}
+	raw, err := w.read(w.position, HeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	header, err := ParseHeader(raw)
+	if err != nil {
+		return nil, fmt.Errorf("at byte %d: %w", w.position, err)
+	}
+	if w.position+int64(header.SizeBytes) > w.size {
+		return nil, fmt.Errorf("at byte %d: %w: batch of %d bytes, object is %d bytes", w.position, ErrTruncated, header.SizeBytes, w.size)
+	}
+	batch := &Batch{Header: header, Position: w.position, HeaderCRCValid: HeaderCRCValid(header, raw)}
+	w.position += int64(header.SizeBytes)
+	return batch, nil
+}
+
+// ReadBatch reads a batch returned by Next along with its records.
+func (w *HeaderWalker) ReadBatch(b *Batch) (*Batch, error) {
+	data, err := w.read(b.Position, int64(b.SizeBytes))
+	if err != nil {
+		return nil, err
+	}
+	full := *b
+	full.Payload = data[HeaderSize:]
+	return &full, nil
+}
+
+// read returns length bytes at position, from the current window if it holds
+// them, otherwise with a new ranged read.
+func (w *HeaderWalker) read(position, length int64) ([]byte, error) {
+	if position >= w.start && position+length <= w.start+int64(len(w.window)) {
+		return w.window[position-w.start : position-w.start+length], nil
+	}
+	request := length
+	if request < w.ReadAhead {
+		request = w.ReadAhead
+	}
+	if position+request > w.size {
+		request = w.size - position
+	}
+	if request < length {
+		return nil, fmt.Errorf("at byte %d: %w: read %d of %d bytes", position, ErrTruncated, request, length)
+	}
+	object, err := w.store.GetObjectRange(w.ctx, w.key, position, request)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	window := make([]byte, request)
+	n, err := io.ReadFull(object, window)
+	w.Requests++
+	w.BytesRead += int64(n)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("at byte %d: %w: read %d of %d bytes", position, ErrTruncated, n, request)
+		}
+		return nil, err
+	}
+	w.window, w.start = window, position
+	return window[:length], nil
+}
@@ -0,0 +1,108 @@
+package segment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"rpksi/pkg/storage"
+	"testing"
+)
+
+// walkerSegment stores a segment of three batches of the uncompressed
+// fixture, each about 67KiB, and returns it.
+func walkerSegment(t *testing.T, store *storage.MemoryStore) []byte {
+	t.Helper()
+	var segment []byte
+	for i := 0; i < 3; i++ {
+		b := fixtureBatch(t, "records.none", CompressionNone)
+		b.BaseOffset += int64(i * fixtureRecords)
+		segment = append(segment, encodeBatch(b)...)
+	}
+	if err := store.PutObject(context.Background(), "segment", bytes.NewReader(segment), int64(len(segment))); err != nil {
+		t.Fatal(err)
+	}
+	return segment
+}
+
+func TestHeaderWalker(t *testing.T) {
+	store := storage.NewMemoryStore()
+	segment := walkerSegment(t, store)
+	w := NewHeaderWalker(context.Background(), store, "segment", int64(len(segment)))
+
+	var batches []*Batch
+	for {
+		batch, err := w.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !batch.HeaderCRCValid || batch.Payload != nil {
+			t.Errorf("batch at %d: header CRC valid %v, payload of %d bytes", batch.Position, batch.HeaderCRCValid, len(batch.Payload))
+		}
+		batches = append(batches, batch)
+	}
+	if len(batches) != 3 || batches[2].BaseOffset != 1000+2*fixtureRecords {
+		t.Fatalf("%d batches", len(batches))
+	}
+	// one read ahead per header, the payloads are skipped
+	if w.Requests != 3 || w.BytesRead != 3*DefaultReadAhead {
+		t.Errorf("%d requests of %d bytes for a segment of %d bytes, want 3 of %d", w.Requests, w.BytesRead, len(segment), 3*DefaultReadAhead)
+	}
+
+	full, err := w.ReadBatch(batches[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !full.CRCValid() || full.Position != batches[1].Position {
+		t.Errorf("batch read with its records: CRC valid %v at %d", full.CRCValid(), full.Position)
+	}
+	records, err := full.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != fixtureRecords || records[0].Offset != 1000+fixtureRecords {
+		t.Errorf("%d records from offset %d", len(records), records[0].Offset)
+	}
+}
+
+func TestHeaderWalkerShortObject(t *testing.T) {
+	store := storage.NewMemoryStore()
+	segment := walkerSegment(t, store)
+	batchSize := int64(len(segment) / 3)
+
+	for _, tt := range []struct {
+		name string
+		// object is what is stored, size what the walker is given
+		object, size int64
+	}{
+		// the last batch ends past the object
+		{"cut in a batch", int64(len(segment)) - 10, int64(len(segment)) - 10},
+		// the last header ends past the object
+		{"cut in a header", 2*batchSize + 10, 2*batchSize + 10},
+		// the object is smaller than the listing said
+		{"smaller than listed", 2*batchSize + 10, int64(len(segment))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := segment[:tt.object]
+			if err := store.PutObject(context.Background(), "segment", bytes.NewReader(data), int64(len(data))); err != nil {
+				t.Fatal(err)
+			}
+			w := NewHeaderWalker(context.Background(), store, "segment", tt.size)
+			for i := 0; i < 2; i++ {
+				if _, err := w.Next(); err != nil {
+					t.Fatalf("batch %d: %v", i, err)
+				}
+			}
+			requests := w.Requests
+			if _, err := w.Next(); !errors.Is(err, ErrTruncated) {
+				t.Errorf("last batch: %v, want %v", err, ErrTruncated)
+			}
+			if w.Requests > requests+1 {
+				t.Errorf("%d requests for the last batch", w.Requests-requests)
+			}
+		})
+	}
+}
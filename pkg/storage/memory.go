@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data         []byte
+	etag         string
+	lastModified time.Time
+}
+
+// MemoryStore is an in-memory ObjectStore, useful for tests and for working
+// against a bucket layout without an S3 endpoint.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: make(map[string]memoryObject)}
+}
+
+func (s *MemoryStore) ListObjects(_ context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var objects []ObjectInfo
+	for key, object := range s.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         int64(len(object.data)),
+			ETag:         object.etag,
+			LastModified: object.lastModified,
+		})
+	}
+	// S3 lists keys in lexicographical order
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+func (s *MemoryStore) StatObject(_ context.Context, key string) (ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(object.data)),
+		ETag:         object.etag,
+		LastModified: object.lastModified,
+	}, nil
+}
+
+func (s *MemoryStore) GetObject(_ context.Context, key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(object.data)), nil
+}
+
+func (s *MemoryStore) GetObjectRange(_ context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if offset < 0 || length <= 0 || offset >= int64(len(object.data)) {
+		return nil, fmt.Errorf("invalid range %d+%d of %s (%d bytes)", offset, length, key, len(object.data))
+	}
+	end := offset + length
+	if end > int64(len(object.data)) {
+		end = int64(len(object.data))
+	}
+	return io.NopCloser(bytes.NewReader(object.data[offset:end])), nil
+}
+
+func (s *MemoryStore) PutObject(_ context.Context, key string, reader io.Reader, _ int64) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, data)
+	return nil
+}
+
+func (s *MemoryStore) PutObjectIfMatch(_ context.Context, key string, reader io.Reader, _ int64, etag string) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if object, ok := s.objects[key]; !ok || object.etag != etag {
+		return ErrPreconditionFailed
+	}
+	s.put(key, data)
+	return nil
+}
+
+func (s *MemoryStore) put(key string, data []byte) {
+	sum := md5.Sum(data)
+	s.objects[key] = memoryObject{
+		data:         data,
+		etag:         hex.EncodeToString(sum[:]),
+		lastModified: time.Now(),
+	}
+}
+
+func (s *MemoryStore) RemoveObject(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
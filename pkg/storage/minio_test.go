@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var _ ConditionalPutter = (*MinioStore)(nil)
+
+// s3Stub serves the S3 requests made by MinioStore for a single bucket,
+// honouring If-Match on puts unless ignoreIfMatch is set.
+type s3Stub struct {
+	mu            sync.Mutex
+	objects       map[string][]byte
+	ifMatch       []string // If-Match header of each put, empty when unset
+	ignoreIfMatch bool
+	// beforePut is called before a put is handled, outside of the lock.
+	beforePut func(key string)
+}
+
+func newS3Stub(t *testing.T) (*s3Stub, *MinioStore) {
+	t.Helper()
+	stub := &s3Stub{objects: make(map[string][]byte)}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	store, err := NewMinioStore(strings.TrimPrefix(server.URL, "http://"), "access", "secret", false, "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stub, store
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>`+code+`</Code><Message>`+code+`</Message></Error>`)
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.URL.Query()["location"]; ok {
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch r.Method {
+	case http.MethodHead:
+		s.mu.Lock()
+		data, ok := s.objects[key]
+		s.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"`+etagOf(data)+`"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", "Fri, 27 May 2022 00:00:00 GMT")
+	case http.MethodPut:
+		data, err := readPutBody(r)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		if s.beforePut != nil {
+			s.beforePut(key)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ifMatch := r.Header.Get("If-Match")
+		s.ifMatch = append(s.ifMatch, ifMatch)
+		if len(ifMatch) > 0 && !s.ignoreIfMatch {
+			current, ok := s.objects[key]
+			if !ok {
+				s3Error(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			if ifMatch != `"`+etagOf(current)+`"` {
+				s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+				return
+			}
+		}
+		s.objects[key] = data
+		w.Header().Set("ETag", `"`+etagOf(data)+`"`)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// readPutBody reads the body of a put, decoding the aws-chunked encoding
+// minio-go uses for signed uploads over plain HTTP.
+func readPutBody(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var data []byte
+	body := bufio.NewReader(r.Body)
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(body, chunk); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func (s *s3Stub) put(key string, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = []byte(data)
+}
+
+func (s *s3Stub) object(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.objects[key])
+}
+
+func (s *s3Stub) lastIfMatch() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ifMatch...)
+}
+
+func putString(ctx context.Context, store *MinioStore, key, data, etag string) error {
+	return store.PutObjectIfMatch(ctx, key, bytes.NewReader([]byte(data)), int64(len(data)), etag)
+}
+
+func TestMinioStorePutObjectIfMatch(t *testing.T) {
+	ctx := context.Background()
+	stub, store := newS3Stub(t)
+	if err := store.PutObject(ctx, "manifest.json", strings.NewReader("v1"), 2); err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.StatObject(ctx, "manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ETag != etagOf([]byte("v1")) {
+		t.Fatalf("etag %q, want %q", info.ETag, etagOf([]byte("v1")))
+	}
+
+	if err := putString(ctx, store, "manifest.json", "v2", info.ETag); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.object("manifest.json"); got != "v2" {
+		t.Errorf("object %q after a matching put, want v2", got)
+	}
+	// only the conditional put is sent with If-Match, quoted
+	if got := stub.lastIfMatch(); len(got) != 2 || got[0] != "" || got[1] != `"`+info.ETag+`"` {
+		t.Errorf("If-Match headers %q", got)
+	}
+
+	// an ETag that no longer matches is refused before anything is sent
+	if err := putString(ctx, store, "manifest.json", "v3", info.ETag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("put with a stale etag: %v, want %v", err, ErrPreconditionFailed)
+	}
+	if err := putString(ctx, store, "missing.json", "v1", info.ETag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("put of a missing object: %v, want %v", err, ErrPreconditionFailed)
+	}
+	if got := stub.lastIfMatch(); len(got) != 2 {
+		t.Errorf("%d puts sent, want 2", len(got))
+	}
+	if got := stub.object("manifest.json"); got != "v2" {
+		t.Errorf("object %q after refused puts, want v2", got)
+	}
+}
+
+func TestMinioStorePutObjectIfMatchRace(t *testing.T) {
+	ctx := context.Background()
+	stub, store := newS3Stub(t)
+	stub.put("manifest.json", "v1")
+	etag := etagOf([]byte("v1"))
+
+	// the archiver writes the manifest between the check and the put
+	stub.beforePut = func(key string) { stub.put(key, "archiver") }
+	if err := putString(ctx, store, "manifest.json", "v2", etag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("racing put: %v, want %v", err, ErrPreconditionFailed)
+	}
+	if got := stub.object("manifest.json"); got != "archiver" {
+		t.Errorf("object %q, want the archiver's", got)
+	}
+
+	// or deletes it
+	stub.put("manifest.json", "v1")
+	stub.beforePut = func(key string) {
+		stub.mu.Lock()
+		delete(stub.objects, key)
+		stub.mu.Unlock()
+	}
+	if err := putString(ctx, store, "manifest.json", "v2", etag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("put of a deleted object: %v, want %v", err, ErrPreconditionFailed)
+	}
+
+	// servers ignoring If-Match only get the check before the put
+	stub.put("manifest.json", "v1")
+	stub.beforePut = nil
+	stub.ignoreIfMatch = true
+	if err := putString(ctx, store, "manifest.json", "v2", etag); err != nil {
+		t.Fatal(err)
+	}
+	if err := putString(ctx, store, "manifest.json", "v3", etag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("put with a stale etag: %v, want %v", err, ErrPreconditionFailed)
+	}
+}
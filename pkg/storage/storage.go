@@ -0,0 +1,51 @@
+// Package storage provides access to the S3-compatible bucket where Redpanda
+// archives topic segments and partition manifests.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when the requested object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ErrPreconditionFailed is returned by a conditional put when the object no
+// longer has the expected ETag.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ObjectInfo describes a single object in the bucket.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// ObjectStore is the set of bucket operations needed by rpksi.
+type ObjectStore interface {
+	// ListObjects recursively lists every object whose key starts with prefix.
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// StatObject returns the metadata of a single object.
+	StatObject(ctx context.Context, key string) (ObjectInfo, error)
+	// GetObject opens the object for reading. The caller must close the reader.
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetObjectRange opens length bytes of the object starting at offset,
+	// which must be within the object. A range past the end of the object is
+	// cut short. The caller must close the reader.
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// PutObject creates or overwrites the object with the contents of reader.
+	// A size of -1 means the size is unknown.
+	PutObject(ctx context.Context, key string, reader io.Reader, size int64) error
+	// RemoveObject deletes the object. Removing a missing object is not an error.
+	RemoveObject(ctx context.Context, key string) error
+}
+
+// ConditionalPutter is implemented by stores that support If-Match on puts.
+type ConditionalPutter interface {
+	// PutObjectIfMatch writes the object only if its current ETag equals
+	// etag, otherwise it returns ErrPreconditionFailed.
+	PutObjectIfMatch(ctx context.Context, key string, reader io.Reader, size int64, etag string) error
+}